@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/profile"
+	"github.com/spf13/cobra"
+	"github.com/will-rowe/hulk/src/evaluate"
+	"github.com/will-rowe/hulk/src/helpers"
+	"github.com/will-rowe/hulk/src/histosketch"
+	"github.com/will-rowe/hulk/src/kmerspectrum"
+	"github.com/will-rowe/hulk/src/sketchio"
+	"github.com/will-rowe/hulk/src/version"
+)
+
+// the command line arguments
+var (
+	evalDir         *string // a directory containing the sketches to evaluate
+	evalRecursive   *bool   // recursively search the supplied directory
+	evalSketchSizes *[]uint // the histosketch sizes to evaluate
+	evalReplicates  *int    // the number of replicate histosketches made of each spectrum at each size
+	evalSeed        *int64  // the seed for the first replicate
+)
+
+// evaluateCmd is used by cobra
+var evaluateCmd = &cobra.Command{
+	Use:   "evaluate [sketch files]",
+	Short: "Evaluate the histosketch similarity estimates against the exact similarity of saved k-mer spectra",
+	Long: `
+		Evaluate the histosketch similarity estimates against the exact similarity of saved k-mer spectra.
+
+		This subcommand takes sketches that contain a saved k-mer spectrum (made with hulk sketch --spectrum)
+		and calculates the exact weighted jaccard similarity between every pair of spectra. It then
+		histosketches each spectrum at each of the requested sketch sizes, using a different seed for each
+		replicate, and compares the hulk smash estimates (the jaccard and weightedjaccard metrics) with the
+		exact similarities.
+
+		The bias, variance and root mean squared error of the estimates at each sketch size are written to
+		<outFile>.hulk-evaluate.tsv, and the estimates for each pair of sketches are written to
+		<outFile>.hulk-evaluate-pairs.tsv. The histosketches use the count-min sketch settings recorded in
+		the first sketch.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runEvaluate(args)
+	},
+}
+
+// init the command line arguments
+func init() {
+	evalDir = evaluateCmd.Flags().StringP("sketchDir", "d", "", "a directory containing the sketches to evaluate")
+	evalRecursive = evaluateCmd.Flags().Bool("recursive", false, "recursively search the supplied sketch directory (-d)")
+	evalSketchSizes = evaluateCmd.Flags().UintSlice("sketchSizes", []uint{64, 128, 256, 512, 1024}, "the histosketch sizes to evaluate")
+	evalReplicates = evaluateCmd.Flags().Int("replicates", 10, "the number of independent histosketches (seeds) made of each spectrum at each sketch size")
+	evalSeed = evaluateCmd.Flags().Int64("seed", histosketch.DISTRIBUTION_SEED, "the histosketch seed for the first replicate (each replicate adds 1 to the seed)")
+	RootCmd.AddCommand(evaluateCmd)
+}
+
+// runEvaluate is the main function for this subcommand
+func runEvaluate(sketchFiles []string) {
+
+	// set up cpu profiling
+	if *profiling == true {
+		defer profile.Start(profile.ProfilePath("./")).Stop()
+	}
+
+	// set up the log
+	if *logFile != "" {
+		logFH := helpers.StartLogging(*logFile)
+		defer logFH.Close()
+		log.SetOutput(logFH)
+	} else {
+		// normal behaviour is to print the log to STDOUT
+		log.SetOutput(os.Stdout)
+	}
+
+	// start the evaluate subcommand
+	log.Printf("this is hulk (version %s)\n", version.VERSION)
+	log.Printf("starting the evaluate subcommand\n")
+
+	// check the parameters and collect the sketch files
+	log.Printf("checking parameters and collecting sketches...\n")
+	if len(*evalSketchSizes) == 0 {
+		helpers.ErrorCheck(fmt.Errorf("please supply at least one sketch size (--sketchSizes)"))
+	}
+	for _, sketchSize := range *evalSketchSizes {
+		if sketchSize == 0 {
+			helpers.ErrorCheck(fmt.Errorf("sketch sizes must be greater than 0"))
+		}
+	}
+	if *evalReplicates < 1 {
+		helpers.ErrorCheck(fmt.Errorf("--replicates must be at least 1"))
+	}
+	if *proc <= 0 || *proc > runtime.NumCPU() {
+		*proc = runtime.NumCPU()
+	}
+	runtime.GOMAXPROCS(*proc)
+	if *evalDir != "" {
+		helpers.ErrorCheck(helpers.CheckDir(*evalDir))
+		jsonFiles, err := helpers.CollectJSONs(strings.TrimSuffix(*evalDir, "/")+"/", *evalRecursive)
+		helpers.ErrorCheck(err)
+		sketchFiles = append(sketchFiles, jsonFiles...)
+	}
+	sort.Strings(sketchFiles)
+	if len(sketchFiles) < 2 {
+		helpers.ErrorCheck(fmt.Errorf("%d sketches supplied, HULK needs at least 2 to evaluate", len(sketchFiles)))
+	}
+
+	// load the saved spectra, using the count-min sketch settings of the first sketch for the histosketches
+	spectra := make([]*kmerspectrum.SavedSpectrum, len(sketchFiles))
+	settings := evaluate.Settings{
+		SketchSizes: *evalSketchSizes,
+		Replicates:  *evalReplicates,
+		Seed:        *evalSeed,
+		NumWorkers:  *proc,
+	}
+	for i, sketchFile := range sketchFiles {
+		hulkData, err := sketchio.LoadHULKdata(sketchFile)
+		helpers.ErrorCheck(err)
+		sketchObj, err := hulkData.FindSketch(*kmerSize, "spectrum")
+		if err != nil {
+			helpers.ErrorCheck(fmt.Errorf("%v (was it sketched with hulk sketch --spectrum?)", err))
+		}
+		spectra[i] = sketchObj.(*kmerspectrum.SavedSpectrum)
+		if i == 0 && hulkData.Metadata.CountMin != nil {
+			settings.CountMin = histosketch.CountMinSettings{
+				Width:        hulkData.Metadata.CountMin.Width,
+				Depth:        hulkData.Metadata.CountMin.Depth,
+				Conservative: hulkData.Metadata.CountMin.Conservative,
+			}
+		}
+	}
+	log.Printf("\tk-mer size: %d\n", *kmerSize)
+	log.Printf("\tnumber of spectra: %d (%d pairs)\n", len(spectra), len(spectra)*(len(spectra)-1)/2)
+	log.Printf("\tsketch sizes: %v\n", *evalSketchSizes)
+	log.Printf("\treplicates: %d (seeds %d-%d)\n", *evalReplicates, *evalSeed, *evalSeed+int64(*evalReplicates-1))
+
+	// run the evaluation
+	log.Printf("evaluating...\n")
+	pairs, summaries, err := evaluate.Evaluate(spectra, settings)
+	helpers.ErrorCheck(err)
+	for _, summary := range summaries {
+		log.Printf("\t%v (sketch size %d): bias %.4f, variance %.6f, RMSE %.4f\n", summary.Metric, summary.SketchSize, summary.Bias, summary.Variance, summary.RMSE)
+	}
+
+	// write the results
+	summaryRows := [][]string{{"metric", "sketch_size", "pairs", "replicates", "bias", "variance", "rmse"}}
+	for _, summary := range summaries {
+		summaryRows = append(summaryRows, []string{summary.Metric, strconv.Itoa(int(summary.SketchSize)), strconv.Itoa(summary.Pairs), strconv.Itoa(summary.Replicates), formatEstimate(summary.Bias), formatEstimate(summary.Variance), formatEstimate(summary.RMSE)})
+	}
+	helpers.ErrorCheck(writeTSV(*outFile+".hulk-evaluate.tsv", summaryRows))
+	log.Printf("\twritten summary to disk: %v\n", *outFile+".hulk-evaluate.tsv")
+	pairRows := [][]string{{"sketch_a", "sketch_b", "metric", "sketch_size", "exact", "mean_estimate", "variance"}}
+	for _, pair := range pairs {
+		mean, variance := pair.MeanVariance()
+		pairRows = append(pairRows, []string{sketchFiles[pair.A], sketchFiles[pair.B], pair.Metric, strconv.Itoa(int(pair.SketchSize)), formatEstimate(pair.Exact), formatEstimate(mean), formatEstimate(variance)})
+	}
+	helpers.ErrorCheck(writeTSV(*outFile+".hulk-evaluate-pairs.tsv", pairRows))
+	log.Printf("\twritten pairwise estimates to disk: %v\n", *outFile+".hulk-evaluate-pairs.tsv")
+	log.Printf("finished")
+}
+
+// formatEstimate is a helper function to convert an evaluation value to a string
+func formatEstimate(value float64) string {
+	return strconv.FormatFloat(value, 'f', 6, 64)
+}
+
+// writeTSV is a helper function to write a set of rows to a TSV file
+func writeTSV(fileName string, rows [][]string) error {
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(fh)
+	writer.Comma = '\t'
+	if err := writer.WriteAll(rows); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
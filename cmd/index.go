@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pkg/profile"
+	"github.com/spf13/cobra"
+	"github.com/will-rowe/hulk/src/helpers"
+	"github.com/will-rowe/hulk/src/lshforest"
+	"github.com/will-rowe/hulk/src/sketchio"
+	"github.com/will-rowe/hulk/src/version"
+)
+
+// the command line arguments
+var (
+	indexFile      *string // the index file to create or add to
+	indexDir       *string // a directory containing sketches to index
+	indexRecursive *bool   // recursively search the supplied directory
+	indexAlgo      *string // which sketching algorithm to index
+	indexTrees     *int    // the number of trees in the LSH Forest
+)
+
+// indexCmd is used by cobra
+var indexCmd = &cobra.Command{
+	Use:   "index [sketch files]",
+	Short: "Index sketches so that similar samples can be found with hulk search",
+	Long: `
+		Index sketches so that similar samples can be found with hulk search.
+
+		This subcommand builds an LSH Forest index of sketches, which hulk search can use to find the most
+		similar samples to a query sketch without comparing it to every indexed sketch. The sketches are
+		collected from the supplied sketch files and/or a sketch directory (-d).
+
+		If the index file already exists, the sketches are added to it. Sketches that are already in the
+		index are skipped, unless the sketch file has changed since it was indexed. All of the sketches
+		must have been made with the same k-mer size, sketch size, seeds and hash function.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runIndex(cmd, args)
+	},
+}
+
+// init the command line arguments
+func init() {
+	indexFile = indexCmd.Flags().StringP("index", "i", "", "the index file to create or add to (default <outFile>.hulk-index)")
+	indexDir = indexCmd.Flags().StringP("sketchDir", "d", "", "a directory containing sketches to index")
+	indexRecursive = indexCmd.Flags().Bool("recursive", false, "recursively search the supplied sketch directory (-d)")
+	indexAlgo = indexCmd.Flags().StringP("algorithm", "a", "histosketch", fmt.Sprintf("tells HULK which sketching algorithm to index %v", sketchio.IndexAlgorithms))
+	indexTrees = indexCmd.Flags().Int("trees", lshforest.DEFAULT_TREES, "number of trees in the LSH Forest (more trees find more of the similar samples, but use more memory)")
+	RootCmd.AddCommand(indexCmd)
+}
+
+// runIndex is the main function for this subcommand
+func runIndex(cmd *cobra.Command, sketchFiles []string) {
+
+	// set up cpu profiling
+	if *profiling == true {
+		defer profile.Start(profile.ProfilePath("./")).Stop()
+	}
+
+	// set up the log
+	if *logFile != "" {
+		logFH := helpers.StartLogging(*logFile)
+		defer logFH.Close()
+		log.SetOutput(logFH)
+	} else {
+		// normal behaviour is to print the log to STDOUT
+		log.SetOutput(os.Stdout)
+	}
+
+	// start the index subcommand
+	log.Printf("this is hulk (version %s)\n", version.VERSION)
+	log.Printf("starting the index subcommand\n")
+
+	// collect the sketch files
+	log.Printf("checking parameters and collecting sketches...\n")
+	if *indexDir != "" {
+		helpers.ErrorCheck(helpers.CheckDir(*indexDir))
+		jsonFiles, err := helpers.CollectJSONs(strings.TrimSuffix(*indexDir, "/")+"/", *indexRecursive)
+		helpers.ErrorCheck(err)
+		sketchFiles = append(sketchFiles, jsonFiles...)
+	}
+	if len(sketchFiles) == 0 {
+		helpers.ErrorCheck(fmt.Errorf("no sketches to index, please supply sketch files and/or a sketch directory (-d)"))
+	}
+	if *indexFile == "" {
+		*indexFile = *outFile + ".hulk-index"
+	}
+
+	// open the existing index, or start a new one
+	var index *sketchio.SketchIndex
+	if _, err := os.Stat(*indexFile); err == nil {
+		index, err = sketchio.LoadSketchIndex(*indexFile)
+		helpers.ErrorCheck(err)
+		settings := index.Settings
+		if cmd.Flags().Changed("algorithm") && *indexAlgo != settings.Algorithm {
+			helpers.ErrorCheck(fmt.Errorf("can't add %v sketches to an index of %v sketches", *indexAlgo, settings.Algorithm))
+		}
+		if cmd.Flags().Changed("kmerSize") && *kmerSize != settings.KmerSize {
+			helpers.ErrorCheck(fmt.Errorf("can't add k=%d sketches to an index of k=%d sketches", *kmerSize, settings.KmerSize))
+		}
+		if cmd.Flags().Changed("trees") && *indexTrees != settings.Trees {
+			helpers.ErrorCheck(fmt.Errorf("can't change the number of trees in an existing index (%d)", settings.Trees))
+		}
+		log.Printf("\tadding to existing index: %v (%d sketches)\n", *indexFile, index.Size())
+	} else {
+		index, err = sketchio.NewSketchIndex(*indexAlgo, *kmerSize, *indexTrees, version.VERSION)
+		helpers.ErrorCheck(err)
+		log.Printf("\tcreating new index: %v\n", *indexFile)
+	}
+	log.Printf("\talgorithm: %v\n", index.Settings.Algorithm)
+	log.Printf("\tk-mer size: %d\n", index.Settings.KmerSize)
+	log.Printf("\tnumber of trees: %d\n", index.Settings.Trees)
+	log.Printf("\tnumber of sketch files: %d\n", len(sketchFiles))
+
+	// add the sketches
+	log.Printf("indexing sketches...\n")
+	added, skipped := 0, 0
+	for _, sketchFile := range sketchFiles {
+		loadedSketch, err := sketchio.LoadHULKdata(sketchFile)
+		helpers.ErrorCheck(err)
+		ok, err := index.Add(sketchFile, loadedSketch)
+		if err != nil {
+			helpers.ErrorCheck(fmt.Errorf("can't index %v: %v", sketchFile, err))
+		}
+		if ok {
+			added++
+		} else {
+			skipped++
+		}
+	}
+	log.Printf("\tadded %d sketches (%d were already indexed)\n", added, skipped)
+
+	// write the index
+	helpers.ErrorCheck(index.Write(*indexFile))
+	log.Printf("\twritten index to disk: %v (%d sketches)\n", *indexFile, index.Size())
+	log.Printf("finished")
+}
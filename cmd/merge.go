@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/pkg/profile"
+	"github.com/spf13/cobra"
+	"github.com/will-rowe/hulk/src/helpers"
+	"github.com/will-rowe/hulk/src/sketchio"
+	"github.com/will-rowe/hulk/src/version"
+)
+
+// mergeCmd is used by cobra
+var mergeCmd = &cobra.Command{
+	Use:   "merge [sketch files]",
+	Short: "Merge sketches from separate runs into a single sketch",
+	Long: `
+		Merge sketches from separate runs into a single sketch.
+
+		This subcommand combines sketches of the same sample that were made separately (e.g. from
+		different sequencing lanes or flowcells), so that the reads don't need to be sketched again.
+		The sketches must have been made with the same k-mer size, sketch size, seeds and hash function.
+		Each sketching algorithm found in all of the sketches is merged and the merged sketches are recorded.
+		KMV, KHF and HyperMinHash sketches and saved spectra are merged exactly. A histosketch only keeps the minimum
+		weight in each slot, so histosketches are rebuilt from the merged saved spectra (made with hulk sketch --spectrum),
+		giving a histosketch of all the reads. Histosketches are skipped if any of the sketches don't have a saved spectrum.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runMerge(args)
+	},
+}
+
+// init the command line arguments
+func init() {
+	RootCmd.AddCommand(mergeCmd)
+}
+
+// runMerge is the main function for this subcommand
+func runMerge(sketchFiles []string) {
+
+	// set up cpu profiling
+	if *profiling == true {
+		defer profile.Start(profile.ProfilePath("./")).Stop()
+	}
+
+	// set up the log
+	if *logFile != "" {
+		logFH := helpers.StartLogging(*logFile)
+		defer logFH.Close()
+		log.SetOutput(logFH)
+	} else {
+		// normal behaviour is to print the log to STDOUT
+		log.SetOutput(os.Stdout)
+	}
+
+	// start the merge subcommand
+	log.Printf("this is hulk (version %s)\n", version.VERSION)
+	log.Printf("starting the merge subcommand\n")
+
+	// load the sketches
+	log.Printf("collecting sketches...\n")
+	hulkDatas := make([]*sketchio.HULKdata, len(sketchFiles))
+	for i, sketchFile := range sketchFiles {
+		loadedSketch, err := sketchio.LoadHULKdata(sketchFile)
+		helpers.ErrorCheck(err)
+		hulkDatas[i] = loadedSketch
+		log.Printf("\t%v (%d reads)\n", sketchFile, loadedSketch.ReadCount)
+	}
+
+	// merge them
+	log.Printf("merging %d sketches...\n", len(hulkDatas))
+	merged, skipped, err := sketchio.MergeHULKdata(sketchFiles, hulkDatas)
+	helpers.ErrorCheck(err)
+	for _, sig := range merged.Signatures {
+		log.Printf("\tmerged %v sketches\n", sig.Algorithm)
+	}
+	for _, reason := range skipped {
+		log.Printf("\tskipped: %v\n", reason)
+	}
+
+	// write the merged sketch
+	helpers.ErrorCheck(merged.WriteJSON(*outFile + ".json"))
+	log.Printf("\twritten merged sketch to disk: %v\n", *outFile+".json")
+	log.Printf("finished")
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/pkg/profile"
+	"github.com/spf13/cobra"
+	"github.com/will-rowe/hulk/src/helpers"
+	"github.com/will-rowe/hulk/src/sketchio"
+	"github.com/will-rowe/hulk/src/version"
+)
+
+// the command line arguments
+var (
+	searchIndex *string // the index file to search
+	topK        *int    // the number of similar samples to return for each query
+)
+
+// searchCmd is used by cobra
+var searchCmd = &cobra.Command{
+	Use:   "search [query sketch files]",
+	Short: "Search an index for the samples most similar to query sketches",
+	Long: `
+		Search an index for the samples most similar to query sketches.
+
+		This subcommand uses an index made by hulk index to find the indexed samples that are most similar
+		to each query sketch. The top-k samples for each query are written to a TSV file, along with their
+		estimated similarity (the same estimate used by hulk smash with the jaccard metric).
+
+		The LSH Forest compares a query to the indexed sketches that share the most slots with it first,
+		falling back to less similar sketches until k samples are found (or the index runs out of samples).`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runSearch(args)
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return helpers.CheckRequiredFlags(cmd.Flags())
+	},
+}
+
+// init the command line arguments
+func init() {
+	searchIndex = searchCmd.Flags().StringP("index", "i", "", "the index file to search (made by hulk index)")
+	topK = searchCmd.Flags().Int("topK", 10, "the number of similar samples to return for each query")
+	searchCmd.MarkFlagRequired("index")
+	RootCmd.AddCommand(searchCmd)
+}
+
+// runSearch is the main function for this subcommand
+func runSearch(queryFiles []string) {
+
+	// set up cpu profiling
+	if *profiling == true {
+		defer profile.Start(profile.ProfilePath("./")).Stop()
+	}
+
+	// set up the log
+	if *logFile != "" {
+		logFH := helpers.StartLogging(*logFile)
+		defer logFH.Close()
+		log.SetOutput(logFH)
+	} else {
+		// normal behaviour is to print the log to STDOUT
+		log.SetOutput(os.Stdout)
+	}
+
+	// start the search subcommand
+	log.Printf("this is hulk (version %s)\n", version.VERSION)
+	log.Printf("starting the search subcommand\n")
+
+	// load the index
+	log.Printf("checking parameters and loading the index...\n")
+	if *topK < 1 {
+		helpers.ErrorCheck(fmt.Errorf("--topK must be at least 1"))
+	}
+	helpers.ErrorCheck(helpers.CheckFile(*searchIndex))
+	index, err := sketchio.LoadSketchIndex(*searchIndex)
+	helpers.ErrorCheck(err)
+	log.Printf("\tindex: %v (%d sketches)\n", *searchIndex, index.Size())
+	log.Printf("\talgorithm: %v\n", index.Settings.Algorithm)
+	log.Printf("\tk-mer size: %d\n", index.Settings.KmerSize)
+	log.Printf("\ttop-k: %d\n", *topK)
+	log.Printf("\tnumber of queries: %d\n", len(queryFiles))
+
+	// create the results outfile
+	resultsFile, err := os.Create(*outFile + ".hulk-search.tsv")
+	helpers.ErrorCheck(err)
+	defer resultsFile.Close()
+	resultsWriter := csv.NewWriter(resultsFile)
+	resultsWriter.Comma = '\t'
+	defer resultsWriter.Flush()
+	helpers.ErrorCheck(resultsWriter.Write([]string{"query", "rank", "match", "filename", "similarity"}))
+
+	// search for each query
+	log.Printf("searching...\n")
+	for _, queryFile := range queryFiles {
+		query, err := sketchio.LoadHULKdata(queryFile)
+		helpers.ErrorCheck(err)
+		hits, err := index.Search(query, *topK)
+		if err != nil {
+			helpers.ErrorCheck(fmt.Errorf("can't search for %v: %v", queryFile, err))
+		}
+		log.Printf("\t%v: %d matches\n", queryFile, len(hits))
+		for rank, hit := range hits {
+			helpers.ErrorCheck(resultsWriter.Write([]string{queryFile, strconv.Itoa(rank + 1), hit.Key, hit.FileName, strconv.FormatFloat(hit.Similarity, 'f', 4, 64)}))
+		}
+	}
+	log.Printf("\twritten search results to disk: %v\n", *outFile+".hulk-search.tsv")
+	log.Printf("finished")
+}
@@ -3,30 +3,61 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/pkg/profile"
 	"github.com/spf13/cobra"
+	"github.com/will-rowe/hulk/src/countmin"
 	"github.com/will-rowe/hulk/src/helpers"
+	"github.com/will-rowe/hulk/src/histosketch"
+	"github.com/will-rowe/hulk/src/minimizer"
 	"github.com/will-rowe/hulk/src/pipeline"
 	"github.com/will-rowe/hulk/src/version"
 )
 
 // the command line arguments
 var (
-	fastq       *[]string // list of FASTQ files to sketch
-	fasta       *bool     // tells HULK that the input file is actually in FASTA format
-	windowSize  *uint     // minimizer window size [2/3 of k-mer length]. A minimizer is the smallest k-mer in a window of w consecutive k-mers.
-	interval    *uint     // size of k-mer sampling interval (0 == no interval)
-	sketchSize  *uint     // size of sketch
-	decayRatio  *float64  // the decay ratio used for concept drift (1.00 = concept drift disabled)
-	streaming   *bool     // writes the sketches to STDOUT (as well as to disk)
-	bannerLabel *string   // adds a label to the saved sketch, for use with banner
-	addKHF      *bool     // HULK will also produce a MinHash KHF sketch
-	addKMV      *bool     // HULK will also produce a MinHash KMV sketch
+	fastq       *[]string      // list of FASTQ files to sketch
+	r1          *[]string      // list of R1 FASTQ files for paired-end data
+	r2          *[]string      // list of R2 FASTQ files for paired-end data (same order as r1)
+	interleaved *bool          // tells HULK that the FASTQ input contains interleaved paired-end reads
+	fasta       *bool          // deprecated: the sequence format is now detected from the input
+	windowSize  *uint          // minimizer window size [2/3 of k-mer length]. A minimizer is the smallest k-mer in a window of w consecutive k-mers.
+	seeder      *string        // the method used to select seeds from the sequences
+	smerSize    *uint          // the s-mer size for syncmers (0 = k-w)
+	syncOffset  *int           // the position of the smallest s-mer in an open syncmer (-1 = middle of the k-mer)
+	strobeMin   *uint          // the start of the randstrobe window (0 = k)
+	strobeMax   *uint          // the end of the randstrobe window (0 = 2k)
+	hashFunc    *string        // the function used to hash k-mers
+	hashSeed    *uint64        // the seed for the hash function
+	interval    *uint          // number of reads to process between sketch snapshots (0 == no interval)
+	window      *uint          // only sketch the intervals in the last N reads (0 = no read limit)
+	windowTime  *time.Duration // only sketch the intervals in the last T (0 = no time limit)
+	sketchSize  *uint          // size of sketch
+	decayRatio  *float64       // the decay ratio used for concept drift (1.00 = concept drift disabled)
+	seed        *int64         // the seed for the histosketch consistent weighted samples
+	cmWidth     *uint          // the number of counters in each table of the histosketch count-min sketch (0 = default)
+	cmFit       *bool          // use a counter per k-mer spectrum bin in each table of the histosketch count-min sketch
+	cmDepth     *uint          // the number of tables in the histosketch count-min sketch (0 = default)
+	cmConserve  *bool          // use conservative update for the histosketch count-min sketch
+	streaming   *bool          // writes the sketches to STDOUT as newline-delimited JSON (as well as to disk)
+	bannerLabel *string        // adds a label to the saved sketch, for use with banner
+	addKHF      *bool          // HULK will also produce a MinHash KHF sketch
+	addKMV      *bool          // HULK will also produce a MinHash KMV sketch
+	addHMH      *bool          // HULK will also produce a HyperMinHash sketch
+	addSpectrum *bool          // HULK will also save the k-mer spectrum
+	minQual     *int           // quality trim reads to this Phred score (0 = no trimming)
+	minLength   *uint          // drop sequences with reads shorter than this after trimming (0 = no minimum)
+	maxLength   *uint          // drop sequences with reads longer than this (0 = no maximum)
+	maxN        *float64       // drop sequences with reads containing a greater fraction of ambiguous bases than this (1.0 = no maximum)
+	manifest    *string        // a TSV manifest of samples, each of which is sketched separately
+	checkpoint  *string        // save the sketching state to this file once all the reads have been sketched
+	resume      *string        // resume sketching from the state saved in this checkpoint file
 )
 
 // sketchCmd is used by cobra
@@ -48,15 +79,42 @@ var sketchCmd = &cobra.Command{
 // init the command line arguments
 func init() {
 	fastq = sketchCmd.Flags().StringSliceP("fastq", "f", []string{}, "FASTQ file(s) to sketch (can also pipe in STDIN)")
+	r1 = sketchCmd.Flags().StringSlice("r1", []string{}, "R1 FASTQ file(s) for paired-end data (use with --r2)")
+	r2 = sketchCmd.Flags().StringSlice("r2", []string{}, "R2 FASTQ file(s) for paired-end data, in the same order as --r1")
+	manifest = sketchCmd.Flags().StringP("manifest", "m", "", "TSV manifest of samples to sketch, writing one sketch per sample (header needs sample and files (or r1 and r2) columns, plus optional label and metadata columns)")
+	interleaved = sketchCmd.Flags().Bool("interleaved", false, "tells HULK that the FASTQ input (file(s) or STDIN) contains interleaved paired-end reads")
 	fasta = sketchCmd.Flags().Bool("fasta", false, "tells HULK that the input file is actually FASTA format (.fna/.fasta/.fa), not FASTQ (experimental feature)")
+	sketchCmd.Flags().MarkDeprecated("fasta", "FASTA/FASTQ format and compression (gzip/bzip2/zstd) are now detected from the input")
 	windowSize = sketchCmd.Flags().UintP("windowSize", "w", 9, "minimizer window size")
-	interval = sketchCmd.Flags().UintP("interval", "i", 0, "size of k-mer sampling interval (default 0 (= no interval))")
+	seeder = sketchCmd.Flags().String("seeder", "minimizer", fmt.Sprintf("method used to select seeds from the sequences %v", minimizer.SEEDERS))
+	smerSize = sketchCmd.Flags().Uint("smerSize", 0, "s-mer size for syncmers (default 0 (= k-w))")
+	syncOffset = sketchCmd.Flags().Int("syncmerOffset", -1, "position of the smallest s-mer in an open syncmer (default -1 (= middle of the k-mer))")
+	strobeMin = sketchCmd.Flags().Uint("strobeMin", 0, "start of the window for the second randstrobe, as an offset from the first (default 0 (= k))")
+	strobeMax = sketchCmd.Flags().Uint("strobeMax", 0, "end of the window for the second randstrobe, as an offset from the first (default 0 (= 2k))")
+	hashFunc = sketchCmd.Flags().String("hash", "hash64", fmt.Sprintf("function used to hash the canonical k-mers %v", minimizer.HASH_FUNCS))
+	hashSeed = sketchCmd.Flags().Uint64("hashSeed", 0, "seed for the hash function (ntHash only)")
+	interval = sketchCmd.Flags().UintP("interval", "i", 0, "number of reads to process between sketch snapshots, each snapshot is written to disk (default 0 (= no interval))")
+	window = sketchCmd.Flags().Uint("window", 0, "only sketch the last N reads, expiring older intervals from the sketch (must be a multiple of --interval) (default 0 (= no window))")
+	windowTime = sketchCmd.Flags().Duration("windowTime", 0, "only sketch the intervals from the last T (e.g. 30m), expiring older intervals from the sketch (default 0 (= no window))")
 	sketchSize = sketchCmd.Flags().UintP("sketchSize", "s", 50, "size of sketch")
 	decayRatio = sketchCmd.Flags().Float64P("decayRatio", "x", 1.0, "decay ratio used for concept drift (1.0 = concept drift disabled)")
-	streaming = sketchCmd.Flags().Bool("stream", false, "prints the sketches to STDOUT after every interval is reached, whilst still writting them to disk (log file is redirected to disk))")
+	seed = sketchCmd.Flags().Int64("seed", histosketch.DISTRIBUTION_SEED, "seed for the histosketch (only sketches made with the same seed can be compared, different seeds give independent replicate sketches)")
+	cmWidth = sketchCmd.Flags().Uint("cmWidth", 0, fmt.Sprintf("number of counters in each table of the count-min sketch used to estimate k-mer spectrum frequencies for histosketching (default 0 (= %d))", defaultWidth()))
+	cmFit = sketchCmd.Flags().Bool("cmFitSpectrum", false, "use a counter per k-mer spectrum bin in each table of the count-min sketch, which avoids most collisions but is much larger (e.g. 194481 counters per table for k=21)")
+	cmDepth = sketchCmd.Flags().Uint("cmDepth", 0, fmt.Sprintf("number of tables in the count-min sketch (default 0 (= %d))", defaultDepth()))
+	cmConserve = sketchCmd.Flags().Bool("cmConservative", false, "use conservative update for the count-min sketch, which reduces the over-estimation of k-mer spectrum frequencies")
+	streaming = sketchCmd.Flags().Bool("stream", false, "prints the sketches to STDOUT as newline-delimited JSON after every interval is reached, whilst still writting them to disk (log file is redirected to disk)")
 	bannerLabel = sketchCmd.Flags().StringP("bannerLabel", "b", "blank", "adds a label to the sketch object, for use with BANNER")
 	addKHF = sketchCmd.Flags().Bool("khf", false, "also generate a MinHash K-Hash Functions sketch")
 	addKMV = sketchCmd.Flags().Bool("kmv", false, "also generate a MinHash K-Minimum Values (bottom-k) sketch")
+	addHMH = sketchCmd.Flags().Bool("hyperminhash", false, "also generate a HyperMinHash sketch (uses the sketch size rounded up to a power of 2 registers), which can estimate union and intersection sizes")
+	addSpectrum = sketchCmd.Flags().Bool("spectrum", false, "also save the k-mer spectrum (the frequency of each used bin), so that histosketches can be merged (hulk merge) and evaluated against the exact similarity of the spectra (hulk evaluate)")
+	minQual = sketchCmd.Flags().Int("minQual", 0, "quality trim the ends of reads using this Phred score (0 = no trimming)")
+	minLength = sketchCmd.Flags().Uint("minLength", 0, "drop sequences with reads shorter than this after trimming (0 = no minimum)")
+	maxLength = sketchCmd.Flags().Uint("maxLength", 0, "drop sequences with reads longer than this (0 = no maximum)")
+	maxN = sketchCmd.Flags().Float64("maxN", 1.0, "drop sequences with reads containing a greater fraction of ambiguous (non-ACGT) bases than this (1.0 = no maximum)")
+	checkpoint = sketchCmd.Flags().String("checkpoint", "", "save the sketching state to this file once all the reads have been sketched, so that more reads can be added with --resume")
+	resume = sketchCmd.Flags().String("resume", "", "resume sketching from a checkpoint, adding the reads to the checkpointed sketch (must use the same sketch settings)")
 	sketchCmd.Flags().SortFlags = false
 	RootCmd.AddCommand(sketchCmd)
 }
@@ -94,16 +152,40 @@ func runSketch() {
 	// check the supplied files and then log some stuff
 	log.Printf("checking parameters...\n")
 	helpers.ErrorCheck(sketchParamCheck())
-	if *fasta {
-		log.Printf("\tmode: FASTA\n")
-	} else {
-		log.Printf("\tmode: FASTQ\n")
-	}
+	log.Printf("\tread pairing: %v\n", pairingMode())
 	log.Printf("\tno. processors: %d\n", *proc)
-	log.Printf("\tminimizer k-mer size: %d\n", *kmerSize)
-	log.Printf("\tminimizer window size: %d\n", *windowSize)
+	seederParams, err := minimizer.NewSeederParams(*seeder, *kmerSize, *windowSize, *smerSize, *syncOffset, *strobeMin, *strobeMax)
+	helpers.ErrorCheck(err)
+	log.Printf("\tseeder: %v\n", seederParams.Name)
+	log.Printf("\tk-mer size: %d\n", *kmerSize)
+	switch seederParams.Name {
+	case "minimizer":
+		log.Printf("\tminimizer window size: %d\n", seederParams.W)
+	case "closed-syncmer", "open-syncmer":
+		log.Printf("\tsyncmer s-mer size: %d\n", seederParams.S)
+		if seederParams.Name == "open-syncmer" {
+			log.Printf("\tsyncmer offset: %d\n", seederParams.T)
+		}
+	case "randstrobe":
+		log.Printf("\trandstrobe window: %d-%d\n", seederParams.WMin, seederParams.WMax)
+	}
+	log.Printf("\thash function: %v (seed: %d)\n", *hashFunc, *hashSeed)
 
 	log.Printf("\tsketch size: %d\n", *sketchSize)
+	log.Printf("\thistosketch seed: %d\n", *seed)
+	if *interval != 0 {
+		log.Printf("\tsketching interval: %d reads\n", *interval)
+	} else {
+		log.Printf("\tsketching interval: disabled\n")
+	}
+	if *window != 0 || *windowTime != 0 {
+		log.Printf("\tsliding window: enabled (reads: %d, time: %v)\n", *window, *windowTime)
+	} else {
+		log.Printf("\tsliding window: disabled\n")
+	}
+	if *checkpoint != "" {
+		log.Printf("\tcheckpoint: %v\n", *checkpoint)
+	}
 	if *streaming {
 		log.Printf("\tstreaming: enabled\n")
 	} else {
@@ -117,9 +199,27 @@ func runSketch() {
 	}
 	spectrumSize := int32(helpers.Pow(*kmerSize, 4))
 	log.Printf("\tnumber of bins in k-mer spectrum: %d\n", spectrumSize)
+	countMin := histosketch.CountMinSettings{Width: uint32(*cmWidth), Depth: uint32(*cmDepth), Conservative: *cmConserve}
+	if countMin.Width == 0 {
+		countMin.Width = defaultWidth()
+		if *cmFit {
+			countMin.Width = uint32(spectrumSize)
+		}
+	}
+	if countMin.Depth == 0 {
+		countMin.Depth = defaultDepth()
+	}
+	log.Printf("\tcount-min sketch: %d x %d (conservative update: %v)\n", countMin.Depth, countMin.Width, countMin.Conservative)
 	// adding any additional sketches?
 	log.Printf("\tadding KHF sketch: %v\n", *addKHF)
 	log.Printf("\tadding KMV sketch: %v\n", *addKMV)
+	log.Printf("\tadding HyperMinHash sketch: %v\n", *addHMH)
+	log.Printf("\tsaving k-mer spectrum: %v\n", *addSpectrum)
+	if readFiltering() {
+		log.Printf("\tread filtering: enabled (minQual: %d, minLength: %d, maxLength: %d, maxN: %.2f)\n", *minQual, *minLength, *maxLength, *maxN)
+	} else {
+		log.Printf("\tread filtering: disabled\n")
+	}
 
 	// create the runtime info struct
 	hulkInfo := &pipeline.Info{
@@ -128,57 +228,196 @@ func runSketch() {
 
 	// add the sketch command to the hulk runtime info
 	hulkInfo.Sketch = &pipeline.SketchCmd{
-		Fasta:        *fasta,
+		Pairing:      pairingMode(),
 		KmerSize:     *kmerSize,
 		WindowSize:   *windowSize,
+		HashFunc:     *hashFunc,
+		HashSeed:     *hashSeed,
+		Seeder:       seederParams,
 		SpectrumSize: spectrumSize,
 		SketchSize:   *sketchSize,
 		DecayRatio:   *decayRatio,
+		CountMin:     countMin,
+		Seed:         *seed,
 		Stream:       *streaming,
 		Interval:     *interval,
+		WindowReads:  *window,
+		WindowTime:   *windowTime,
 		OutFile:      *outFile,
-		NumMinions:   *proc * 1, // TODO: can increase minions for faster minimizer generation but big bottleneck happens during flushing
+		NumMinions:   *proc,
 		BannerLabel:  *bannerLabel,
 		KHF:          *addKHF,
 		KMV:          *addKMV,
+		HMH:          *addHMH,
+		Spectrum:     *addSpectrum,
+		MinQual:      *minQual,
+		MinLength:    *minLength,
+		MaxLength:    *maxLength,
+		MaxN:         *maxN,
+		Checkpoint:   *checkpoint,
 	}
 
-	// add the filename(s) which is being sketched by HULK
-	if len(*fastq) == 0 {
-		hulkInfo.Sketch.FileName = "STDIN"
+	// sketch each sample in the manifest, or sketch all the input as one sample
+	if *manifest != "" {
+		runManifest(hulkInfo.Sketch)
 	} else {
-		inputFiles := ""
-		for _, file := range *fastq {
-			inputFiles += file + ","
+		if len(*fastq) == 0 && len(*r1) == 0 {
+			hulkInfo.Sketch.FileName = "STDIN"
+		} else {
+			hulkInfo.Sketch.FileName = fileNames(append(append(*fastq, *r1...), *r2...))
 		}
-		hulkInfo.Sketch.FileName = inputFiles
+		if *resume != "" {
+			savedState, err := pipeline.LoadCheckpoint(*resume)
+			helpers.ErrorCheck(err)
+			helpers.ErrorCheck(savedState.Resume(hulkInfo.Sketch))
+			log.Printf("resuming from checkpoint: %v (%d sequences already sketched)\n", *resume, savedState.Progress.Sequences)
+		}
+		helpers.ErrorCheck(runPipeline(hulkInfo, *fastq, *r1, *r2))
 	}
+	log.Printf("finished in %s", time.Since(start))
+}
+
+// runPipeline is a function to set up and run the sketching pipeline, returning the first error encountered by the pipeline processes
+func runPipeline(hulkInfo *pipeline.Info, fastqFiles, r1Files, r2Files []string) error {
 
 	// create the pipeline
-	log.Printf("initialising sketching pipeline...\n")
+	hulkInfo.Logf("initialising sketching pipeline...\n")
 	sketchPipeline := pipeline.NewPipeline()
 
 	// initialise processes
-	log.Printf("\tinitialising the processes\n")
+	hulkInfo.Logf("\tinitialising the processes\n")
 	dataStream := pipeline.NewDataStreamer(hulkInfo)
 	fastqHandler := pipeline.NewFastqHandler(hulkInfo)
 	fastqHasher := pipeline.NewSeqMinimizer(hulkInfo)
 	sketcher := pipeline.NewSketcher(hulkInfo)
 
 	// connect the pipeline processes
-	log.Printf("\tconnecting data streams\n")
-	dataStream.Connect(*fastq)
+	hulkInfo.Logf("\tconnecting data streams\n")
+	if len(r1Files) != 0 {
+		dataStream.ConnectPairs(r1Files, r2Files)
+	} else {
+		dataStream.Connect(fastqFiles)
+	}
 	fastqHandler.Connect(dataStream)
-	fastqHasher.Connect(fastqHandler)
 	sketcher.Connect(fastqHasher)
 
-	// submit each process to the pipeline and run it
-	sketchPipeline.AddProcesses(dataStream, fastqHandler, fastqHasher, sketcher)
-	log.Printf("\tnumber of processes added to the sketching pipeline: %d\n", sketchPipeline.GetNumProcesses())
-	log.Printf("\tnumber of minions in the sketching pool: %d\n", hulkInfo.Sketch.NumMinions)
+	// submit each process to the pipeline and run it, adding the read filter if needed
+	if readFiltering() {
+		readFilter := pipeline.NewReadFilter(hulkInfo)
+		readFilter.Connect(fastqHandler)
+		fastqHasher.ConnectFilter(readFilter)
+		sketchPipeline.AddProcesses(dataStream, fastqHandler, readFilter, fastqHasher, sketcher)
+	} else {
+		fastqHasher.Connect(fastqHandler)
+		sketchPipeline.AddProcesses(dataStream, fastqHandler, fastqHasher, sketcher)
+	}
+	hulkInfo.Logf("\tnumber of processes added to the sketching pipeline: %d\n", sketchPipeline.GetNumProcesses())
+	hulkInfo.Logf("\tnumber of minions in the sketching pool: %d\n", hulkInfo.Sketch.NumMinions)
 	sketchPipeline.Run()
+	return hulkInfo.Err()
+}
 
-	log.Printf("finished in %s", time.Since(start))
+// runManifest is a function to sketch each sample in the manifest, running several samples at once within the processor budget
+// a failed sample is reported once all the other samples have been sketched
+func runManifest(sketchCmd *pipeline.SketchCmd) {
+	fh, err := os.Open(*manifest)
+	helpers.ErrorCheck(err)
+	samples, err := pipeline.ReadManifest(fh)
+	fh.Close()
+	helpers.ErrorCheck(err)
+
+	// share the processors between the samples
+	numWorkers := *proc
+	if numWorkers > len(samples) {
+		numWorkers = len(samples)
+	}
+	minionsPerSample, spareMinions := *proc/numWorkers, *proc%numWorkers
+	if spareMinions == 0 {
+		log.Printf("sketching %d samples from the manifest (%d at a time, with %d minion(s) each)...\n", len(samples), numWorkers, minionsPerSample)
+	} else {
+		log.Printf("sketching %d samples from the manifest (%d at a time, with %d or %d minions each)...\n", len(samples), numWorkers, minionsPerSample+1, minionsPerSample)
+	}
+
+	// sketch the samples, giving any spare processors to the first workers
+	errs := make([]error, len(samples))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		numMinions := minionsPerSample
+		if i < spareMinions {
+			numMinions++
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				errs[job] = sketchSample(sketchCmd, samples[job], numMinions)
+			}
+		}()
+	}
+	for job := range samples {
+		jobs <- job
+	}
+	close(jobs)
+	wg.Wait()
+
+	// report each sample
+	log.Printf("batch summary:\n")
+	failed := 0
+	for i, sample := range samples {
+		if errs[i] != nil {
+			failed++
+			log.Printf("\tsample %v failed: %v\n", sample.ID, errs[i])
+		} else {
+			log.Printf("\tsample %v sketched: %v.%v.json\n", sample.ID, *outFile, sample.ID)
+		}
+	}
+	log.Printf("\tsketched %d of %d samples\n", len(samples)-failed, len(samples))
+	if failed != 0 {
+		helpers.ErrorCheck(fmt.Errorf("%d of %d samples failed", failed, len(samples)))
+	}
+}
+
+// sketchSample is a function to sketch a single sample from the manifest, using a copy of the sketch command settings
+func sketchSample(sketchCmd *pipeline.SketchCmd, sample *pipeline.Sample, numMinions int) error {
+	files := append(append(append([]string{}, sample.Files...), sample.R1...), sample.R2...)
+	for _, file := range files {
+		if err := helpers.CheckFile(file); err != nil {
+			return err
+		}
+		if err := helpers.CheckExt(file, []string{"fastq", "fq", "fasta", "fna", "fa"}); err != nil {
+			return err
+		}
+	}
+	sampleCmd := *sketchCmd
+	sampleCmd.FileName = fileNames(files)
+	sampleCmd.SampleID = sample.ID
+	sampleCmd.Attributes = sample.Attributes
+	sampleCmd.OutFile = *outFile + "." + sample.ID
+	sampleCmd.NumMinions = numMinions
+	if sample.Label != "" {
+		sampleCmd.BannerLabel = sample.Label
+	}
+	if len(sample.R1) != 0 {
+		sampleCmd.Pairing = "paired"
+	} else if sampleCmd.Pairing == "paired" {
+		sampleCmd.Pairing = "single"
+	}
+	sampleInfo := &pipeline.Info{
+		Version: version.VERSION,
+		Sketch:  &sampleCmd,
+		Logger:  log.New(log.Writer(), "["+sample.ID+"] ", log.Flags()|log.Lmsgprefix),
+	}
+	return runPipeline(sampleInfo, sample.Files, sample.R1, sample.R2)
+}
+
+// fileNames is a function to list the input files in the format used by the sketch FileName
+func fileNames(files []string) string {
+	inputFiles := ""
+	for _, file := range files {
+		inputFiles += file + ","
+	}
+	return inputFiles
 }
 
 // sketchParamCheck is a function to check user supplied parameters
@@ -200,8 +439,86 @@ func sketchParamCheck() error {
 	}
 	runtime.GOMAXPROCS(*proc)
 
-	// check the supplied FASTQ file(s)
-	if len(*fastq) == 0 {
+	// check the read pairing options
+	if len(*r1) != len(*r2) {
+		return fmt.Errorf("the same number of R1 and R2 files must be supplied (%d vs. %d)", len(*r1), len(*r2))
+	}
+	if len(*r1) != 0 {
+		if len(*fastq) != 0 {
+			return fmt.Errorf("can't use --fastq with --r1/--r2")
+		}
+		if *interleaved {
+			return fmt.Errorf("can't use --interleaved with --r1/--r2")
+		}
+		for _, fastqFile := range append(*r1, *r2...) {
+			helpers.ErrorCheck(helpers.CheckFile(fastqFile))
+			helpers.ErrorCheck(helpers.CheckExt(fastqFile, []string{"fastq", "fq", "fasta", "fna", "fa"}))
+		}
+	}
+
+	// check the hash function
+	if err := minimizer.CheckHash(*hashFunc, *hashSeed); err != nil {
+		return err
+	}
+
+	// check the read filters
+	if *minQual < 0 {
+		return fmt.Errorf("--minQual must be 0 or above")
+	}
+	if *maxLength != 0 && *maxLength < *minLength {
+		return fmt.Errorf("--maxLength must not be less than --minLength (%d vs. %d)", *maxLength, *minLength)
+	}
+	if *maxN < 0 || *maxN > 1 {
+		return fmt.Errorf("--maxN must be between 0.0 and 1.0")
+	}
+
+	// check the count-min sketch dimensions (these are held as uint32)
+	if uint64(*cmWidth) > math.MaxUint32 || uint64(*cmDepth) > math.MaxUint32 {
+		return fmt.Errorf("--cmWidth and --cmDepth must be below %d", uint64(math.MaxUint32))
+	}
+	if *cmFit && *cmWidth != 0 {
+		return fmt.Errorf("--cmFitSpectrum can't be used with --cmWidth")
+	}
+
+	// check the sliding window, which moves on at each interval and replaces concept drift
+	if *window != 0 || *windowTime != 0 {
+		if *interval == 0 {
+			return fmt.Errorf("--window and --windowTime need an --interval")
+		}
+		if *window%*interval != 0 {
+			return fmt.Errorf("--window must be a multiple of --interval (%d vs. %d)", *window, *interval)
+		}
+		if *windowTime < 0 {
+			return fmt.Errorf("--windowTime must not be negative")
+		}
+		if *decayRatio != 1.0 {
+			return fmt.Errorf("can't use --decayRatio with a sliding window")
+		}
+		if *addKHF || *addKMV || *addHMH {
+			return fmt.Errorf("can't use --khf, --kmv or --hyperminhash with a sliding window, as they can't expire old reads")
+		}
+	}
+
+	// check the checkpoint files
+	if *resume != "" {
+		if err := helpers.CheckFile(*resume); err != nil {
+			return err
+		}
+	}
+
+	// check the supplied FASTQ file(s), the files in a manifest are checked as each sample is sketched
+	if *manifest != "" {
+		if len(*fastq) != 0 || len(*r1) != 0 {
+			return fmt.Errorf("can't use --manifest with --fastq or --r1/--r2")
+		}
+		if *checkpoint != "" || *resume != "" {
+			return fmt.Errorf("can't use --checkpoint or --resume with --manifest")
+		}
+		helpers.ErrorCheck(helpers.CheckFile(*manifest))
+		log.Printf("\tinput files: using manifest (%v)", *manifest)
+	} else if len(*r1) != 0 {
+		log.Printf("\tinput files: %d pairs", len(*r1))
+	} else if len(*fastq) == 0 {
 		helpers.ErrorCheck(helpers.CheckSTDIN())
 		log.Printf("\tinput file: using STDIN")
 	} else {
@@ -212,3 +529,32 @@ func sketchParamCheck() error {
 	}
 	return nil
 }
+
+// defaultWidth returns the default number of counters in each table of the count-min sketch
+func defaultWidth() uint32 {
+	width, _ := countmin.Dimensions(countmin.EPSILON, countmin.DELTA)
+	return width
+}
+
+// defaultDepth returns the default number of tables in the count-min sketch
+func defaultDepth() uint32 {
+	_, depth := countmin.Dimensions(countmin.EPSILON, countmin.DELTA)
+	return depth
+}
+
+// pairingMode returns the read pairing mode requested by the user
+func pairingMode() string {
+	switch {
+	case len(*r1) != 0:
+		return "paired"
+	case *interleaved:
+		return "interleaved"
+	default:
+		return "single"
+	}
+}
+
+// readFiltering returns true if any of the read filters have been requested
+func readFiltering() bool {
+	return *minQual > 0 || *minLength > 0 || *maxLength > 0 || *maxN < 1.0
+}
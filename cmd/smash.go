@@ -3,12 +3,12 @@ package cmd
 import (
 	"encoding/csv"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
 	"sort"
-	"strconv"
 
 	"github.com/pkg/profile"
 	"github.com/spf13/cobra"
@@ -19,19 +19,36 @@ import (
 
 // the command line arguments
 var (
-	sketchDir    *string // the directory containing the sketches
-	recursive    *bool   // recursively search the supplied directory
-	algo         *string // which sketching algorithm to use (histosketch, KMV, khf)
-	metric       *string // the distance metric to use
-	bannerMatrix *bool   // also write a bannerMatrix
+	sketchDir    *string  // the directory containing the sketches
+	recursive    *bool    // recursively search the supplied directory
+	algo         *string  // which sketching algorithm to use (histosketch, KMV, khf, hyperminhash)
+	metric       *string  // the distance metric to use
+	bannerMatrix *bool    // also write a bannerMatrix
+	tileSize     *int     // compare the sketches in tiles of this many sketches (0 = load all the sketches at once)
+	sparse       *bool    // write the pairs of sketches above a threshold, rather than a matrix
+	threshold    *float64 // the smallest similarity (or set size estimate) written to the sparse output
+	matrixFormat *string  // the format to write the matrix in
+	distance     *bool    // write distances rather than similarities
+	labelSource  *string  // where the row and column labels come from
 )
 
-// the available distance metrics
-var availMetrics = []string{"jaccard", "weightedjaccard"}
+// the available distance metrics (see the sketchio metric registry for the sketches each one can be used with)
+var availMetrics = sketchio.MetricNames()
+
+// the requested metric
+var smashMetric *sketchio.Metric
 
 // the sketches
 var hSketches map[string]*sketchio.HULKdata
 
+// the sketch files, in the order they are written to the matrix, and their labels
+var smashFiles []string
+var smashLabels []string
+
+// the first sketch, which every other sketch is checked against
+var smashRef *sketchio.HULKdata
+var smashRefSketch *sketchio.PreparedSketch
+
 // smashCmd is used by cobra
 var smashCmd = &cobra.Command{
 	Use:   "smash",
@@ -39,9 +56,36 @@ var smashCmd = &cobra.Command{
 	Long: `
 		Smash a bunch of sketches and return a distance matrix.
 
-		This subcommand performs pairwise comparisons of sketches and then writes a distance matrix.`,
+		This subcommand performs pairwise comparisons of sketches and then writes a distance matrix.
+
+		The matrix is written as a labelled CSV by default, with the rows and columns in the order of the
+		sketch file paths. It can instead be written as a labelled TSV (--format tsv), a relaxed PHYLIP
+		distance matrix (--format phylip, with the labels separated from the values by a space rather than
+		padded to 10 characters), a TSV of each pair of sketches (--format long) or a NumPy array
+		(--format npy, with the labels written to a separate file). The values are similarities out of 100,
+		or distances between 0 and 1 with --distance (PHYLIP matrices are always distances). The labels
+		are the sketch file paths, or the sequence file names (--labels filename) or banner labels
+		(--labels banner) stored in the sketches, which must be unique.
+
+		Each metric can only be used with the sketches that hold what it reads. The jaccard metric
+		can be used with any sketch, the weightedjaccard metric reads the slots and weights of
+		histosketches, and the braycurtis, canberra and euclidean metrics read the histosketch weights.
+		The canberra and euclidean distances aren't between 0 and 1, so they are always written as
+		distances.
+
+		HyperMinHash sketches (-a hyperminhash) can also estimate the number of k-mers in the union
+		or intersection of each pair of sketches (-m union or -m intersection), which are written
+		to the matrix instead of the similarity.
+
+		Large collections can be compared in tiles (--tileSize), so that only two tiles of sketches are
+		loaded at a time and the matrix is written as it is made. Each sketch file is read once, with the
+		extracted sketches kept in a temporary directory until the matrix is finished. Only the blocks of tiles
+		on and above the diagonal are compared, and the compared blocks are kept in the temporary directory
+		until their transposed block below the diagonal has been written. With --sparse, only the pairs of sketches
+		with a similarity of at least --threshold are written (in the long format), which keeps both the
+		memory use and the output small for collections of many thousands of samples.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		runSmash()
+		runSmash(cmd)
 	},
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		return helpers.CheckRequiredFlags(cmd.Flags())
@@ -52,14 +96,20 @@ var smashCmd = &cobra.Command{
 func init() {
 	sketchDir = smashCmd.Flags().StringP("sketchDir", "d", "./", "the directory containing the sketches to smash (compare)...")
 	recursive = smashCmd.Flags().Bool("recursive", false, "recursively search the supplied sketch directory (-d)")
-	algo = smashCmd.PersistentFlags().StringP("algorithm", "a", "histosketch", fmt.Sprintf("tells HULK which sketching algorithm to use %v", sketchio.AvailAlgorithms))
+	algo = smashCmd.PersistentFlags().StringP("algorithm", "a", "histosketch", fmt.Sprintf("tells HULK which sketching algorithm to use %v", sketchio.SmashAlgorithms))
 	metric = smashCmd.Flags().StringP("metric", "m", "jaccard", fmt.Sprintf("tells HULK which distance metric to use %v", availMetrics))
 	bannerMatrix = smashCmd.Flags().Bool("bannerMatrix", false, "write a matrix file for banner")
+	tileSize = smashCmd.Flags().Int("tileSize", 0, "load and compare the sketches in tiles of this many sketches, writing the matrix as it is made (0 = load all the sketches at once)")
+	sparse = smashCmd.Flags().Bool("sparse", false, "write the pairs of sketches with a similarity of at least --threshold as a TSV, rather than writing a matrix")
+	threshold = smashCmd.Flags().Float64("threshold", 0.0, "the smallest similarity (0-100) written by --sparse (or the smallest set size for the union and intersection metrics)")
+	matrixFormat = smashCmd.Flags().String("format", "csv", fmt.Sprintf("the format to write the matrix in %v (phylip is relaxed phylip, with labels of any length)", sketchio.MatrixFormats))
+	distance = smashCmd.Flags().Bool("distance", false, "write distances (0-1) rather than similarities (0-100)")
+	labelSource = smashCmd.Flags().String("labels", "path", fmt.Sprintf("where to get the matrix labels from %v", sketchio.LabelSources))
 	RootCmd.AddCommand(smashCmd)
 }
 
 // runSmash is the main function for this subcommand
-func runSmash() {
+func runSmash(cmd *cobra.Command) {
 
 	// set up cpu profiling
 	if *profiling == true {
@@ -86,17 +136,37 @@ func runSmash() {
 	hSketches = make(map[string]*sketchio.HULKdata)
 
 	// check the parameters and load the sketches
-	helpers.ErrorCheck(smashParamCheck())
+	helpers.ErrorCheck(smashParamCheck(cmd))
 	log.Printf("checking parameters and collecting sketches...\n")
 	log.Printf("\talgorithm: %v\n", *algo)
 	log.Printf("\tk-mer size: %d\n", *kmerSize)
+	log.Printf("\thash function: %v (seed: %d)\n", smashRef.HashFunc, smashRef.HashSeed)
+	log.Printf("\tseeder: %v\n", smashRef.Seeder.Name)
 	log.Printf("\tcreate matrix for banner: %v\n", *bannerMatrix)
-	log.Printf("\tnumber of sketch objects: %d\n", len(hSketches))
+	log.Printf("\tnumber of sketch objects: %d\n", len(smashFiles))
+	log.Printf("\tmatrix format: %v (%v, labelled by %v)\n", *matrixFormat, valueName(), *labelSource)
+	if *tileSize != 0 {
+		log.Printf("\ttile size: %d\n", *tileSize)
+	}
+	if *sparse {
+		log.Printf("\tsparse output threshold: %v\n", *threshold)
+	}
 	log.Print("HULK SMASH!\n")
 
 	// hulk smash
-	helpers.ErrorCheck(makeMatrix())
-	log.Printf("\twritten similarity matrix to disk: %v\n", *outFile+".hulk-matrix.csv")
+	if *tileSize != 0 {
+		helpers.ErrorCheck(makeTiledMatrix())
+	} else {
+		helpers.ErrorCheck(makeMatrix())
+	}
+	if *matrixFormat == "long" {
+		log.Printf("\twritten pairs to disk: %v\n", sketchio.MatrixFileName(*outFile, *matrixFormat))
+	} else {
+		log.Printf("\twritten %v matrix to disk: %v\n", valueName(), sketchio.MatrixFileName(*outFile, *matrixFormat))
+	}
+	if *matrixFormat == "npy" {
+		log.Printf("\twritten matrix labels to disk: %v\n", sketchio.MatrixLabelsFileName(*outFile))
+	}
 
 	// create banner matrix if requested
 	if *bannerMatrix {
@@ -108,27 +178,63 @@ func runSmash() {
 }
 
 // smashParamCheck is a function to check user supplied parameters
-func smashParamCheck() error {
+func smashParamCheck(cmd *cobra.Command) error {
 	// check the metric choice
+	var err error
+	if smashMetric, err = sketchio.GetMetric(*metric); err != nil {
+		return err
+	}
+
+	// check the algorithm choice
 	ok := false
-	for _, availMetric := range availMetrics {
-		if *metric == availMetric {
+	for _, availAlgo := range sketchio.SmashAlgorithms {
+		if *algo == availAlgo {
 			ok = true
 		}
 	}
 	if ok == false {
-		return fmt.Errorf("supplied distance metric is not available: %v\nplease select one of the following: %v", *metric, availMetrics)
+		return fmt.Errorf("supplied algorithm not available: %v\nplease select one of the following: %v", *algo, sketchio.SmashAlgorithms)
+	}
+	if err := smashMetric.CheckAlgorithm(*algo); err != nil {
+		return err
+	}
+	if *tileSize < 0 {
+		return fmt.Errorf("--tileSize must not be negative")
 	}
 
-	// check the algorithm choice
+	// check the output choices
 	ok = false
-	for _, availAlgo := range sketchio.AvailAlgorithms {
-		if *algo == availAlgo {
+	for _, availFormat := range sketchio.MatrixFormats {
+		if *matrixFormat == availFormat {
 			ok = true
 		}
 	}
 	if ok == false {
-		return fmt.Errorf("supplied algorithm not available: %v\nplease select one of the following: %v", *algo, sketchio.AvailAlgorithms)
+		return fmt.Errorf("supplied matrix format not available: %v\nplease select one of the following: %v", *matrixFormat, sketchio.MatrixFormats)
+	}
+	ok = false
+	for _, availSource := range sketchio.LabelSources {
+		if *labelSource == availSource {
+			ok = true
+		}
+	}
+	if ok == false {
+		return fmt.Errorf("supplied label source not available: %v\nplease select one of the following: %v", *labelSource, sketchio.LabelSources)
+	}
+	if *sparse {
+		if cmd.Flags().Changed("format") && *matrixFormat != "long" {
+			return fmt.Errorf("--sparse writes the pairs in the long format, it can't be used with --format %v", *matrixFormat)
+		}
+		*matrixFormat = "long"
+	}
+	if *matrixFormat == "phylip" || (!smashMetric.Bounded && !smashMetric.SetSize) {
+		*distance = true
+	}
+	if smashMetric.SetSize && *distance {
+		return fmt.Errorf("the %v metric is a set size estimate and can't be written as a distance (or as a phylip matrix)", *metric)
+	}
+	if *sparse && !smashMetric.Bounded && !smashMetric.SetSize {
+		return fmt.Errorf("the %v distance isn't between 0 and 1, so it can't be filtered by a similarity threshold (--sparse)", *metric)
 	}
 
 	// setup the outFile
@@ -160,83 +266,355 @@ func smashParamCheck() error {
 	jsonFiles, err := helpers.CollectJSONs(string(sDir), *recursive)
 	helpers.ErrorCheck(err)
 
-	// load the json files, check they are hulk sketches and get an array of sketch objects
-	for _, jsonFile := range jsonFiles {
+	sort.Strings(jsonFiles)
+	smashFiles = jsonFiles
 
-		// load the json
-		loadedSketch, err := sketchio.LoadHULKdata(jsonFile)
-		helpers.ErrorCheck(err)
+	// make sure there are at least 2 sketches to smash
+	if len(smashFiles) < 2 {
+		return fmt.Errorf("%d sketches found in the supplied directory, HULK needs at least 2 to smash!\n", len(smashFiles))
+	}
 
-		// add the sketch to the pile
-		hSketches[jsonFile] = loadedSketch
+	// load the json files, check they are hulk sketches and get an array of sketch objects (tiles are loaded by makeTiledMatrix instead)
+	if *tileSize == 0 {
+		for _, jsonFile := range smashFiles {
+			loadedSketch, err := sketchio.LoadHULKdata(jsonFile)
+			helpers.ErrorCheck(err)
+			hSketches[jsonFile] = loadedSketch
+		}
 	}
 
-	// make sure there are at least 2 sketches to smash
-	if len(hSketches) < 2 {
-		return fmt.Errorf("%d sketches found in the supplied directory, HULK needs at least 2 to smash!\n", len(hSketches))
+	// get the labels (tiles collect their labels when they are first read instead)
+	smashLabels = make([]string, len(smashFiles))
+	for i, jsonFile := range smashFiles {
+		if *labelSource == "path" {
+			smashLabels[i] = jsonFile
+			continue
+		}
+		if *tileSize != 0 {
+			continue
+		}
+		hulkData, err := getHULKdata(jsonFile)
+		if err != nil {
+			return err
+		}
+		if smashLabels[i], err = hulkData.GetLabel(*labelSource, jsonFile); err != nil {
+			return err
+		}
+	}
+	if *tileSize == 0 {
+		if err := checkLabels(); err != nil {
+			return err
+		}
+	}
+
+	// the first sketch is the one that every other sketch is checked against
+	smashRef, err = getHULKdata(smashFiles[0])
+	if err != nil {
+		return err
+	}
+	smashRefSketch, err = smashRef.PrepareSketch(*kmerSize, *algo)
+	return err
+}
+
+// getHULKdata is a function to get a sketch, loading it from disk if it isn't in the sketch pile
+func getHULKdata(jsonFile string) (*sketchio.HULKdata, error) {
+	if hulkData, ok := hSketches[jsonFile]; ok {
+		return hulkData, nil
+	}
+	return sketchio.LoadHULKdata(jsonFile)
+}
+
+// prepareSketches is a function to extract the sketches from a set of sketch files, making sure they can all be compared with the first sketch
+// if labels is not nil, the label of each sketch is collected too
+func prepareSketches(jsonFiles []string, labels []string) ([]*sketchio.PreparedSketch, error) {
+	sketches := make([]*sketchio.PreparedSketch, len(jsonFiles))
+	for i, jsonFile := range jsonFiles {
+		hulkData, err := getHULKdata(jsonFile)
+		if err != nil {
+			return nil, err
+		}
+		if labels != nil {
+			if labels[i], err = hulkData.GetLabel(*labelSource, jsonFile); err != nil {
+				return nil, err
+			}
+		}
+
+		// make sure the sketches used the same hash function and seeder
+		if err := smashRef.CheckHash(hulkData); err != nil {
+			return nil, fmt.Errorf("can't smash %v and %v: %v", smashFiles[0], jsonFile, err)
+		}
+		if err := smashRef.CheckSeeder(hulkData); err != nil {
+			return nil, fmt.Errorf("can't smash %v and %v: %v", smashFiles[0], jsonFile, err)
+		}
+		sketch, err := hulkData.PrepareSketch(*kmerSize, *algo)
+		if err != nil {
+			return nil, err
+		}
+		if err := smashRefSketch.CheckCompatible(sketch); err != nil {
+			return nil, err
+		}
+		sketches[i] = sketch
+	}
+	return sketches, nil
+}
+
+// smashCompare is a function to get the comparison for the requested metric
+func smashCompare() sketchio.CompareFunc {
+	if smashMetric.SetSize {
+		return func(subject, query *sketchio.PreparedSketch) (float64, error) {
+			return subject.GetCardinality(query, *metric)
+		}
+	}
+	return func(subject, query *sketchio.PreparedSketch) (float64, error) {
+		return subject.GetDistance(query, *metric)
 	}
+}
+
+// smashValue is a function to convert a comparison to the value that is written (distances are converted to a similarity out of 100 unless --distance is set, set size estimates are written as they are)
+func smashValue(value float64) float64 {
+	if smashMetric.SetSize || *distance {
+		return value
+	}
+	return 100 - (value * 100)
+}
+
+// passesThreshold is a function to check if a comparison should be written to the sparse output (the threshold is always a similarity out of 100, or a set size)
+func passesThreshold(value float64) bool {
+	if !*sparse {
+		return true
+	}
+	if smashMetric.SetSize {
+		return value >= *threshold
+	}
+	return 100-(value*100) >= *threshold
+}
+
+// valueName is a function to describe the values that are written
+func valueName() string {
+	switch {
+	case smashMetric.SetSize:
+		return *metric
+	case *distance:
+		return "distance"
+	default:
+		return "similarity"
+	}
+}
 
+// checkLabels is a function to make sure the matrix labels are unique, before any sketches are compared
+func checkLabels() error {
+	if err := sketchio.CheckLabels(smashLabels); err != nil {
+		return fmt.Errorf("%v (use --labels path to label the matrix with the sketch paths)", err)
+	}
 	return nil
 }
 
+// newMatrixWriter is a function to create the output file for the requested format, writing the values to an appropriate number of decimal places
+func newMatrixWriter() (*sketchio.MatrixWriter, error) {
+	precision := 2
+	if smashMetric.SetSize {
+		precision = 0
+	} else if *distance {
+		precision = 4
+	}
+	return sketchio.NewMatrixWriter(*outFile, *matrixFormat, smashLabels, valueName(), precision)
+}
+
 // makeMatrix perform pairwise comparisons of sketches, populates a distance matrix and then writes to csv
 func makeMatrix() error {
 
-	// create the matrix csv outfile
-	matrixFile, err := os.Create((*outFile + ".hulk-matrix.csv"))
-	defer matrixFile.Close()
+	// extract each sketch once and make sure they can all be compared
+	sketches, err := prepareSketches(smashFiles, nil)
 	if err != nil {
 		return err
 	}
-	matrixWriter := csv.NewWriter(matrixFile)
-	defer matrixWriter.Flush()
 
-	// sort the sketches
-	ordering := make([]string, len(hSketches))
-	count := 0
-	for fileName := range hSketches {
-		ordering[count] = fileName
-		count++
+	// hulk smash
+	matrix, err := sketchio.SmashSketches(sketches, smashCompare(), *proc)
+	if err != nil {
+		return err
 	}
-	sort.Strings(ordering)
 
-	// write the header
-	if matrixWriter.Write(ordering) != nil {
+	// write the rows, mirroring the upper triangle (or just the pairs in the upper triangle for the long format)
+	out, err := newMatrixWriter()
+	if err != nil {
 		return err
 	}
+	for i := range smashFiles {
+		if *matrixFormat == "long" {
+			for j := i + 1; j < len(smashFiles); j++ {
+				if !passesThreshold(matrix.Get(i, j)) {
+					continue
+				}
+				if err := out.WritePair(i, j, smashValue(matrix.Get(i, j))); err != nil {
+					out.Close()
+					return err
+				}
+			}
+			continue
+		}
+		row := make([]float64, len(smashFiles))
+		for j := range smashFiles {
+			row[j] = smashValue(matrix.Get(i, j))
+		}
+		if err := out.WriteRow(row); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
 
-	// hulk smash
-	for _, fileName := range ordering {
-		distances := make([]string, len(ordering))
-		for i, fileName2 := range ordering {
-
-			// the GetDistance method will call the sketch check, which will make sure the sketches are compatible (in terms of length etc)
-			distanceVal, err := hSketches[fileName].GetDistance(hSketches[fileName2], *metric, *kmerSize, *algo)
-			helpers.ErrorCheck(err)
+// makeTiledMatrix performs pairwise comparisons of sketches one block of two tiles at a time, writing the rows of each tile once they are finished
+// each tile is read from the sketch files once, collecting the labels and spilling the extracted sketches to a temporary directory, which the blocks are then loaded from
+// for matrix output each row tile is compared with every tile, holding a full row for each sketch in the tile
+// for the long format only the tiles from the row tile onwards are needed, and only the pairs that reach the threshold are held
+func makeTiledMatrix() error {
+	numTiles := (len(smashFiles) + *tileSize - 1) / *tileSize
+	tileRange := func(tile int) (int, int) {
+		end := (tile + 1) * *tileSize
+		if end > len(smashFiles) {
+			end = len(smashFiles)
+		}
+		return tile * *tileSize, end
+	}
 
-			// convert the distance to a similarity, then to string so it can be written with the csv library
-			similarityVal := 100 - (distanceVal * 100)
-			distances[i] = strconv.FormatFloat(similarityVal, 'f', 2, 64)
+	// extract the sketches from each tile and spill them to disk
+	spillDir, err := ioutil.TempDir("", "hulk-smash")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(spillDir)
+	spillFile := func(tile int) string {
+		return filepath.Join(spillDir, fmt.Sprintf("tile-%d.gob", tile))
+	}
+	for tile := 0; tile < numTiles; tile++ {
+		start, end := tileRange(tile)
+		var labels []string
+		if *labelSource != "path" {
+			labels = smashLabels[start:end]
 		}
-		if matrixWriter.Write(distances) != nil {
+		sketches, err := prepareSketches(smashFiles[start:end], labels)
+		if err != nil {
+			return err
+		}
+		if err := sketchio.SpillSketches(spillFile(tile), sketches); err != nil {
 			return err
 		}
 	}
-	return nil
+	if err := checkLabels(); err != nil {
+		return err
+	}
+
+	// compare the tiles
+	out, err := newMatrixWriter()
+	if err != nil {
+		return err
+	}
+	compare := smashCompare()
+	type pair struct {
+		colID int
+		value float64
+	}
+	blockFile := func(rowTile, colTile int) string {
+		return filepath.Join(spillDir, fmt.Sprintf("block-%d-%d.gob", rowTile, colTile))
+	}
+	for rowTile := 0; rowTile < numTiles; rowTile++ {
+		rowSketches, err := sketchio.LoadSpilledSketches(spillFile(rowTile))
+		if err != nil {
+			out.Close()
+			return err
+		}
+		rows := make([][]float64, len(rowSketches))
+		pairs := make([][]pair, len(rowSketches))
+		if *matrixFormat != "long" {
+			for i := range rows {
+				rows[i] = make([]float64, len(smashFiles))
+			}
+
+			// the blocks left of the diagonal were compared for an earlier row tile, so fill them from the spilled blocks
+			for colTile := 0; colTile < rowTile; colTile++ {
+				block, err := sketchio.LoadSpilledBlock(blockFile(colTile, rowTile))
+				if err != nil {
+					out.Close()
+					return err
+				}
+				os.Remove(blockFile(colTile, rowTile))
+				for j, blockRow := range block {
+					for i, value := range blockRow {
+						rows[i][colTile**tileSize+j] = smashValue(value)
+					}
+				}
+			}
+		}
+
+		// compare the row tile with the column tiles on and right of the diagonal
+		for colTile := rowTile; colTile < numTiles; colTile++ {
+			colSketches := rowSketches
+			if colTile != rowTile {
+				if colSketches, err = sketchio.LoadSpilledSketches(spillFile(colTile)); err != nil {
+					out.Close()
+					return err
+				}
+			}
+			block, err := sketchio.CompareBlock(rowSketches, colSketches, colTile == rowTile, compare, *proc)
+			if err != nil {
+				out.Close()
+				return err
+			}
+			if *matrixFormat != "long" && colTile != rowTile {
+				if err := sketchio.SpillBlock(blockFile(rowTile, colTile), block); err != nil {
+					out.Close()
+					return err
+				}
+			}
+			for i, blockRow := range block {
+				for j, value := range blockRow {
+					colID := colTile**tileSize + j
+					if *matrixFormat != "long" {
+						rows[i][colID] = smashValue(value)
+					} else if colID > rowTile**tileSize+i && passesThreshold(value) {
+						pairs[i] = append(pairs[i], pair{colID, smashValue(value)})
+					}
+				}
+			}
+		}
+
+		// write the rows for this tile
+		for i := range rowSketches {
+			if *matrixFormat != "long" {
+				err = out.WriteRow(rows[i])
+			}
+			for _, p := range pairs[i] {
+				if err == nil {
+					err = out.WritePair(rowTile**tileSize+i, p.colID, p.value)
+				}
+			}
+			if err != nil {
+				out.Close()
+				return err
+			}
+		}
+	}
+	return out.Close()
 }
 
 // makeBannerMatrix checks sketches, creates a matrix for Banner, assigns a banner label and writes to csv
 func makeBannerMatrix() error {
 	// create the Banner matrix csv outfile
 	bannerFile, err := os.Create((*outFile + ".banner-matrix.csv"))
-	defer bannerFile.Close()
 	if err != nil {
 		return err
 	}
+	defer bannerFile.Close()
 	bannerWriter := csv.NewWriter(bannerFile)
 	defer bannerWriter.Flush()
-	// range over each sketch and create the line for the csv writer
-	for _, HULKdata := range hSketches {
+	// range over each sketch (in the same order as the smash matrix) and create the line for the csv writer
+	for _, jsonFile := range smashFiles {
+		HULKdata, err := getHULKdata(jsonFile)
+		if err != nil {
+			return err
+		}
 
 		// get the correct k size
 		sketchObj, err := HULKdata.FindSketch(*kmerSize, *algo)
@@ -253,7 +631,7 @@ func makeBannerMatrix() error {
 
 		// append the banner label to the line and then write it
 		printString = append(printString, HULKdata.Banner)
-		if bannerWriter.Write(printString) != nil {
+		if err := bannerWriter.Write(printString); err != nil {
 			return err
 		}
 	}
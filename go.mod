@@ -6,6 +6,7 @@ require (
 	github.com/JSchwehn/goDistances v0.0.0-20171201063011-7146cc9f200a
 	github.com/deckarep/golang-set v1.7.1
 	github.com/dgryski/go-jump v0.0.0-20170409065014-e1f439676b57
+	github.com/klauspost/compress v1.10.3
 	github.com/leesper/go_rng v0.0.0-20190531154944-a612b043e353
 	github.com/pkg/profile v1.3.0
 	github.com/spf13/cobra v0.0.5
@@ -2,6 +2,9 @@
 package countmin
 
 import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
 	"math"
 
 	jump "github.com/dgryski/go-jump"
@@ -13,6 +16,9 @@ const EPSILON float64 = 0.001
 // DELTA is a default value for delta (confidence)
 const DELTA float64 = 0.99
 
+// RENORMALISE_THRESHOLD is the value of the global scale factor at which the decay is applied to the counters, so that the lazily scaled counters don't overflow
+const RENORMALISE_THRESHOLD float64 = 1e-100
+
 // CountMinSketch is the CountMin sketch data structure
 type CountMinSketch struct {
 	epsilon      float64     // relative-accuracy factor
@@ -22,14 +28,41 @@ type CountMinSketch struct {
 	width        uint32      // this is g in the paper, which is the number of counters per hash table
 	applyScaling bool        // if true, uniform scaling will be applied to the counters using the decay weight
 	decayWeight  float64     // the decay weight for scaling
+	scaleFactor  float64     // the global scale factor, which is applied lazily to every counter (the counter value is sketch[d][g] * scaleFactor)
+	conservative bool        // if true, conservative update is used, so only the counters holding the minimum for an element are incremented
+	total        float64     // the sum of all the increments, held unscaled like the counters
+	positions    []int32     // the counter for the current element in each hash table (used by conservative update)
+}
+
+// Dimensions is a function to return the sketch width (g) and depth (d) needed for a relative accuracy within a factor of epsilon with probability delta
+func Dimensions(epsilon, delta float64) (uint32, uint32) {
+	return uint32(math.Ceil(2 / epsilon)), uint32(math.Ceil(math.Log(1-delta) / math.Log(0.5)))
 }
 
 // NewCountMinSketch is the constructor function. The CountMin Sketch relative accuracy is within a factor of epsilon with probability delta.
 func NewCountMinSketch(epsilon, delta, decayRatio float64) *CountMinSketch {
+	g, d := Dimensions(epsilon, delta)
+	newSketch := newCountMinSketch(g, d, decayRatio)
+	newSketch.epsilon = epsilon
+	newSketch.delta = delta
+	return newSketch
+}
+
+// NewCountMinSketchWithSize is a constructor function for a sketch with a set width (g) and depth (d), the relative accuracy is then within a factor of 2/g with probability 1-0.5^d
+// if conservative is true, conservative update is used, which reduces the over-estimation of frequencies but means that counts can't be removed from the sketch
+func NewCountMinSketchWithSize(width, depth uint32, decayRatio float64, conservative bool) (*CountMinSketch, error) {
+	if width == 0 || depth == 0 {
+		return nil, fmt.Errorf("count-min sketch must have a width and depth of at least 1 (not %d x %d)", width, depth)
+	}
+	newSketch := newCountMinSketch(width, depth, decayRatio)
+	newSketch.epsilon = 2 / float64(width)
+	newSketch.delta = 1 - math.Pow(0.5, float64(depth))
+	newSketch.conservative = conservative
+	return newSketch, nil
+}
 
-	// calculate the sketch size
-	g := uint32(math.Ceil(2 / epsilon))
-	d := uint32(math.Ceil(math.Log(1-delta) / math.Log(0.5)))
+// newCountMinSketch is an unexported constructor function to set up a sketch of g x d counters
+func newCountMinSketch(g, d uint32, decayRatio float64) *CountMinSketch {
 
 	// initialise the sketch
 	q := make([][]float64, d)
@@ -39,11 +72,11 @@ func NewCountMinSketch(epsilon, delta, decayRatio float64) *CountMinSketch {
 
 	// create the data structure
 	newSketch := &CountMinSketch{
-		epsilon: epsilon,
-		delta:   delta,
-		sketch:  q,
-		depth:   d,
-		width:   g,
+		sketch:      q,
+		depth:       d,
+		width:       g,
+		scaleFactor: 1.0,
+		positions:   make([]int32, d),
 	}
 
 	// set the decay weight
@@ -62,7 +95,7 @@ func (CountMinSketch *CountMinSketch) Dump() <-chan float64 {
 	go func() {
 		for d := uint32(0); d < CountMinSketch.depth; d++ {
 			for g := uint32(0); g < CountMinSketch.width; g++ {
-				dumper <- CountMinSketch.sketch[d][g]
+				dumper <- CountMinSketch.sketch[d][g] * CountMinSketch.scaleFactor
 			}
 		}
 		close(dumper)
@@ -77,6 +110,8 @@ func (CountMinSketch *CountMinSketch) Wipe() {
 		q[i] = make([]float64, CountMinSketch.width)
 	}
 	CountMinSketch.sketch = q
+	CountMinSketch.scaleFactor = 1.0
+	CountMinSketch.total = 0.0
 }
 
 // GetDepth is a method to return the number of hash tables (d) in the sketch
@@ -89,6 +124,32 @@ func (CountMinSketch *CountMinSketch) GetWidth() uint32 {
 	return CountMinSketch.width
 }
 
+// GetEpsilon is a method to return the relative accuracy factor of the sketch
+func (CountMinSketch *CountMinSketch) GetEpsilon() float64 {
+	return CountMinSketch.epsilon
+}
+
+// GetDelta is a method to return the probability that a frequency estimate is within the relative accuracy of the sketch
+func (CountMinSketch *CountMinSketch) GetDelta() float64 {
+	return CountMinSketch.delta
+}
+
+// IsConservative is a method to return true if the sketch uses conservative update
+func (CountMinSketch *CountMinSketch) IsConservative() bool {
+	return CountMinSketch.conservative
+}
+
+// GetTotal is a method to return the sum of all the increments added to the sketch (after any decay)
+func (CountMinSketch *CountMinSketch) GetTotal() float64 {
+	return CountMinSketch.total * CountMinSketch.scaleFactor
+}
+
+// GetErrorBound is a method to return the estimated error bound for the sketch
+// a frequency estimate is never less than the true frequency, and it exceeds the true frequency by at most epsilon * the total of the increments, with probability delta
+func (CountMinSketch *CountMinSketch) GetErrorBound() float64 {
+	return CountMinSketch.epsilon * CountMinSketch.GetTotal()
+}
+
 // GetDecayWeight is a method to return the decay weight set in the data structure
 func (CountMinSketch *CountMinSketch) GetDecayWeight() float64 {
 	return CountMinSketch.decayWeight
@@ -103,12 +164,30 @@ func (CountMinSketch *CountMinSketch) GetEstimate(element uint64) float64 {
 func (CountMinSketch *CountMinSketch) Add(element uint64, increment float64) float64 {
 
 	// determine if uniform scaling needs to be applied to the sketch counters
+	// the scaling is applied lazily, by decaying the global scale factor instead of every counter, until the scale factor needs renormalising
 	if CountMinSketch.applyScaling == true {
-		CountMinSketch.scale()
+		CountMinSketch.scaleFactor *= CountMinSketch.decayWeight
+		if CountMinSketch.scaleFactor < RENORMALISE_THRESHOLD {
+			CountMinSketch.renormalise()
+		}
+	}
+	CountMinSketch.total += increment / CountMinSketch.scaleFactor
+	if CountMinSketch.conservative {
+		return CountMinSketch.conservativeUpdate(element, increment)
 	}
 	return CountMinSketch.traverse(element, increment)
 }
 
+// position is an unexported method to get the counter position for an element in a table of the sketch
+func (CountMinSketch *CountMinSketch) position(element uint64, d uint32) int32 {
+
+	// hash for element
+	hash := element + (uint64(d) * element)
+
+	// use consistent jump hash to get counter position in this table
+	return jump.Hash(hash, int(CountMinSketch.width))
+}
+
 // traverse is an unexported method to identify a counter for a query element in each table of the count-min sketch
 func (CountMinSketch *CountMinSketch) traverse(element uint64, increment float64) float64 {
 
@@ -117,16 +196,11 @@ func (CountMinSketch *CountMinSketch) traverse(element uint64, increment float64
 
 	// find the counter for the element in each table of the sketch
 	for d := uint32(0); d < CountMinSketch.depth; d++ {
+		g := CountMinSketch.position(element, d)
 
-		// hash for element
-		hash := element + (uint64(d) * element)
-
-		// use consistent jump hash to get counter position in this table
-		g := jump.Hash(hash, int(CountMinSketch.width))
-
-		// increment the counter if requested
+		// increment the counter if requested (the counters are held unscaled)
 		if increment != 0.0 {
-			CountMinSketch.sketch[d][g] += increment
+			CountMinSketch.sketch[d][g] += increment / CountMinSketch.scaleFactor
 		}
 
 		// evaluate if the current counter is the minimum
@@ -134,14 +208,96 @@ func (CountMinSketch *CountMinSketch) traverse(element uint64, increment float64
 			currentMinimum = CountMinSketch.sketch[d][g]
 		}
 	}
-	return currentMinimum
+	return currentMinimum * CountMinSketch.scaleFactor
 }
 
-// scale is an unexported method to uniformly scale each counter in the sketch
-func (CountMinSketch *CountMinSketch) scale() {
+// conservativeUpdate is an unexported method to add an element using conservative update
+// the new frequency estimate is the current minimum plus the increment, and each counter for the element is only raised to this estimate if it is lower
+func (CountMinSketch *CountMinSketch) conservativeUpdate(element uint64, increment float64) float64 {
+	currentMinimum := math.MaxFloat64
+	for d := uint32(0); d < CountMinSketch.depth; d++ {
+		g := CountMinSketch.position(element, d)
+		CountMinSketch.positions[d] = g
+		if CountMinSketch.sketch[d][g] < currentMinimum {
+			currentMinimum = CountMinSketch.sketch[d][g]
+		}
+	}
+	estimate := currentMinimum + increment/CountMinSketch.scaleFactor
+	for d, g := range CountMinSketch.positions {
+		if CountMinSketch.sketch[d][g] < estimate {
+			CountMinSketch.sketch[d][g] = estimate
+		}
+	}
+	return estimate * CountMinSketch.scaleFactor
+}
+
+// renormalise is an unexported method to apply the global scale factor to each counter in the sketch and then reset it
+func (CountMinSketch *CountMinSketch) renormalise() {
 	for d := uint32(0); d < CountMinSketch.depth; d++ {
 		for g := uint32(0); g < CountMinSketch.width; g++ {
-			CountMinSketch.sketch[d][g] = CountMinSketch.sketch[d][g] * CountMinSketch.decayWeight
+			CountMinSketch.sketch[d][g] = CountMinSketch.sketch[d][g] * CountMinSketch.scaleFactor
+		}
+	}
+	CountMinSketch.total = CountMinSketch.total * CountMinSketch.scaleFactor
+	CountMinSketch.scaleFactor = 1.0
+}
+
+// countMinState holds the fields of a CountMinSketch that are saved by MarshalBinary
+type countMinState struct {
+	Epsilon      float64
+	Delta        float64
+	Sketch       [][]float64
+	Depth        uint32
+	Width        uint32
+	ApplyScaling bool
+	DecayWeight  float64
+	ScaleFactor  float64
+	Conservative bool
+	Total        float64
+}
+
+// MarshalBinary is a method to encode the full state of the sketch, including the counters and the global scale factor
+func (CountMinSketch *CountMinSketch) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&countMinState{
+		Epsilon:      CountMinSketch.epsilon,
+		Delta:        CountMinSketch.delta,
+		Sketch:       CountMinSketch.sketch,
+		Depth:        CountMinSketch.depth,
+		Width:        CountMinSketch.width,
+		ApplyScaling: CountMinSketch.applyScaling,
+		DecayWeight:  CountMinSketch.decayWeight,
+		ScaleFactor:  CountMinSketch.scaleFactor,
+		Conservative: CountMinSketch.conservative,
+		Total:        CountMinSketch.total,
+	})
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary is a method to restore a sketch from the state encoded by MarshalBinary
+func (CountMinSketch *CountMinSketch) UnmarshalBinary(data []byte) error {
+	state := &countMinState{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(state); err != nil {
+		return err
+	}
+	if uint32(len(state.Sketch)) != state.Depth {
+		return fmt.Errorf("count-min sketch has %d tables, expected %d", len(state.Sketch), state.Depth)
+	}
+	for _, table := range state.Sketch {
+		if uint32(len(table)) != state.Width {
+			return fmt.Errorf("count-min sketch table has %d counters, expected %d", len(table), state.Width)
 		}
 	}
+	CountMinSketch.epsilon = state.Epsilon
+	CountMinSketch.delta = state.Delta
+	CountMinSketch.sketch = state.Sketch
+	CountMinSketch.depth = state.Depth
+	CountMinSketch.width = state.Width
+	CountMinSketch.applyScaling = state.ApplyScaling
+	CountMinSketch.decayWeight = state.DecayWeight
+	CountMinSketch.scaleFactor = state.ScaleFactor
+	CountMinSketch.conservative = state.Conservative
+	CountMinSketch.total = state.Total
+	CountMinSketch.positions = make([]int32, state.Depth)
+	return nil
 }
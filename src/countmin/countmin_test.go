@@ -0,0 +1,172 @@
+package countmin
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var (
+	numElements  = 5000
+	numKeys      = 1000
+	decayRatios  = []float64{0.001, 0.1, 0.5, 0.9}
+	benchElement = uint64(12345)
+)
+
+// eagerAdd is the original concept drift implementation, which scales every counter before every addition
+// it is kept here as a reference for the lazy implementation and only works on a sketch that hasn't used the lazy scale factor
+func (CountMinSketch *CountMinSketch) eagerAdd(element uint64, increment float64) float64 {
+	if CountMinSketch.applyScaling == true {
+		for d := uint32(0); d < CountMinSketch.depth; d++ {
+			for g := uint32(0); g < CountMinSketch.width; g++ {
+				CountMinSketch.sketch[d][g] = CountMinSketch.sketch[d][g] * CountMinSketch.decayWeight
+			}
+		}
+	}
+	return CountMinSketch.traverse(element, increment)
+}
+
+// closeEnough is a helper function to check two frequency estimates are equal, within floating point tolerance
+func closeEnough(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))+1e-300
+}
+
+// check the sketch dimensions
+func TestNewCountMinSketch(t *testing.T) {
+	cms := NewCountMinSketch(EPSILON, DELTA, 1.0)
+	if cms.GetWidth() != 2000 || cms.GetDepth() != 7 {
+		t.Fatalf("unexpected sketch dimensions: %d x %d", cms.GetDepth(), cms.GetWidth())
+	}
+	if cms.applyScaling {
+		t.Fatal("scaling should not be applied for a decay ratio of 1.0")
+	}
+}
+
+// check the frequency estimates without concept drift
+func TestAdd(t *testing.T) {
+	cms := NewCountMinSketch(EPSILON, DELTA, 1.0)
+	for i := 0; i < 10; i++ {
+		cms.Add(42, 1.5)
+	}
+	if estimate := cms.GetEstimate(42); estimate != 15.0 {
+		t.Fatalf("incorrect estimate: %f", estimate)
+	}
+	cms.Wipe()
+	if estimate := cms.GetEstimate(42); estimate != 0.0 {
+		t.Fatalf("sketch was not wiped: %f", estimate)
+	}
+}
+
+// check the lazy decay gives the same frequency estimates as scaling every counter on every addition
+func TestLazyDecay(t *testing.T) {
+	for _, decayRatio := range decayRatios {
+		lazy := NewCountMinSketch(EPSILON, DELTA, decayRatio)
+		eager := NewCountMinSketch(EPSILON, DELTA, decayRatio)
+		rnd := rand.New(rand.NewSource(1))
+		renormalised := false
+		for i := 0; i < numElements; i++ {
+			element, increment := uint64(rnd.Intn(numKeys)), float64(rnd.Intn(100)+1)
+			scaleFactor := lazy.scaleFactor
+			lazyEstimate, eagerEstimate := lazy.Add(element, increment), eager.eagerAdd(element, increment)
+			if !closeEnough(lazyEstimate, eagerEstimate) {
+				t.Fatalf("decay ratio %f, element %d: lazy estimate %g does not match the eager estimate %g", decayRatio, i, lazyEstimate, eagerEstimate)
+			}
+			if lazy.scaleFactor > scaleFactor {
+				renormalised = true
+			}
+		}
+		for element := uint64(0); element < uint64(numKeys); element++ {
+			if !closeEnough(lazy.GetEstimate(element), eager.GetEstimate(element)) {
+				t.Fatalf("decay ratio %f: final lazy estimate %g does not match the eager estimate %g", decayRatio, lazy.GetEstimate(element), eager.GetEstimate(element))
+			}
+		}
+		lazyCounters, eagerCounters := lazy.Dump(), eager.Dump()
+		for counter := range lazyCounters {
+			if !closeEnough(counter, <-eagerCounters) {
+				t.Fatalf("decay ratio %f: dumped counters don't match", decayRatio)
+			}
+		}
+
+		// the scale factor should need renormalising, unless the decay is very slow
+		if !renormalised && decayRatio > 0.1 {
+			t.Fatalf("decay ratio %f: the scale factor was never renormalised", decayRatio)
+		}
+	}
+}
+
+// check the sketch dimensions can be set, and that the accuracy follows from them
+func TestNewCountMinSketchWithSize(t *testing.T) {
+	cms, err := NewCountMinSketchWithSize(500, 4, 1.0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cms.GetWidth() != 500 || cms.GetDepth() != 4 || cms.GetEpsilon() != 0.004 || cms.GetDelta() != 0.9375 {
+		t.Fatalf("unexpected sketch: %d x %d (epsilon: %f, delta: %f)", cms.GetDepth(), cms.GetWidth(), cms.GetEpsilon(), cms.GetDelta())
+	}
+	if _, err := NewCountMinSketchWithSize(0, 4, 1.0, false); err == nil {
+		t.Fatal("shouldn't make a sketch with no counters")
+	}
+}
+
+// check that conservative update never under-estimates, reduces the over-estimation, and that both modes stay within the error bound
+func TestConservativeUpdate(t *testing.T) {
+	for _, decayRatio := range []float64{1.0, 0.001} {
+		standard, _ := NewCountMinSketchWithSize(200, 4, decayRatio, false)
+		conservative, _ := NewCountMinSketchWithSize(200, 4, decayRatio, true)
+		truth := make(map[uint64]float64)
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < numElements; i++ {
+			element, increment := uint64(rnd.Intn(numKeys)), float64(rnd.Intn(100)+1)
+			if standard.applyScaling {
+				for key := range truth {
+					truth[key] *= standard.GetDecayWeight()
+				}
+			}
+			truth[element] += increment
+			standard.Add(element, increment)
+			conservative.Add(element, increment)
+		}
+		if !closeEnough(standard.GetTotal(), conservative.GetTotal()) || !closeEnough(standard.GetErrorBound(), standard.GetEpsilon()*standard.GetTotal()) {
+			t.Fatalf("decay ratio %f: unexpected totals: %f vs. %f", decayRatio, standard.GetTotal(), conservative.GetTotal())
+		}
+		standardError, conservativeError := 0.0, 0.0
+		for element, frequency := range truth {
+			standardEstimate, conservativeEstimate := standard.GetEstimate(element), conservative.GetEstimate(element)
+			if conservativeEstimate < frequency*(1-1e-9) || conservativeEstimate > standardEstimate*(1+1e-9) {
+				t.Fatalf("decay ratio %f: conservative estimate %f is outside of the true frequency %f and the standard estimate %f", decayRatio, conservativeEstimate, frequency, standardEstimate)
+			}
+			if standardEstimate-frequency > standard.GetErrorBound() {
+				t.Fatalf("decay ratio %f: estimate %f exceeds the true frequency %f by more than the error bound %f", decayRatio, standardEstimate, frequency, standard.GetErrorBound())
+			}
+			standardError += standardEstimate - frequency
+			conservativeError += conservativeEstimate - frequency
+		}
+		if conservativeError >= standardError {
+			t.Fatalf("decay ratio %f: conservative update did not reduce the over-estimation (%f vs. %f)", decayRatio, conservativeError, standardError)
+		}
+	}
+}
+
+// benchmark adding an element without concept drift
+func BenchmarkAdd(b *testing.B) {
+	cms := NewCountMinSketch(EPSILON, DELTA, 1.0)
+	for n := 0; n < b.N; n++ {
+		cms.Add(benchElement, 1.0)
+	}
+}
+
+// benchmark adding an element with concept drift, scaling every counter on every addition
+func BenchmarkAddEagerDecay(b *testing.B) {
+	cms := NewCountMinSketch(EPSILON, DELTA, 0.5)
+	for n := 0; n < b.N; n++ {
+		cms.eagerAdd(benchElement, 1.0)
+	}
+}
+
+// benchmark adding an element with concept drift, using the lazy scale factor
+func BenchmarkAddLazyDecay(b *testing.B) {
+	cms := NewCountMinSketch(EPSILON, DELTA, 0.5)
+	for n := 0; n < b.N; n++ {
+		cms.Add(benchElement, 1.0)
+	}
+}
@@ -70,3 +70,20 @@ func GetWJD(setA, setB, weightsA, weightsB []float64) (float64, error) {
 	// return the weighted jaccard distance
 	return 1 - (intersect / union), nil
 }
+
+// GetExactWJD is a function to calculate the exact weighted jaccard distance between two sets of frequencies (e.g. two k-mer spectra)
+// this is the distance that GetWJD estimates from a pair of histosketches
+func GetExactWJD(setA, setB []float64) (float64, error) {
+	if len(setA) != len(setB) {
+		return 0.0, fmt.Errorf("set size mismatch: %d vs %d\n", len(setA), len(setB))
+	}
+	intersect, union := 0.0, 0.0
+	for i := range setA {
+		intersect += math.Min(setA[i], setB[i])
+		union += math.Max(setA[i], setB[i])
+	}
+	if union == 0 {
+		return 0.0, fmt.Errorf("can't calculate the weighted jaccard distance of empty sets")
+	}
+	return 1 - (intersect / union), nil
+}
@@ -0,0 +1,105 @@
+package distances
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// testHistograms is a helper function to get a pair of random histograms that share some of their bins
+func testHistograms(rnd *rand.Rand, numBins int) ([]float64, []float64) {
+	a, b := make([]float64, numBins), make([]float64, numBins)
+	for i := range a {
+		if rnd.Float64() < 0.8 {
+			a[i] = float64(rnd.Intn(50) + 1)
+		}
+		if rnd.Float64() < 0.5 {
+			b[i] = a[i]
+		} else if rnd.Float64() < 0.8 {
+			b[i] = float64(rnd.Intn(50) + 1)
+		}
+	}
+	return a, b
+}
+
+// test the exact weighted jaccard distance against a hand calculation
+func TestGetExactWJD(t *testing.T) {
+	distance, err := GetExactWJD([]float64{3, 4, 0}, []float64{0, 2, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(distance-(1.0-2.0/9.0)) > 1e-12 {
+		t.Fatalf("exact weighted jaccard distance is %f, expected %f", distance, 1.0-2.0/9.0)
+	}
+	if _, err := GetExactWJD([]float64{0, 0}, []float64{0, 0}); err == nil {
+		t.Fatal("empty sets should not have a weighted jaccard distance")
+	}
+	if _, err := GetExactWJD([]float64{1}, []float64{1, 2}); err == nil {
+		t.Fatal("sets of different sizes should not be compared")
+	}
+}
+
+// test GetWJD against the ground truth
+// if every bin of the histograms is a slot (so the slots always match) and the weights are the bin frequencies, GetWJD is the exact weighted jaccard distance
+func TestGetWJD(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	numBins := 100
+	slots := make([]float64, numBins)
+	for i := range slots {
+		slots[i] = float64(i)
+	}
+	for trial := 0; trial < 10; trial++ {
+		a, b := testHistograms(rnd, numBins)
+		expected, err := GetExactWJD(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := GetWJD(slots, slots, a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-expected) > 1e-12 {
+			t.Fatalf("GetWJD gave %f, the exact weighted jaccard distance is %f", got, expected)
+		}
+
+		// the distance is symmetric
+		if reversed, _ := GetWJD(slots, slots, b, a); reversed != got {
+			t.Fatalf("GetWJD is not symmetric: %f vs. %f", got, reversed)
+		}
+	}
+
+	// slots that don't match only add to the union
+	got, err := GetWJD([]float64{1, 2}, []float64{1, 3}, []float64{2, 1}, []float64{4, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 1.0 - 2.0/7.0; math.Abs(got-expected) > 1e-12 {
+		t.Fatalf("GetWJD gave %f, expected %f", got, expected)
+	}
+}
+
+// test the unweighted metrics
+func TestGetDistance(t *testing.T) {
+	setA, setB := []float64{1, 2, 3, 4}, []float64{1, 2, 0, 0}
+	expected := map[string]float64{
+		"jaccard":    0.5,
+		"braycurtis": 7.0 / 13.0,
+		"canberra":   2.0,
+		"euclidean":  5.0,
+	}
+	for metric, distance := range expected {
+		got, err := GetDistance(setA, setB, metric)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-distance) > 1e-12 {
+			t.Fatalf("%v distance is %f, expected %f", metric, got, distance)
+		}
+	}
+	if _, err := GetDistance(setA, setB, "cosine"); err == nil {
+		t.Fatal("unknown metric should not be calculated")
+	}
+	if _, err := GetDistance(setA, setB[:2], "jaccard"); err == nil {
+		t.Fatal("sets of different sizes should not be compared")
+	}
+}
@@ -0,0 +1,189 @@
+// Package evaluate measures how well histosketches estimate the weighted jaccard similarity of samples, using their saved k-mer spectra as the ground truth
+package evaluate
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/will-rowe/hulk/src/histosketch"
+	"github.com/will-rowe/hulk/src/kmerspectrum"
+	"github.com/will-rowe/hulk/src/sketchio"
+)
+
+// Metrics are the hulk smash metrics that are evaluated, both of which are histosketch estimates of the weighted jaccard similarity
+var Metrics = []string{"jaccard", "weightedjaccard"}
+
+// Settings are the histosketch settings used for the evaluation
+type Settings struct {
+	SketchSizes []uint                       // the histosketch sizes to evaluate
+	Replicates  int                          // the number of independent histosketches (i.e. seeds) made of each spectrum at each size
+	Seed        int64                        // the seed for the first replicate (replicate r uses Seed+r)
+	CountMin    histosketch.CountMinSettings // the countmin sketch settings for the histosketches
+	NumWorkers  int                          // the number of go routines used to make the histosketches
+}
+
+// Pair holds the exact weighted jaccard similarity for a pair of spectra, along with the estimates for one metric and sketch size
+type Pair struct {
+	A, B       int       // the position of the spectra in the evaluated set
+	Metric     string    // the hulk smash metric used to estimate the similarity
+	SketchSize uint      // the histosketch size
+	Exact      float64   // the exact weighted jaccard similarity
+	Estimates  []float64 // the estimated similarity for each replicate
+}
+
+// Summary holds the bias and variance of the estimates for one metric and sketch size, across all the pairs of spectra
+type Summary struct {
+	Metric     string
+	SketchSize uint
+	Pairs      int
+	Replicates int
+	Bias       float64 // the mean difference between the estimated and exact similarity
+	Variance   float64 // the mean variance of the estimates for a pair (i.e. the variance between replicates)
+	RMSE       float64 // the root mean squared error of the estimates
+}
+
+// Evaluate is a function to make replicate histosketches of each spectrum at each sketch size, and compare their similarity estimates with the exact similarity of the spectra
+// the pairs are returned in order of the metric, sketch size and then the pair of spectra
+func Evaluate(spectra []*kmerspectrum.SavedSpectrum, settings Settings) ([]*Pair, []*Summary, error) {
+	if len(spectra) < 2 {
+		return nil, nil, fmt.Errorf("at least 2 spectra are needed for an evaluation")
+	}
+	if settings.Replicates < 1 {
+		return nil, nil, fmt.Errorf("at least 1 replicate is needed for an evaluation")
+	}
+	for _, spectrum := range spectra[1:] {
+		if err := spectra[0].CheckCompatible(spectrum); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// get the exact similarities
+	exact := make([][]float64, len(spectra))
+	for a := range spectra {
+		exact[a] = make([]float64, len(spectra))
+		for b := a + 1; b < len(spectra); b++ {
+			distance, err := spectra[a].GetDistance(spectra[b], "weightedjaccard")
+			if err != nil {
+				return nil, nil, err
+			}
+			exact[a][b] = 1.0 - distance
+		}
+	}
+
+	// estimate the similarities at each sketch size
+	pairs := []*Pair{}
+	summaries := []*Summary{}
+	for _, metric := range Metrics {
+		for _, sketchSize := range settings.SketchSizes {
+			sizePairs := []*Pair{}
+			for a := range spectra {
+				for b := a + 1; b < len(spectra); b++ {
+					sizePairs = append(sizePairs, &Pair{A: a, B: b, Metric: metric, SketchSize: sketchSize, Exact: exact[a][b]})
+				}
+			}
+			pairs = append(pairs, sizePairs...)
+			summaries = append(summaries, &Summary{Metric: metric, SketchSize: sketchSize, Pairs: len(sizePairs), Replicates: settings.Replicates})
+		}
+	}
+	for _, sketchSize := range settings.SketchSizes {
+		for replicate := 0; replicate < settings.Replicates; replicate++ {
+			sketches, err := sketchSpectra(spectra, sketchSize, settings.Seed+int64(replicate), settings)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, pair := range pairs {
+				if pair.SketchSize != sketchSize {
+					continue
+				}
+				distance, err := sketches[pair.A].GetDistance(sketches[pair.B], pair.Metric)
+				if err != nil {
+					return nil, nil, err
+				}
+				pair.Estimates = append(pair.Estimates, 1.0-distance)
+			}
+		}
+	}
+
+	// summarise the estimates
+	for _, summary := range summaries {
+		for _, pair := range pairs {
+			if pair.Metric != summary.Metric || pair.SketchSize != summary.SketchSize {
+				continue
+			}
+			mean, variance := pair.MeanVariance()
+			summary.Bias += mean - pair.Exact
+			summary.Variance += variance
+			for _, estimate := range pair.Estimates {
+				summary.RMSE += (estimate - pair.Exact) * (estimate - pair.Exact)
+			}
+		}
+		summary.Bias /= float64(summary.Pairs)
+		summary.Variance /= float64(summary.Pairs)
+		summary.RMSE = math.Sqrt(summary.RMSE / float64(summary.Pairs*summary.Replicates))
+	}
+	return pairs, summaries, nil
+}
+
+// MeanVariance is a method to return the mean and (population) variance of the replicate estimates for a pair
+func (Pair *Pair) MeanVariance() (float64, float64) {
+	mean, variance := 0.0, 0.0
+	for _, estimate := range Pair.Estimates {
+		mean += estimate
+	}
+	mean /= float64(len(Pair.Estimates))
+	for _, estimate := range Pair.Estimates {
+		variance += (estimate - mean) * (estimate - mean)
+	}
+	return mean, variance / float64(len(Pair.Estimates))
+}
+
+// sketchSpectra is a helper function to histosketch each spectrum, in the same way as hulk sketch, and prepare the histosketches for comparison
+func sketchSpectra(spectra []*kmerspectrum.SavedSpectrum, sketchSize uint, seed int64, settings Settings) ([]*sketchio.PreparedSketch, error) {
+	numWorkers := settings.NumWorkers
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	sketches := make([]*sketchio.PreparedSketch, len(spectra))
+	errs := make([]error, len(spectra))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				sketches[i], errs[i] = sketchSpectrum(spectra[i], sketchSize, seed, settings.CountMin)
+			}
+		}()
+	}
+	for i := range spectra {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sketches, nil
+}
+
+// sketchSpectrum is a helper function to histosketch a spectrum and prepare the histosketch for comparison
+func sketchSpectrum(spectrum *kmerspectrum.SavedSpectrum, sketchSize uint, seed int64, countMin histosketch.CountMinSettings) (*sketchio.PreparedSketch, error) {
+	hs, err := histosketch.NewHistoSketch(spectrum.KmerSize, sketchSize, spectrum.NumBins, 1.0, seed, countMin)
+	if err != nil {
+		return nil, err
+	}
+	for _, bin := range spectrum.GetBins() {
+		if err := hs.AddElement(uint64(bin.BinID), bin.Frequency); err != nil {
+			return nil, err
+		}
+	}
+	hulkData := sketchio.NewHULKdata()
+	if err := hulkData.Add(hs); err != nil {
+		return nil, err
+	}
+	return hulkData.PrepareSketch(spectrum.KmerSize, "histosketch")
+}
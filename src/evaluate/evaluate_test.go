@@ -0,0 +1,119 @@
+package evaluate
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/will-rowe/hulk/src/kmerspectrum"
+)
+
+// testSpectra is a helper function to get a set of spectra that share some of their bin frequencies
+func testSpectra(t *testing.T, numSpectra int) []*kmerspectrum.SavedSpectrum {
+	rnd := rand.New(rand.NewSource(1))
+	numBins := int32(200)
+	base := make([]float64, numBins)
+	for i := range base {
+		base[i] = float64(rnd.Intn(50) + 1)
+	}
+	spectra := make([]*kmerspectrum.SavedSpectrum, numSpectra)
+	for s := range spectra {
+		bins := []*kmerspectrum.Bin{}
+		for i, frequency := range base {
+			if rnd.Float64() < 0.3 {
+				frequency = float64(rnd.Intn(50) + 1)
+			}
+			bins = append(bins, &kmerspectrum.Bin{BinID: int32(i), Frequency: frequency})
+		}
+		spectrum, err := kmerspectrum.NewSavedSpectrum(7, numBins, bins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spectra[s] = spectrum
+	}
+	return spectra
+}
+
+// test that the evaluation covers every pair, metric and sketch size, and that larger sketches give less variable estimates
+func TestEvaluate(t *testing.T) {
+	spectra := testSpectra(t, 3)
+	settings := Settings{SketchSizes: []uint{16, 256}, Replicates: 8, Seed: 1, NumWorkers: 2}
+	pairs, summaries, err := Evaluate(spectra, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != len(Metrics)*len(settings.SketchSizes) || len(pairs) != 3*len(summaries) {
+		t.Fatalf("evaluation has %d summaries and %d pairs", len(summaries), len(pairs))
+	}
+	for _, pair := range pairs {
+		distance, err := spectra[pair.A].GetDistance(spectra[pair.B], "weightedjaccard")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(pair.Exact-(1.0-distance)) > 1e-12 || len(pair.Estimates) != settings.Replicates {
+			t.Fatalf("pair %d vs. %d has exact similarity %f and %d estimates", pair.A, pair.B, pair.Exact, len(pair.Estimates))
+		}
+	}
+	for i := 0; i < len(summaries); i += len(settings.SketchSizes) {
+		small, large := summaries[i], summaries[i+1]
+		if small.Metric != large.Metric || small.SketchSize != 16 || large.SketchSize != 256 {
+			t.Fatalf("summaries are not in order: %+v %+v", small, large)
+		}
+		if large.Variance >= small.Variance {
+			t.Fatalf("%v estimates from larger sketches should vary less: %f vs. %f", small.Metric, large.Variance, small.Variance)
+		}
+		if large.RMSE*large.RMSE < large.Variance {
+			t.Fatalf("%v mean squared error can't be less than the variance: %f vs. %f", large.Metric, large.RMSE*large.RMSE, large.Variance)
+		}
+	}
+
+	// the replicates are seeded, so an evaluation can be repeated
+	_, repeated, err := Evaluate(spectra, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range summaries {
+		if *summaries[i] != *repeated[i] {
+			t.Fatalf("repeated evaluation differs: %+v vs. %+v", summaries[i], repeated[i])
+		}
+	}
+	if _, _, err := Evaluate(spectra[:1], settings); err == nil {
+		t.Fatal("a single spectrum should not be evaluated")
+	}
+}
+
+// test that the evaluation measures the bias of the histosketch estimates against the exact similarity of the spectra they were made from
+// the weight-based weighted jaccard estimate isn't unbiased, so the bias is reported rather than checked, but the squared error should split into the variance and the error of each pair's mean estimate
+func TestHistosketchEstimates(t *testing.T) {
+	spectra := testSpectra(t, 4)
+	settings := Settings{SketchSizes: []uint{512}, Replicates: 20, Seed: 1, NumWorkers: 2}
+	pairs, summaries, err := Evaluate(spectra, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, summary := range summaries {
+		meanError, squaredError := 0.0, 0.0
+		for _, pair := range pairs {
+			if pair.Metric != summary.Metric || pair.SketchSize != summary.SketchSize {
+				continue
+			}
+			for _, estimate := range pair.Estimates {
+				if estimate < 0 || estimate > 1 {
+					t.Fatalf("%v estimate for spectra %d and %d is %f, expected a similarity between 0 and 1", pair.Metric, pair.A, pair.B, estimate)
+				}
+			}
+			mean, _ := pair.MeanVariance()
+			meanError += mean - pair.Exact
+			squaredError += (mean - pair.Exact) * (mean - pair.Exact)
+		}
+		meanError /= float64(summary.Pairs)
+		squaredError /= float64(summary.Pairs)
+		if math.Abs(summary.Bias-meanError) > 1e-12 {
+			t.Fatalf("%v bias is %f, expected %f", summary.Metric, summary.Bias, meanError)
+		}
+		if expected := math.Sqrt(summary.Variance + squaredError); math.Abs(summary.RMSE-expected) > 1e-9 {
+			t.Fatalf("%v RMSE is %f, expected %f", summary.Metric, summary.RMSE, expected)
+		}
+		t.Logf("%v estimates with %d slots have a bias of %f and a RMSE of %f", summary.Metric, summary.SketchSize, summary.Bias, summary.RMSE)
+	}
+}
@@ -78,13 +78,13 @@ func StartLogging(logFile string) *os.File {
 	return logFH
 }
 
-// CheckSTDIN is a function to check that STDIN can be read
+// CheckSTDIN is a function to check that STDIN can be read (either piped or redirected from a file)
 func CheckSTDIN() error {
 	stat, err := os.Stdin.Stat()
 	if err != nil {
 		return fmt.Errorf("error with STDIN")
 	}
-	if (stat.Mode() & os.ModeNamedPipe) == 0 {
+	if (stat.Mode() & os.ModeCharDevice) != 0 {
 		return fmt.Errorf("no STDIN found")
 	}
 	return nil
@@ -115,12 +115,15 @@ func CheckFile(file string) error {
 	return nil
 }
 
-// CheckExt is a function to check the extensions of a file
+// CheckExt is a function to check the extensions of a file, ignoring any compression extension (gz, bz2 or zst)
 func CheckExt(file string, exts []string) error {
 	splitFilename := strings.Split(file, ".")
 	finalIdx := len(splitFilename) - 1
-	if splitFilename[finalIdx] == "gz" {
-		finalIdx--
+	switch splitFilename[finalIdx] {
+	case "gz", "bz2", "zst":
+		if finalIdx > 0 {
+			finalIdx--
+		}
 	}
 	err := fmt.Errorf("file does not have recognised extension: %v", file)
 	for _, ext := range exts {
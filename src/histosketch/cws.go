@@ -0,0 +1,71 @@
+package histosketch
+
+/*
+ this part of the package provides the consistent weighted samples used by the histosketch
+
+ the samples used to be drawn from seeded random number generators and held as three sketch length x histogram bin matrices,
+ which took a lot of memory for large spectra (e.g. 50 slots x 194481 bins x 3 x 8 bytes = ~233MB)
+
+ the samples are now derived when they are needed, from a seeded hash of the histosketch slot and histogram bin:
+ -r and c are drawn from Gamma(2,1), as the sum of two exponentials (-ln(u1) - ln(u2))
+ -beta is drawn from Uniform(0,1)
+ each (slot, bin) pair gets its own splitmix64 stream, so the samples are independent of the spectrum size and the order they are requested in
+*/
+
+import (
+	"math"
+)
+
+// SAMPLE_SCHEME is the version of the scheme used to generate the consistent weighted samples, which is recorded in each histosketch
+// -0: the samples were drawn from seeded random number generators and held in memory (sketches made before the scheme was recorded)
+// -1: the samples are derived from a seeded hash of the histosketch slot and histogram bin
+const SAMPLE_SCHEME int = 1
+
+// splitmix64 constants
+const (
+	golden uint64 = 0x9e3779b97f4a7c15
+	mixA   uint64 = 0xbf58476d1ce4e5b9
+	mixB   uint64 = 0x94d049bb133111eb
+)
+
+// CWS is a struct to hold the consistent weighted sampling information
+type CWS struct {
+	seed uint64 // the seed for the sample hash
+}
+
+// newCWS is the constructor function
+func newCWS(seed int64) *CWS {
+	return &CWS{seed: mix64(uint64(seed) + golden)}
+}
+
+// getSample is a method to yield A_ka from the CWS, given the incoming histogram bin and the current sketch position
+func (CWS *CWS) getSample(i uint, j int, freq float64) float64 {
+	r, c, b := CWS.values(i, j)
+	Yka := math.Exp(math.Log(freq) - b)
+	return c / (Yka * math.Exp(r))
+}
+
+// values is a method to return r, c and beta*r (as used by getSample) for a histogram bin and sketch position
+func (CWS *CWS) values(i uint, j int) (float64, float64, float64) {
+	state := mix64(mix64(CWS.seed^uint64(i)) ^ uint64(j))
+	var u [5]float64
+	for n := range u {
+		state += golden
+		u[n] = toUnit(mix64(state))
+	}
+	r := -math.Log(u[0] * u[1])
+	c := math.Log(-math.Log(u[2] * u[3]))
+	return r, c, u[4] * r
+}
+
+// mix64 is the splitmix64 finaliser
+func mix64(x uint64) uint64 {
+	x = (x ^ (x >> 30)) * mixA
+	x = (x ^ (x >> 27)) * mixB
+	return x ^ (x >> 31)
+}
+
+// toUnit is a helper function to convert a hash value to a float in the open interval (0,1)
+func toUnit(x uint64) float64 {
+	return (float64(x>>11) + 0.5) / (1 << 53)
+}
@@ -1,14 +1,15 @@
 // Package histosketch is a Go implementation of HistoSketch: Fast Similarity-Preserving Sketching of Streaming Histograms with Concept Drift (https://exascale.info/assets/pdf/icdm2017_HistoSketch.pdf)
 // I've made some changes in my implementation compared to the paper:
-// - Instead of providing the number of histogram bins (Dimensions) and the number of countmin hash tables (d), I have decided to use epsilon and delta values to calculate CMS Dimensions.
-// - As I am using HistoSketch to Sketch CMS counters, the Dimensions of the histosketch are determined by the CMS Dimensions
+// - The number of histogram bins (Dimensions) is the size of the k-mer spectrum, and the bin frequencies are estimated with a countmin sketch.
+// - By default, the countmin sketch dimensions are calculated from the countmin epsilon and delta values (2000 counters per table), but they can be set with CountMinSettings (e.g. to use a counter per histogram bin).
 package histosketch
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
 	"math"
 
-	rng "github.com/leesper/go_rng"
 	"github.com/will-rowe/hulk/src/countmin"
 	"github.com/will-rowe/hulk/src/helpers"
 )
@@ -16,22 +17,9 @@ import (
 // MAX_K is the maximum k-mer size currently supported by HULK
 const MAX_K uint = 31
 
-// DISTRIBUTION_SEED is used to generate the distributions for the CWS
+// DISTRIBUTION_SEED is the default seed used to generate the distributions for the CWS
 const DISTRIBUTION_SEED int64 = 1
 
-// CWS is a struct to hold the consistent weighted sampling information
-type CWS struct {
-	r [][]float64 // r in the paper
-	c [][]float64 // c in the paper
-	b [][]float64 // beta in the paper (adapted here to pre-calculate beta*r )
-}
-
-// getSample is a method to yield A_ka from the CWS, given the incoming histogram bin and the current sketch position
-func (CWS *CWS) getSample(i uint, j int, freq float64) float64 {
-	Yka := math.Exp(math.Log(freq) - CWS.b[j][i])
-	return CWS.c[j][i] / (Yka * math.Exp(CWS.r[j][i]))
-}
-
 // HistoSketch is the histosketch data structure
 type HistoSketch struct {
 	algorithm         string
@@ -42,12 +30,24 @@ type HistoSketch struct {
 	SketchSize        uint                     `json:"num"`                // number of minimums in the histosketch
 	Dimensions        int32                    `json:"num_histogram_bins"` // number of histogram bins
 	ApplyConceptDrift bool                     `json:"concept_drift"`      // if true, uniform scaling will be applied to frequency estimates (in the CMS) and a decay ratio will be applied to sketch elements prior to assessing incoming elements
+	SampleScheme      int                      `json:"sample_scheme"`      // the scheme used to generate the consistent weighted samples (see SAMPLE_SCHEME)
+	Seed              int64                    `json:"seed"`               // the seed used to generate the consistent weighted samples
 	cwsSamples        *CWS                     // the consistent weighted samples
 	cmSketch          *countmin.CountMinSketch // Q in the paper (d * g matrix, where g is Sketch length)
 }
 
+// CountMinSettings are the settings for the countmin sketch that the histosketch uses to estimate the histogram bin frequencies
+// the zero value gives a countmin sketch with the dimensions derived from countmin.EPSILON and countmin.DELTA
+type CountMinSettings struct {
+	Width        uint32 // the number of counters in each table (0 = derived from countmin.EPSILON, unless FitSpectrum is set)
+	Depth        uint32 // the number of tables (0 = derived from countmin.DELTA)
+	Conservative bool   // use conservative update, which reduces the over-estimation of bin frequencies
+	FitSpectrum  bool   // use a counter per histogram bin in each table when Width is 0 (this avoids most collisions, but is much larger for big spectra)
+}
+
 // NewHistoSketch is the constructor function
-func NewHistoSketch(kmerSize, histosketchLength uint, numHistogramBins int32, decayRatio float64) (*HistoSketch, error) {
+// histosketches can only be compared if they were made with the same seed, but sketching a sample with different seeds gives independent replicate sketches
+func NewHistoSketch(kmerSize, histosketchLength uint, numHistogramBins int32, decayRatio float64, seed int64, cmSettings CountMinSettings) (*HistoSketch, error) {
 
 	// run some basic checks
 	if kmerSize > MAX_K {
@@ -66,6 +66,22 @@ func NewHistoSketch(kmerSize, histosketchLength uint, numHistogramBins int32, de
 		return nil, fmt.Errorf("histogram must have at least 2 bins")
 	}
 
+	// create the countmin sketch, using the default dimensions unless the user has set them or asked for a counter per histogram bin
+	defaultWidth, defaultDepth := countmin.Dimensions(countmin.EPSILON, countmin.DELTA)
+	if cmSettings.Width == 0 {
+		cmSettings.Width = defaultWidth
+		if cmSettings.FitSpectrum {
+			cmSettings.Width = uint32(numHistogramBins)
+		}
+	}
+	if cmSettings.Depth == 0 {
+		cmSettings.Depth = defaultDepth
+	}
+	cmSketch, err := countmin.NewCountMinSketchWithSize(cmSettings.Width, cmSettings.Depth, decayRatio, cmSettings.Conservative)
+	if err != nil {
+		return nil, err
+	}
+
 	// create the histosketch data structure
 	newHistosketch := &HistoSketch{
 		algorithm:     "histosketch",
@@ -74,7 +90,10 @@ func NewHistoSketch(kmerSize, histosketchLength uint, numHistogramBins int32, de
 		SketchWeights: make([]float64, histosketchLength),
 		SketchSize:    histosketchLength,
 		Dimensions:    numHistogramBins,
-		cmSketch:      countmin.NewCountMinSketch(countmin.EPSILON, countmin.DELTA, decayRatio),
+		SampleScheme:  SAMPLE_SCHEME,
+		Seed:          seed,
+		cwsSamples:    newCWS(seed),
+		cmSketch:      cmSketch,
 	}
 	if decayRatio != 1.0 {
 		newHistosketch.ApplyConceptDrift = true
@@ -85,46 +104,9 @@ func NewHistoSketch(kmerSize, histosketchLength uint, numHistogramBins int32, de
 		newHistosketch.Sketch[i] = 0
 		newHistosketch.SketchWeights[i] = math.MaxFloat64
 	}
-
-	// create the CWS samples and attach them to the data structure
-	newHistosketch.newCWS()
 	return newHistosketch, nil
 }
 
-// newCWS is a method to generate a set of Consistent Weighted Samples
-func (HistoSketch *HistoSketch) newCWS() {
-
-	// create the matrices
-	r := make([][]float64, HistoSketch.SketchSize)
-	c := make([][]float64, HistoSketch.SketchSize)
-	b := make([][]float64, HistoSketch.SketchSize)
-
-	// set up the CWS by taking 3 sets of samples: from a Gamma distribution, log Gamma distribution and a uniform distribution respectively
-	gammaGenerator := rng.NewGammaGenerator(DISTRIBUTION_SEED)     // a random number generator for gamma distribution
-	uniformGenerator := rng.NewUniformGenerator(DISTRIBUTION_SEED) // a random number generator for a uniform distribution
-
-	// create the samples
-	for i := uint(0); i < HistoSketch.SketchSize; i++ {
-		r[i] = make([]float64, HistoSketch.Dimensions)
-		c[i] = make([]float64, HistoSketch.Dimensions)
-		b[i] = make([]float64, HistoSketch.Dimensions)
-		for j := int32(0); j < HistoSketch.Dimensions; j++ {
-			r[i][j] = gammaGenerator.Gamma(2, 1)
-			c[i][j] = math.Log(gammaGenerator.Gamma(2, 1))
-			//b[i][j] = uniformGenerator.Float64Range(0, 1) // as in paper
-			// I've multiplied beta by r and stored this instead of just beta
-			b[i][j] = uniformGenerator.Float64Range(0, 1) * r[i][j]
-		}
-	}
-
-	// set the cwsSamples
-	HistoSketch.cwsSamples = &CWS{
-		r: r,
-		c: c,
-		b: b,
-	}
-}
-
 // AddElement is a method to assess an incoming histogram element and add it to the histosketch if required
 func (HistoSketch *HistoSketch) AddElement(bin uint64, value float64) error {
 
@@ -154,6 +136,55 @@ func (HistoSketch *HistoSketch) AddElement(bin uint64, value float64) error {
 	return nil
 }
 
+// GetCountMin is a method to return the countmin sketch used to estimate the histogram bin frequencies
+func (HistoSketch *HistoSketch) GetCountMin() *countmin.CountMinSketch {
+	return HistoSketch.cmSketch
+}
+
+// CheckCompatible is a method to check that two histosketches were made with the same consistent weighted samples, as otherwise they can't be compared
+func (HistoSketch *HistoSketch) CheckCompatible(query *HistoSketch) error {
+	if HistoSketch.SampleScheme != query.SampleScheme {
+		return fmt.Errorf("histosketches were made with different CWS sample schemes (%d vs. %d)", HistoSketch.SampleScheme, query.SampleScheme)
+	}
+	if HistoSketch.Seed != query.Seed {
+		return fmt.Errorf("histosketches were made with different seeds (%d vs. %d)", HistoSketch.Seed, query.Seed)
+	}
+	return nil
+}
+
+// Merge is a method to combine two histosketches, keeping the element with the smallest CWS value (A_ka) in each slot
+// a histosketch only holds the minimum for each slot, so the merged sketch is a sketch of the element-wise maximum of the two histograms rather than their sum
+// for histograms with similar proportions (e.g. several sequencing lanes of one library) this closely approximates sketching all of the data together
+// NOTE: the countmin sketch is not merged, so no more elements should be added to the merged histosketch
+func (HistoSketch *HistoSketch) Merge(query *HistoSketch) error {
+
+	// check the sketches are compatible
+	if err := HistoSketch.CheckCompatible(query); err != nil {
+		return err
+	}
+	if HistoSketch.KmerSize != query.KmerSize {
+		return fmt.Errorf("can't merge histosketches with different k-mer sizes: %d vs. %d", HistoSketch.KmerSize, query.KmerSize)
+	}
+	if HistoSketch.SketchSize != query.SketchSize || len(HistoSketch.Sketch) != len(query.Sketch) {
+		return fmt.Errorf("can't merge histosketches with different sizes: %d vs. %d", HistoSketch.SketchSize, query.SketchSize)
+	}
+	if HistoSketch.Dimensions != query.Dimensions {
+		return fmt.Errorf("can't merge histosketches with different numbers of histogram bins: %d vs. %d", HistoSketch.Dimensions, query.Dimensions)
+	}
+	if HistoSketch.ApplyConceptDrift || query.ApplyConceptDrift {
+		return fmt.Errorf("can't merge histosketches made with concept drift, as their weights depend on when each element was added")
+	}
+
+	// keep the smallest weight in each slot
+	for slot, weight := range query.SketchWeights {
+		if weight < HistoSketch.SketchWeights[slot] {
+			HistoSketch.Sketch[slot] = query.Sketch[slot]
+			HistoSketch.SketchWeights[slot] = weight
+		}
+	}
+	return nil
+}
+
 // GetSketch is a method to return the current histosketch
 func (HistoSketch *HistoSketch) GetSketch() []uint64 {
 	sketch := make([]uint64, len(HistoSketch.Sketch))
@@ -178,3 +209,64 @@ func (HistoSketch *HistoSketch) GetMD5() string {
 func (HistoSketch *HistoSketch) GetAlgo() string {
 	return HistoSketch.algorithm
 }
+
+// histoSketchState holds the fields of a HistoSketch that are saved by MarshalBinary
+type histoSketchState struct {
+	KmerSize          uint
+	Sketch            []uint
+	SketchWeights     []float64
+	SketchSize        uint
+	Dimensions        int32
+	ApplyConceptDrift bool
+	SampleScheme      int
+	Seed              int64
+	CMSketch          *countmin.CountMinSketch
+}
+
+// MarshalBinary is a method to encode the full state of the histosketch, including the countmin sketch, so that more elements can be added once it is restored
+// the consistent weighted samples aren't saved, as they are derived from the seed
+func (HistoSketch *HistoSketch) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&histoSketchState{
+		KmerSize:          HistoSketch.KmerSize,
+		Sketch:            HistoSketch.Sketch,
+		SketchWeights:     HistoSketch.SketchWeights,
+		SketchSize:        HistoSketch.SketchSize,
+		Dimensions:        HistoSketch.Dimensions,
+		ApplyConceptDrift: HistoSketch.ApplyConceptDrift,
+		SampleScheme:      HistoSketch.SampleScheme,
+		Seed:              HistoSketch.Seed,
+		CMSketch:          HistoSketch.cmSketch,
+	})
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary is a method to restore a histosketch from the state encoded by MarshalBinary
+func (HistoSketch *HistoSketch) UnmarshalBinary(data []byte) error {
+	state := &histoSketchState{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(state); err != nil {
+		return err
+	}
+	if state.SampleScheme != SAMPLE_SCHEME {
+		return fmt.Errorf("histosketch was made with a different CWS sample scheme (%d vs. %d)", state.SampleScheme, SAMPLE_SCHEME)
+	}
+	if state.CMSketch == nil {
+		return fmt.Errorf("histosketch is missing its countmin sketch")
+	}
+	if len(state.Sketch) != len(state.SketchWeights) {
+		return fmt.Errorf("histosketch has %d bins but %d weights", len(state.Sketch), len(state.SketchWeights))
+	}
+	HistoSketch.algorithm = "histosketch"
+	HistoSketch.KmerSize = state.KmerSize
+	HistoSketch.Md5sum = ""
+	HistoSketch.Sketch = state.Sketch
+	HistoSketch.SketchWeights = state.SketchWeights
+	HistoSketch.SketchSize = state.SketchSize
+	HistoSketch.Dimensions = state.Dimensions
+	HistoSketch.ApplyConceptDrift = state.ApplyConceptDrift
+	HistoSketch.SampleScheme = state.SampleScheme
+	HistoSketch.Seed = state.Seed
+	HistoSketch.cwsSamples = newCWS(state.Seed)
+	HistoSketch.cmSketch = state.CMSketch
+	return nil
+}
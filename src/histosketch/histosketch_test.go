@@ -0,0 +1,341 @@
+package histosketch
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	rng "github.com/leesper/go_rng"
+)
+
+var (
+	testBins     = 200
+	testSlots    = uint(256)
+	numCWSvalues = 50000
+)
+
+// legacyCWS holds the in-memory samples used before the sample scheme was recorded (SampleScheme 0), made by the baseline generator
+type legacyCWS struct {
+	r [][]float64
+	c [][]float64
+	b [][]float64
+}
+
+// newLegacyCWS reproduces the baseline newCWS
+func newLegacyCWS(slots uint, bins int32) *legacyCWS {
+
+	// create the matrices
+	r := make([][]float64, slots)
+	c := make([][]float64, slots)
+	b := make([][]float64, slots)
+
+	// set up the CWS by taking 3 sets of samples: from a Gamma distribution, log Gamma distribution and a uniform distribution respectively
+	gammaGenerator := rng.NewGammaGenerator(DISTRIBUTION_SEED)
+	uniformGenerator := rng.NewUniformGenerator(DISTRIBUTION_SEED)
+
+	// create the samples
+	for i := uint(0); i < slots; i++ {
+		r[i] = make([]float64, bins)
+		c[i] = make([]float64, bins)
+		b[i] = make([]float64, bins)
+		for j := int32(0); j < bins; j++ {
+			r[i][j] = gammaGenerator.Gamma(2, 1)
+			c[i][j] = math.Log(gammaGenerator.Gamma(2, 1))
+			b[i][j] = uniformGenerator.Float64Range(0, 1) * r[i][j]
+		}
+	}
+	return &legacyCWS{r: r, c: c, b: b}
+}
+
+// getSample is the baseline method to yield A_ka from the CWS, given the incoming histogram bin and the current sketch position
+func (legacyCWS *legacyCWS) getSample(i uint, j int, freq float64) float64 {
+	Yka := math.Exp(math.Log(freq) - legacyCWS.b[j][i])
+	return legacyCWS.c[j][i] / (Yka * math.Exp(legacyCWS.r[j][i]))
+}
+
+// meanVar is a helper function to return the mean and sample variance
+func meanVar(x []float64) (float64, float64) {
+	mean, variance := 0.0, 0.0
+	for _, val := range x {
+		mean += val
+	}
+	mean /= float64(len(x))
+	for _, val := range x {
+		variance += (val - mean) * (val - mean)
+	}
+	return mean, variance / float64(len(x)-1)
+}
+
+// ksStatistic is a helper function to return the two sample Kolmogorov-Smirnov statistic
+func ksStatistic(a, b []float64) float64 {
+	a, b = append([]float64{}, a...), append([]float64{}, b...)
+	sort.Float64s(a)
+	sort.Float64s(b)
+	i, j, d := 0, 0, 0.0
+	for i < len(a) && j < len(b) {
+		if a[i] <= b[j] {
+			i++
+		} else {
+			j++
+		}
+		d = math.Max(d, math.Abs(float64(i)/float64(len(a))-float64(j)/float64(len(b))))
+	}
+	return d
+}
+
+// testHistograms is a helper function to get a pair of histograms that share roughly half their bin counts
+func testHistograms(rnd *rand.Rand) ([]float64, []float64) {
+	a, b := make([]float64, testBins), make([]float64, testBins)
+	for i := range a {
+		a[i] = float64(rnd.Intn(50) + 1)
+		b[i] = a[i]
+		if rnd.Float64() < 0.5 {
+			b[i] = float64(rnd.Intn(50) + 1)
+		}
+	}
+	return a, b
+}
+
+// matches is a helper function to return the fraction of slots where two sketches selected the same bin
+func matches(a, b []uint) float64 {
+	count := 0
+	for i := range a {
+		if a[i] == b[i] {
+			count++
+		}
+	}
+	return float64(count) / float64(len(a))
+}
+
+// check the hashed samples have the same distributions as the baseline generator (and the expected moments), and give the same distribution of A_ka as the baseline getSample
+func TestCWSsamples(t *testing.T) {
+	slots := uint(numCWSvalues / testBins)
+	legacy := newLegacyCWS(slots, int32(testBins))
+	cws := newCWS(DISTRIBUTION_SEED)
+	legacyValues, hashed := make([][]float64, 4), make([][]float64, 4)
+	for i := range legacyValues {
+		legacyValues[i], hashed[i] = make([]float64, numCWSvalues), make([]float64, numCWSvalues)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < numCWSvalues; n++ {
+		bin, slot := uint(n%testBins), n/testBins
+		freq := float64(rnd.Intn(50) + 1)
+		legacyValues[0][n] = legacy.r[slot][bin]
+		legacyValues[1][n] = legacy.c[slot][bin]
+		legacyValues[2][n] = legacy.b[slot][bin] / legacy.r[slot][bin]
+		legacyValues[3][n] = legacy.getSample(bin, slot, freq)
+		r, c, b := cws.values(bin, slot)
+		hashed[0][n], hashed[1][n], hashed[2][n] = r, c, b/r
+		hashed[3][n] = cws.getSample(bin, slot, freq)
+	}
+
+	// Gamma(2,1) has mean 2 and variance 2, log Gamma(2,1) has mean digamma(2) and variance trigamma(2), Uniform(0,1) has mean 1/2 and variance 1/12
+	expected := [][2]float64{{2, 2}, {1 - 0.5772156649, math.Pi*math.Pi/6 - 1}, {0.5, 1.0 / 12}}
+	critical := 1.63 * math.Sqrt(2/float64(numCWSvalues))
+	for i, name := range []string{"r", "c", "beta", "A_ka"} {
+		if i < len(expected) {
+			for _, samples := range [][]float64{legacyValues[i], hashed[i]} {
+				mean, variance := meanVar(samples)
+				if math.Abs(mean-expected[i][0]) > 0.03 || math.Abs(variance-expected[i][1]) > 0.05 {
+					t.Fatalf("unexpected moments for %v: mean %f (expected %f), variance %f (expected %f)", name, mean, expected[i][0], variance, expected[i][1])
+				}
+			}
+		}
+		if d := ksStatistic(legacyValues[i], hashed[i]); d > critical {
+			t.Fatalf("hashed %v samples have a different distribution to the baseline samples (KS statistic %f > %f)", name, d, critical)
+		}
+	}
+
+	// the samples for a bin shouldn't be correlated with each other
+	meanR, varR := meanVar(hashed[0])
+	meanC, varC := meanVar(hashed[1])
+	cov := 0.0
+	for i := range hashed[0] {
+		cov += (hashed[0][i] - meanR) * (hashed[1][i] - meanC)
+	}
+	if corr := cov / float64(numCWSvalues-1) / math.Sqrt(varR*varC); math.Abs(corr) > 0.02 {
+		t.Fatalf("r and c samples are correlated: %f", corr)
+	}
+}
+
+// check the samples only depend on the seed, slot and bin
+func TestCWSdeterministic(t *testing.T) {
+	cws1, cws2 := newCWS(DISTRIBUTION_SEED), newCWS(DISTRIBUTION_SEED)
+	r1, c1, b1 := cws1.values(12345, 7)
+	cws2.values(7, 12345)
+	r2, c2, b2 := cws2.values(12345, 7)
+	if r1 != r2 || c1 != c2 || b1 != b2 {
+		t.Fatal("CWS samples are not deterministic")
+	}
+	if r3, _, _ := cws1.values(7, 12345); r3 == r1 {
+		t.Fatal("CWS samples should differ when the slot and bin are swapped")
+	}
+	if r4, _, _ := newCWS(DISTRIBUTION_SEED+1).values(12345, 7); r4 == r1 {
+		t.Fatal("CWS samples should differ for a different seed")
+	}
+
+	// samples are available for bins beyond the histogram size given to the sketch
+	if r, c, b := cws1.values(math.MaxUint32, 0); math.IsNaN(r+c+b) || math.IsInf(r+c+b, 0) {
+		t.Fatal("CWS sample is not a number")
+	}
+}
+
+// check that sketches made with the hashed samples give the same similarity estimates as those made with the legacy samples
+func TestHistoSketchEquivalence(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	legacy := newLegacyCWS(testSlots, int32(testBins))
+	legacySketch := func(hist []float64) []uint {
+		sketch, weights := make([]uint, testSlots), make([]float64, testSlots)
+		for j := range sketch {
+			weights[j] = math.MaxFloat64
+			for i, freq := range hist {
+				if Aka := legacy.getSample(uint(i), j, freq); Aka < weights[j] {
+					sketch[j], weights[j] = uint(i), Aka
+				}
+			}
+		}
+		return sketch
+	}
+	legacyTotal, hashedTotal, trials := 0.0, 0.0, 10
+	for trial := 0; trial < trials; trial++ {
+		histA, histB := testHistograms(rnd)
+		hsA, err := NewHistoSketch(7, testSlots, int32(testBins), 1.0, DISTRIBUTION_SEED, CountMinSettings{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hsB, _ := NewHistoSketch(7, testSlots, int32(testBins), 1.0, DISTRIBUTION_SEED, CountMinSettings{})
+		for i := range histA {
+			if err := hsA.AddElement(uint64(i), histA[i]); err != nil {
+				t.Fatal(err)
+			}
+			hsB.AddElement(uint64(i), histB[i])
+		}
+		legacyTotal += matches(legacySketch(histA), legacySketch(histB))
+		hashedTotal += matches(hsA.Sketch, hsB.Sketch)
+	}
+	if diff := math.Abs(legacyTotal-hashedTotal) / float64(trials); diff > 0.04 {
+		t.Fatalf("mean similarity estimates differ between the legacy and hashed samples: %f vs. %f", legacyTotal/float64(trials), hashedTotal/float64(trials))
+	}
+}
+
+// check that sketches made with different sample schemes can't be compared
+func TestSampleScheme(t *testing.T) {
+	hsA, err := NewHistoSketch(7, testSlots, int32(testBins), 1.0, DISTRIBUTION_SEED, CountMinSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hsA.SampleScheme != SAMPLE_SCHEME {
+		t.Fatalf("sample scheme not recorded: %d", hsA.SampleScheme)
+	}
+	hsB, _ := NewHistoSketch(7, testSlots, int32(testBins), 1.0, DISTRIBUTION_SEED, CountMinSettings{})
+	if err := hsA.CheckCompatible(hsB); err != nil {
+		t.Fatal(err)
+	}
+	hsB.SampleScheme = 0
+	if err := hsA.CheckCompatible(hsB); err == nil {
+		t.Fatal("histosketches with different sample schemes should not be compatible")
+	}
+}
+
+// check that sketches made with different seeds are independent replicates, which can't be compared
+func TestSeed(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	hist, _ := testHistograms(rnd)
+	hsA, err := NewHistoSketch(7, testSlots, int32(testBins), 1.0, DISTRIBUTION_SEED, CountMinSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hsB, _ := NewHistoSketch(7, testSlots, int32(testBins), 1.0, 42, CountMinSettings{})
+	if hsB.Seed != 42 {
+		t.Fatalf("seed not recorded: %d", hsB.Seed)
+	}
+	for i, freq := range hist {
+		hsA.AddElement(uint64(i), freq)
+		hsB.AddElement(uint64(i), freq)
+	}
+	if matches(hsA.Sketch, hsB.Sketch) > 0.1 {
+		t.Fatal("sketches of the same histogram made with different seeds should be independent")
+	}
+	if err := hsA.CheckCompatible(hsB); err == nil {
+		t.Fatal("histosketches with different seeds should not be compatible")
+	}
+}
+
+// check that merging histosketches of disjoint histograms, or of histograms with the same proportions, matches sketching all of the data together
+func TestMerge(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	hist, _ := testHistograms(rnd)
+	newSketch := func() *HistoSketch {
+		hs, err := NewHistoSketch(7, testSlots, int32(testBins), 1.0, DISTRIBUTION_SEED, CountMinSettings{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hs
+	}
+	all, firstHalf, secondHalf, laneA, laneB := newSketch(), newSketch(), newSketch(), newSketch(), newSketch()
+	for i, freq := range hist {
+		all.AddElement(uint64(i), freq)
+		if i < testBins/2 {
+			firstHalf.AddElement(uint64(i), freq)
+		} else {
+			secondHalf.AddElement(uint64(i), freq)
+		}
+		laneA.AddElement(uint64(i), freq/2)
+		laneB.AddElement(uint64(i), freq/2)
+	}
+	for _, pair := range [][2]*HistoSketch{{firstHalf, secondHalf}, {laneA, laneB}} {
+		if err := pair[0].Merge(pair[1]); err != nil {
+			t.Fatal(err)
+		}
+		if matches(pair[0].Sketch, all.Sketch) != 1.0 {
+			t.Fatal("merged histosketch does not match a histosketch of all the data")
+		}
+	}
+
+	// check incompatible sketches aren't merged
+	otherSeed, _ := NewHistoSketch(7, testSlots, int32(testBins), 1.0, DISTRIBUTION_SEED+1, CountMinSettings{})
+	otherSize, _ := NewHistoSketch(7, testSlots+1, int32(testBins), 1.0, DISTRIBUTION_SEED, CountMinSettings{})
+	drift, _ := NewHistoSketch(7, testSlots, int32(testBins), 0.5, DISTRIBUTION_SEED, CountMinSettings{})
+	for _, hs := range []*HistoSketch{otherSeed, otherSize, drift} {
+		if err := all.Merge(hs); err == nil {
+			t.Fatal("incompatible histosketches should not be merged")
+		}
+	}
+}
+
+// check that a histosketch restored from its binary state carries on sketching in the same way as the original
+func TestMarshalBinary(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	hs, err := NewHistoSketch(11, testSlots, int32(testBins), 0.5, DISTRIBUTION_SEED, CountMinSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		hs.AddElement(uint64(rnd.Intn(testBins)), float64(rnd.Intn(10)+1))
+	}
+	state, err := hs.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &HistoSketch{}
+	if err := restored.UnmarshalBinary(state); err != nil {
+		t.Fatal(err)
+	}
+	if restored.GetAlgo() != "histosketch" || restored.Seed != hs.Seed || !restored.ApplyConceptDrift {
+		t.Fatal("restored histosketch has different settings")
+	}
+	for i := 0; i < 1000; i++ {
+		bin, value := uint64(rnd.Intn(testBins)), float64(rnd.Intn(10)+1)
+		hs.AddElement(bin, value)
+		restored.AddElement(bin, value)
+	}
+	for slot := range hs.Sketch {
+		if hs.Sketch[slot] != restored.Sketch[slot] || hs.SketchWeights[slot] != restored.SketchWeights[slot] {
+			t.Fatalf("restored histosketch differs from the original at slot %d", slot)
+		}
+	}
+	if err := restored.UnmarshalBinary(state[:len(state)/2]); err == nil {
+		t.Fatal("shouldn't restore a histosketch from a truncated state")
+	}
+}
@@ -80,6 +80,18 @@ func (KmerSpectrum *KmerSpectrum) AddHash(kmer uint64) error {
 	return nil
 }
 
+// AddBin is a method to add the frequency of a bin to the spectrum, so that bins dumped from another spectrum can be added back
+func (KmerSpectrum *KmerSpectrum) AddBin(bin *Bin) error {
+	if bin.BinID < 0 || bin.BinID >= KmerSpectrum.numBins {
+		return fmt.Errorf("bin %d is outside of the k-mer spectrum (%d bins)", bin.BinID, KmerSpectrum.numBins)
+	}
+	if err := KmerSpectrum.bv.Add(int(bin.BinID)); err != nil {
+		return err
+	}
+	KmerSpectrum.bins[bin.BinID] += bin.Frequency
+	return nil
+}
+
 // Dump is a method that returns each counter value in the CMS (returned via channel)
 func (KmerSpectrum *KmerSpectrum) Dump() (<-chan *Bin, error) {
 
@@ -90,27 +102,65 @@ func (KmerSpectrum *KmerSpectrum) Dump() (<-chan *Bin, error) {
 		return nil, fmt.Errorf("k-mer spectrum is empty")
 	}
 
-	// TODO: if the proportion of used bins is below a threshold, use the bit vector to quicky determine which bins are used
+	// make the dumper channel
+	dumper := make(chan *Bin)
+
+	// if the proportion of used bins is below a threshold, use the bit vector to quickly determine which bins are used
 	if propUsed < MIN_USED_BINS {
-		return nil, fmt.Errorf("not used yet")
+		go func() {
+			KmerSpectrum.forEachUsedBin(func(i int32) {
+				dumper <- &Bin{i, KmerSpectrum.bins[i]}
+			})
+			close(dumper)
+		}()
+		return dumper, nil
 	}
 
-	// make the dumper channel
-	dumper := make(chan *Bin)
+	// otherwise, iterate over the bins in the k-mer spectrum, sending any used bin to the dumper
 	go func() {
-
-		// iterate over the bins in the k-mer spectrum, sending any used bin to the dumper
 		for i := int32(0); i < KmerSpectrum.numBins; i++ {
 			if KmerSpectrum.bins[i] != 0.0 {
 				dumper <- &Bin{i, KmerSpectrum.bins[i]}
 			}
 		}
-
 		close(dumper)
 	}()
 	return dumper, nil
 }
 
+// Merge is a method to add the bin values from another k-mer spectrum to this one
+func (KmerSpectrum *KmerSpectrum) Merge(ks2 *KmerSpectrum) error {
+	if KmerSpectrum.numBins != ks2.numBins {
+		return fmt.Errorf("can't merge k-mer spectra with different numbers of bins: %d vs. %d", KmerSpectrum.numBins, ks2.numBins)
+	}
+	var err error
+	ks2.forEachUsedBin(func(i int32) {
+		if err == nil {
+			err = KmerSpectrum.bv.Add(int(i))
+		}
+		KmerSpectrum.bins[i] += ks2.bins[i]
+	})
+	return err
+}
+
+// forEachUsedBin is an unexported method to call a function on each bin that has been incremented, using the bit vector to skip unused bins
+func (KmerSpectrum *KmerSpectrum) forEachUsedBin(fn func(int32)) {
+	for word, bits := range KmerSpectrum.bv {
+		if bits == 0 {
+			continue
+		}
+		for offset := 0; offset < bitvector.MAX_SIZE; offset++ {
+			if bits&(1<<uint(offset)) == 0 {
+				continue
+			}
+			i := int32(word*bitvector.MAX_SIZE + offset)
+			if i < KmerSpectrum.numBins && KmerSpectrum.bins[i] != 0.0 {
+				fn(i)
+			}
+		}
+	}
+}
+
 // Print is a method to print the k-mer spectrum bin values as a comma separated list
 func (KmerSpectrum *KmerSpectrum) Print() string {
 	return helpers.FloatSlice2string(KmerSpectrum.bins, ",")
@@ -1,6 +1,7 @@
 package kmerspectrum
 
 import (
+	"math"
 	"testing"
 )
 
@@ -43,3 +44,106 @@ func TestAddHash(t *testing.T) {
 		t.Fatalf("incorrect cardinality - should be 2, not %d", ks.Cardinality())
 	}
 }
+
+// test the Dump method, using a sparse spectrum so the bit vector is used to find the bins
+func TestDump(t *testing.T) {
+	ks, err := NewKmerSpectrum(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Dump(); err == nil {
+		t.Fatal("shouldn't dump an empty spectrum")
+	}
+	ks.AddHash(hv1)
+	ks.AddHash(hv1)
+	ks.AddHash(hv2)
+	dump, err := ks.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0.0
+	for bin := range dump {
+		total += bin.Frequency
+	}
+	if total != 3.0 {
+		t.Fatalf("dumped bins should sum to 3, not %.0f", total)
+	}
+}
+
+// test the Merge method
+func TestMerge(t *testing.T) {
+	ks1, _ := NewKmerSpectrum(numBins)
+	ks2, _ := NewKmerSpectrum(numBins)
+	ks1.AddHash(hv1)
+	ks2.AddHash(hv1)
+	ks2.AddHash(hv2)
+	if err := ks1.Merge(ks2); err != nil {
+		t.Fatal(err)
+	}
+	if ks1.Cardinality() != 2 {
+		t.Fatalf("incorrect cardinality after merge - should be 2, not %d", ks1.Cardinality())
+	}
+	ks3, _ := NewKmerSpectrum(numBins + 1)
+	if err := ks1.Merge(ks3); err == nil {
+		t.Fatal("shouldn't merge spectra of different sizes")
+	}
+}
+
+// test that saved spectra are aligned over their used bins and compared exactly
+func TestSavedSpectrum(t *testing.T) {
+	specA, err := NewSavedSpectrum(21, numBins, []*Bin{{1, 2}, {3, 4}, {1, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	specB, err := NewSavedSpectrum(21, numBins, []*Bin{{3, 2}, {5, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specA.Bins) != 2 || specA.Frequencies[0] != 3 {
+		t.Fatalf("repeated bins were not summed: %v %v", specA.Bins, specA.Frequencies)
+	}
+
+	// bins 1, 3 and 5 are used: A = {3, 4, 0}, B = {0, 2, 2}
+	setA, setB, err := specA.Align(specB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(setA) != 3 || setA[0] != 3 || setA[2] != 0 || setB[0] != 0 || setB[1] != 2 {
+		t.Fatalf("spectra were not aligned: %v %v", setA, setB)
+	}
+	expected := map[string]float64{
+		"jaccard":         1.0 - 1.0/3.0,
+		"weightedjaccard": 1.0 - 2.0/9.0,
+		"braycurtis":      7.0 / 11.0,
+	}
+	for metric, distance := range expected {
+		got, err := specA.GetDistance(specB, metric)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-distance) > 1e-9 {
+			t.Fatalf("%v distance is %f, expected %f", metric, got, distance)
+		}
+	}
+
+	// merging sums the frequencies, and spectra with different numbers of bins can't be combined
+	if err := specA.Merge(specB); err != nil {
+		t.Fatal(err)
+	}
+	if len(specA.Bins) != 3 || specA.Frequencies[1] != 6 {
+		t.Fatalf("spectra were not merged: %v %v", specA.Bins, specA.Frequencies)
+	}
+	specC, err := NewSavedSpectrum(21, numBins+1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := specA.Merge(specC); err == nil {
+		t.Fatal("spectra with different numbers of bins should not merge")
+	}
+
+	// a loaded spectrum must have its bins in order
+	specB.Bins[0], specB.Bins[1] = specB.Bins[1], specB.Bins[0]
+	if err := specB.Restore(); err == nil {
+		t.Fatal("spectrum with unordered bins should not be restored")
+	}
+}
@@ -0,0 +1,182 @@
+package kmerspectrum
+
+/*
+ this part of the package saves a k-mer spectrum alongside the sketches, so that samples can be compared using their exact bin frequencies
+
+ only the used bins are saved (in order), as most of the bins in a large k-mer spectrum are empty
+ two saved spectra are compared over the union of their used bins, so bins that are empty in both spectra don't contribute to a distance
+*/
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/will-rowe/hulk/src/distances"
+	"github.com/will-rowe/hulk/src/helpers"
+)
+
+// SavedSpectrum holds the frequency of each used bin in a k-mer spectrum
+type SavedSpectrum struct {
+	algo        string
+	KmerSize    uint      `json:"ksize"`
+	NumBins     int32     `json:"num_bins"`    // the number of bins in the k-mer spectrum
+	Bins        []uint64  `json:"bins"`        // the used bins, in order
+	Frequencies []float64 `json:"frequencies"` // the frequency of each used bin
+	Md5sum      string    `json:"md5sum"`
+}
+
+// NewSavedSpectrum is the constructor function, which saves a set of bins (bins that are repeated have their frequencies summed)
+func NewSavedSpectrum(k uint, numBins int32, bins []*Bin) (*SavedSpectrum, error) {
+	ks, err := NewKmerSpectrum(numBins)
+	if err != nil {
+		return nil, err
+	}
+	for _, bin := range bins {
+		if err := ks.AddBin(bin); err != nil {
+			return nil, err
+		}
+	}
+	return ks.Save(k), nil
+}
+
+// Save is a method to save the used bins of the k-mer spectrum
+func (KmerSpectrum *KmerSpectrum) Save(k uint) *SavedSpectrum {
+	saved := &SavedSpectrum{
+		algo:     "spectrum",
+		KmerSize: k,
+		NumBins:  KmerSpectrum.numBins,
+	}
+	KmerSpectrum.forEachUsedBin(func(i int32) {
+		saved.Bins = append(saved.Bins, uint64(i))
+		saved.Frequencies = append(saved.Frequencies, KmerSpectrum.bins[i])
+	})
+	return saved
+}
+
+// GetBins is a method to return the saved bins, so that they can be added back to a k-mer spectrum
+func (SavedSpectrum *SavedSpectrum) GetBins() []*Bin {
+	bins := make([]*Bin, len(SavedSpectrum.Bins))
+	for i, binID := range SavedSpectrum.Bins {
+		bins[i] = &Bin{BinID: int32(binID), Frequency: SavedSpectrum.Frequencies[i]}
+	}
+	return bins
+}
+
+// Merge is a method to add the bin frequencies from another saved spectrum to this one
+func (SavedSpectrum *SavedSpectrum) Merge(query *SavedSpectrum) error {
+	if err := SavedSpectrum.CheckCompatible(query); err != nil {
+		return err
+	}
+	merged, err := NewSavedSpectrum(SavedSpectrum.KmerSize, SavedSpectrum.NumBins, append(SavedSpectrum.GetBins(), query.GetBins()...))
+	if err != nil {
+		return err
+	}
+	SavedSpectrum.Bins = merged.Bins
+	SavedSpectrum.Frequencies = merged.Frequencies
+	SavedSpectrum.Md5sum = ""
+	return nil
+}
+
+// CheckCompatible is a method to check that two saved spectra can be combined or compared
+func (SavedSpectrum *SavedSpectrum) CheckCompatible(query *SavedSpectrum) error {
+	if SavedSpectrum.KmerSize != query.KmerSize {
+		return fmt.Errorf("spectra have different k-mer sizes: %d vs. %d", SavedSpectrum.KmerSize, query.KmerSize)
+	}
+	if SavedSpectrum.NumBins != query.NumBins {
+		return fmt.Errorf("spectra have different numbers of bins: %d vs. %d", SavedSpectrum.NumBins, query.NumBins)
+	}
+	return nil
+}
+
+// Align is a method to get the frequencies of two saved spectra over the union of their used bins, in bin order
+func (SavedSpectrum *SavedSpectrum) Align(query *SavedSpectrum) ([]float64, []float64, error) {
+	if err := SavedSpectrum.CheckCompatible(query); err != nil {
+		return nil, nil, err
+	}
+	setA := make([]float64, 0, len(SavedSpectrum.Bins)+len(query.Bins))
+	setB := make([]float64, 0, len(SavedSpectrum.Bins)+len(query.Bins))
+	i, j := 0, 0
+	for i < len(SavedSpectrum.Bins) || j < len(query.Bins) {
+		switch {
+		case j == len(query.Bins) || (i < len(SavedSpectrum.Bins) && SavedSpectrum.Bins[i] < query.Bins[j]):
+			setA = append(setA, SavedSpectrum.Frequencies[i])
+			setB = append(setB, 0)
+			i++
+		case i == len(SavedSpectrum.Bins) || query.Bins[j] < SavedSpectrum.Bins[i]:
+			setA = append(setA, 0)
+			setB = append(setB, query.Frequencies[j])
+			j++
+		default:
+			setA = append(setA, SavedSpectrum.Frequencies[i])
+			setB = append(setB, query.Frequencies[j])
+			i++
+			j++
+		}
+	}
+	return setA, setB, nil
+}
+
+// GetDistance is a method to calculate the exact distance between two saved spectra
+// jaccard compares the sets of used bins, weightedjaccard compares the bin frequencies, and the other metrics are calculated by the distances package
+func (SavedSpectrum *SavedSpectrum) GetDistance(query *SavedSpectrum, metric string) (float64, error) {
+	setA, setB, err := SavedSpectrum.Align(query)
+	if err != nil {
+		return 0.0, err
+	}
+	if len(setA) == 0 {
+		return 0.0, fmt.Errorf("can't compare empty spectra")
+	}
+	switch metric {
+	case "jaccard":
+		shared := 0.0
+		for i := range setA {
+			if setA[i] != 0 && setB[i] != 0 {
+				shared++
+			}
+		}
+		return 1.0 - (shared / float64(len(setA))), nil
+	case "weightedjaccard":
+		return distances.GetExactWJD(setA, setB)
+	default:
+		return distances.GetDistance(setA, setB, metric)
+	}
+}
+
+// GetSketch is a method to return the used bins
+func (SavedSpectrum *SavedSpectrum) GetSketch() []uint64 {
+	return SavedSpectrum.Bins
+}
+
+// SetMD5 is a method to calculate and store the MD5 for the saved spectrum (which covers both the bins and their frequencies)
+func (SavedSpectrum *SavedSpectrum) SetMD5() {
+	data := make([]uint64, 0, len(SavedSpectrum.Bins)*2)
+	data = append(data, SavedSpectrum.Bins...)
+	for _, frequency := range SavedSpectrum.Frequencies {
+		data = append(data, math.Float64bits(frequency))
+	}
+	SavedSpectrum.Md5sum = fmt.Sprintf("%x", helpers.MD5sum(data))
+}
+
+// GetMD5 is a method to return the MD5 currently calculated for the saved spectrum
+func (SavedSpectrum *SavedSpectrum) GetMD5() string {
+	return SavedSpectrum.Md5sum
+}
+
+// GetAlgo is a method to return the sketching algorithm used
+func (SavedSpectrum *SavedSpectrum) GetAlgo() string {
+	return SavedSpectrum.algo
+}
+
+// Restore is a method to set up the unexported fields of a saved spectrum that has been loaded from JSON, checking that the bins are usable
+func (SavedSpectrum *SavedSpectrum) Restore() error {
+	if len(SavedSpectrum.Bins) != len(SavedSpectrum.Frequencies) {
+		return fmt.Errorf("saved spectrum has %d bins but %d frequencies", len(SavedSpectrum.Bins), len(SavedSpectrum.Frequencies))
+	}
+	for i, binID := range SavedSpectrum.Bins {
+		if binID >= uint64(SavedSpectrum.NumBins) || (i > 0 && binID <= SavedSpectrum.Bins[i-1]) {
+			return fmt.Errorf("saved spectrum bins are not in order, or are outside of the spectrum (%d bins)", SavedSpectrum.NumBins)
+		}
+	}
+	SavedSpectrum.algo = "spectrum"
+	return nil
+}
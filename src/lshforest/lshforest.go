@@ -0,0 +1,126 @@
+// Package lshforest is an implementation of the LSH Forest index (Bawa et al. 2005), which is used to find the sketches most similar to a query sketch
+package lshforest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DEFAULT_TREES is the default number of trees in the forest
+const DEFAULT_TREES int = 8
+
+// LSHforest is an LSH Forest index of sketches
+// each tree uses a band of K sketch slots as a key, and a query collects the sketches whose keys share the longest prefix with its own keys
+// this finds the nearest neighbours without needing a similarity threshold to be set when the index is built
+type LSHforest struct {
+	K     int     // the number of sketch slots used by each tree
+	L     int     // the number of trees
+	trees []*tree // the trees
+}
+
+// tree holds the keys for one band of sketch slots
+type tree struct {
+	buckets map[string][]string // the sketches with each key
+	keys    []string            // the keys, which are sorted before querying
+	sorted  bool                // false if keys have been added since the last sort
+}
+
+// NewLSHforest is the constructor function
+// the sketch slots are split evenly between the trees, any slots left over are not used by the index
+func NewLSHforest(sigSize, numTrees int) (*LSHforest, error) {
+	if numTrees < 1 {
+		return nil, fmt.Errorf("an LSH Forest needs at least 1 tree")
+	}
+	if sigSize < numTrees {
+		return nil, fmt.Errorf("sketch size (%d) is smaller than the number of trees (%d)", sigSize, numTrees)
+	}
+	trees := make([]*tree, numTrees)
+	for i := range trees {
+		trees[i] = &tree{buckets: make(map[string][]string), sorted: true}
+	}
+	return &LSHforest{
+		K:     sigSize / numTrees,
+		L:     numTrees,
+		trees: trees,
+	}, nil
+}
+
+// Add is a method to add a sketch to the forest
+func (LSHforest *LSHforest) Add(key string, sketch []uint64) error {
+	treeKeys, err := LSHforest.treeKeys(sketch)
+	if err != nil {
+		return err
+	}
+	for i, treeKey := range treeKeys {
+		tree := LSHforest.trees[i]
+		if _, ok := tree.buckets[treeKey]; !ok {
+			tree.keys = append(tree.keys, treeKey)
+			tree.sorted = false
+		}
+		tree.buckets[treeKey] = append(tree.buckets[treeKey], key)
+	}
+	return nil
+}
+
+// Query is a method to collect at least numCandidates sketches from the forest (if there are enough), starting with those that share the longest prefix of slots with the query
+// the candidates are returned in the order they were found, but they should be ranked by their similarity to the query
+func (LSHforest *LSHforest) Query(sketch []uint64, numCandidates int) ([]string, error) {
+	treeKeys, err := LSHforest.treeKeys(sketch)
+	if err != nil {
+		return nil, err
+	}
+	LSHforest.index()
+	seen := make(map[string]struct{})
+	candidates := []string{}
+
+	// shorten the prefix one slot at a time until there are enough candidates
+	// an empty prefix matches every sketch, so the forest only runs out of candidates once every sketch has been collected
+	for prefixLen := LSHforest.K; prefixLen >= 0 && len(candidates) < numCandidates; prefixLen-- {
+		for i, treeKey := range treeKeys {
+			prefix := treeKey[:prefixLen*8]
+			keys := LSHforest.trees[i].keys
+			for j := sort.SearchStrings(keys, prefix); j < len(keys) && strings.HasPrefix(keys[j], prefix); j++ {
+				for _, key := range LSHforest.trees[i].buckets[keys[j]] {
+					if _, ok := seen[key]; !ok {
+						seen[key] = struct{}{}
+						candidates = append(candidates, key)
+					}
+				}
+
+				// sketches that share no prefix with the query are all as good as each other, so stop as soon as there are enough
+				if prefixLen == 0 && len(candidates) >= numCandidates {
+					return candidates, nil
+				}
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// index is an unexported method to sort the keys of any tree that has changed
+func (LSHforest *LSHforest) index() {
+	for _, tree := range LSHforest.trees {
+		if !tree.sorted {
+			sort.Strings(tree.keys)
+			tree.sorted = true
+		}
+	}
+}
+
+// treeKeys is an unexported method to get the key for each tree from a sketch, by writing each slot as 8 big-endian bytes so that a prefix of the key is a prefix of the slots
+func (LSHforest *LSHforest) treeKeys(sketch []uint64) ([]string, error) {
+	if len(sketch) < LSHforest.K*LSHforest.L {
+		return nil, fmt.Errorf("sketch is too short for the LSH Forest: %d vs. %d slots", len(sketch), LSHforest.K*LSHforest.L)
+	}
+	treeKeys := make([]string, LSHforest.L)
+	buf := make([]byte, LSHforest.K*8)
+	for i := range treeKeys {
+		for j, slot := range sketch[i*LSHforest.K : (i+1)*LSHforest.K] {
+			binary.BigEndian.PutUint64(buf[j*8:], slot)
+		}
+		treeKeys[i] = string(buf)
+	}
+	return treeKeys, nil
+}
@@ -0,0 +1,86 @@
+package lshforest
+
+import (
+	"testing"
+)
+
+func TestNewLSHforest(t *testing.T) {
+	forest, err := NewLSHforest(50, DEFAULT_TREES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forest.K != 6 || forest.L != DEFAULT_TREES {
+		t.Fatalf("incorrect forest dimensions: K=%d L=%d", forest.K, forest.L)
+	}
+	if _, err := NewLSHforest(4, DEFAULT_TREES); err == nil {
+		t.Fatal("expected an error for a sketch with fewer slots than trees")
+	}
+	if err := forest.Add("short", make([]uint64, 10)); err == nil {
+		t.Fatal("expected an error for a sketch that is too short")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	forest, err := NewLSHforest(8, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := []uint64{1, 2, 3, 4, 5, 6, 7, 8}
+	sketches := map[string][]uint64{
+		"same":      {1, 2, 3, 4, 5, 6, 7, 8},
+		"close":     {1, 2, 3, 9, 5, 6, 7, 9},
+		"far":       {1, 9, 9, 9, 9, 9, 9, 9},
+		"unrelated": {9, 9, 9, 9, 9, 9, 9, 9},
+	}
+	for _, key := range []string{"unrelated", "far", "close", "same"} {
+		if err := forest.Add(key, sketches[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the candidates should be found in order of their shared prefix length
+	candidates, err := forest.Query(query, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0] != "same" {
+		t.Fatalf("expected the identical sketch, got %v", candidates)
+	}
+	candidates, err = forest.Query(query, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 3 || candidates[1] != "close" || candidates[2] != "far" {
+		t.Fatalf("expected the 3 related sketches in order, got %v", candidates)
+	}
+
+	// sketches that share no prefix are only returned once the related sketches run out
+	candidates, err = forest.Query(query, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, candidate := range candidates {
+		if candidate == "unrelated" {
+			t.Fatalf("expected the unrelated sketch to be skipped, got %v", candidates)
+		}
+	}
+	candidates, err = forest.Query(query, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 4 || candidates[3] != "unrelated" {
+		t.Fatalf("expected the unrelated sketch last, got %v", candidates)
+	}
+
+	// sketches added after a query are found by later queries
+	if err := forest.Add("late", []uint64{1, 2, 3, 4, 9, 9, 9, 9}); err != nil {
+		t.Fatal(err)
+	}
+	candidates, err = forest.Query(query, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 2 || candidates[1] != "late" {
+		t.Fatalf("expected the new sketch to be found, got %v", candidates)
+	}
+}
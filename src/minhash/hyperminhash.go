@@ -0,0 +1,289 @@
+package minhash
+
+/*
+ this part of the package is an implementation of HyperMinHash (Yu and Weber 2017, https://arxiv.org/abs/1710.08436)
+
+ each hash is placed in a bucket using its first p bits, and the bucket register keeps the smallest hash seen, stored as the position of its leading one bit (as in HyperLogLog) plus the next r bits
+ this gives a log-log sized sketch which can estimate the cardinality of a set (like HyperLogLog), as well as the Jaccard similarity (like MinHash) and so the union and intersection of two sets
+*/
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/will-rowe/hulk/src/helpers"
+)
+
+const (
+	// HMH_Q is the number of bits used to hold the leading one position in a HyperMinHash register
+	HMH_Q uint = 6
+
+	// HMH_R is the number of bits after the leading one that are held in a HyperMinHash register
+	HMH_R uint = 10
+
+	// HMH_MIN_REGISTERS is the minimum number of registers in a HyperMinHash sketch (the HyperLogLog bias correction needs at least 16)
+	HMH_MIN_REGISTERS uint = 16
+
+	// maxRho is the largest leading one position that fits in a register
+	maxRho uint64 = 1<<HMH_Q - 1
+)
+
+// HyperMinHash is the HyperMinHash sketch of a set
+type HyperMinHash struct {
+	algo       string
+	KmerSize   uint     `json:"ksize"`
+	Md5sum     string   `json:"md5sum"`
+	Registers  []uint64 `json:"registers"` // each register holds the leading one position (rho) of the smallest hash in the bucket, shifted left by HMH_R, plus the HMH_R bits that follow it (0 = empty)
+	SketchSize uint     `json:"num"`       // the number of registers
+	p          uint     // the number of bits used to select the bucket
+}
+
+// NewHyperMinHash is the constructor for a HyperMinHash sketch
+// the number of registers is the sketch size rounded up to a power of 2 (and at least HMH_MIN_REGISTERS)
+func NewHyperMinHash(k, s uint) *HyperMinHash {
+	if s < HMH_MIN_REGISTERS {
+		s = HMH_MIN_REGISTERS
+	}
+	p := uint(bits.Len(uint(s - 1)))
+	return &HyperMinHash{
+		algo:       "hyperminhash",
+		KmerSize:   k,
+		Registers:  make([]uint64, 1<<p),
+		SketchSize: 1 << p,
+		p:          p,
+	}
+}
+
+// AddHash is a method to evaluate a hash value and add it to the sketch if it is the smallest in its bucket
+// the hash is mixed first, as the seed hashes only use 2k bits
+func (HyperMinHash *HyperMinHash) AddHash(hv uint64) {
+	x := mix64(hv)
+	bucket := x >> (64 - HyperMinHash.p)
+	u := x << HyperMinHash.p
+	rho := uint64(bits.LeadingZeros64(u)) + 1
+	if rho > maxRho {
+		rho = maxRho
+	}
+	register := rho<<HMH_R | (u<<rho)>>(64-HMH_R)
+	if better(register, HyperMinHash.Registers[bucket]) {
+		HyperMinHash.Registers[bucket] = register
+	}
+}
+
+// Merge is a method to combine two HyperMinHash sketches, giving a sketch of the union of the two sets
+func (HyperMinHash *HyperMinHash) Merge(query *HyperMinHash) error {
+	if err := HyperMinHash.checkCompatible(query); err != nil {
+		return err
+	}
+	for bucket, register := range query.Registers {
+		if better(register, HyperMinHash.Registers[bucket]) {
+			HyperMinHash.Registers[bucket] = register
+		}
+	}
+	return nil
+}
+
+// Cardinality is a method to estimate the number of distinct hashes added to the sketch, using the HyperLogLog estimator
+func (HyperMinHash *HyperMinHash) Cardinality() float64 {
+	m := float64(len(HyperMinHash.Registers))
+	sum, empty := 0.0, 0.0
+	for _, register := range HyperMinHash.Registers {
+		sum += math.Pow(2, -float64(register>>HMH_R))
+		if register == 0 {
+			empty++
+		}
+	}
+	var alpha float64
+	switch len(HyperMinHash.Registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / sum
+
+	// use linear counting for small cardinalities
+	if estimate <= 2.5*m && empty != 0 {
+		return m * math.Log(m/empty)
+	}
+	return estimate
+}
+
+// UnionCardinality is a method to estimate the number of distinct hashes in the union of two sketches
+func (HyperMinHash *HyperMinHash) UnionCardinality(query *HyperMinHash) (float64, error) {
+	union := NewHyperMinHash(HyperMinHash.KmerSize, HyperMinHash.SketchSize)
+	copy(union.Registers, HyperMinHash.Registers)
+	if err := union.Merge(query); err != nil {
+		return 0.0, err
+	}
+	return union.Cardinality(), nil
+}
+
+// IntersectionCardinality is a method to estimate the number of distinct hashes shared by two sketches
+func (HyperMinHash *HyperMinHash) IntersectionCardinality(query *HyperMinHash) (float64, error) {
+	jaccard, err := HyperMinHash.jaccard(query)
+	if err != nil {
+		return 0.0, err
+	}
+	union, err := HyperMinHash.UnionCardinality(query)
+	if err != nil {
+		return 0.0, err
+	}
+	return jaccard * union, nil
+}
+
+// GetSimilarity is a method to estimate the Jaccard similarity between two HyperMinHash sketches
+func (mh1 *HyperMinHash) GetSimilarity(mh2 MinHash) (float64, error) {
+	query, ok := mh2.(*HyperMinHash)
+	if !ok {
+		return 0.0, fmt.Errorf("mismatched MinHash types: %T vs. %T", mh1, mh2)
+	}
+	return mh1.jaccard(query)
+}
+
+// jaccard is an unexported method to estimate the Jaccard similarity from the proportion of matching registers, after removing the matches expected by chance
+func (HyperMinHash *HyperMinHash) jaccard(query *HyperMinHash) (float64, error) {
+	if err := HyperMinHash.checkCompatible(query); err != nil {
+		return 0.0, err
+	}
+	matches, used := 0.0, 0.0
+	for bucket, register := range HyperMinHash.Registers {
+		if register != 0 || query.Registers[bucket] != 0 {
+			used++
+			if register == query.Registers[bucket] {
+				matches++
+			}
+		}
+	}
+	if used == 0 {
+		return 0.0, fmt.Errorf("can't estimate similarity for empty HyperMinHash sketches")
+	}
+	jaccard := (matches - expectedCollisions(HyperMinHash.Cardinality(), query.Cardinality(), float64(len(HyperMinHash.Registers)))) / used
+	if jaccard < 0 {
+		return 0.0, nil
+	}
+	return jaccard, nil
+}
+
+// GetSketch is a method to return the registers held by a HyperMinHash sketch
+func (HyperMinHash *HyperMinHash) GetSketch() []uint64 {
+	return HyperMinHash.Registers
+}
+
+// SetMD5 is a method to calculate and store the MD5 for the sketch
+func (HyperMinHash *HyperMinHash) SetMD5() {
+	HyperMinHash.Md5sum = fmt.Sprintf("%x", helpers.MD5sum(HyperMinHash.Registers))
+	return
+}
+
+// GetMD5 is a method to return the MD5 currently calculated for the sketch
+func (HyperMinHash *HyperMinHash) GetMD5() string {
+	return HyperMinHash.Md5sum
+}
+
+// GetAlgo is a method to return the sketching algorithm used
+func (HyperMinHash *HyperMinHash) GetAlgo() string {
+	return HyperMinHash.algo
+}
+
+// Restore is a method to set up the unexported fields of a sketch that has been loaded from JSON
+func (HyperMinHash *HyperMinHash) Restore() error {
+	numRegisters := uint(len(HyperMinHash.Registers))
+	if numRegisters < HMH_MIN_REGISTERS || numRegisters&(numRegisters-1) != 0 {
+		return fmt.Errorf("HyperMinHash sketch has %d registers, which is not a power of 2 (of at least %d)", numRegisters, HMH_MIN_REGISTERS)
+	}
+	HyperMinHash.algo = "hyperminhash"
+	HyperMinHash.SketchSize = numRegisters
+	HyperMinHash.p = uint(bits.TrailingZeros(numRegisters))
+	return nil
+}
+
+// hmhState holds the fields of a HyperMinHash that are saved by MarshalBinary
+type hmhState struct {
+	KmerSize  uint
+	Registers []uint64
+}
+
+// MarshalBinary is a method to encode the sketch, so that more hashes can be added once it is restored
+func (HyperMinHash *HyperMinHash) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&hmhState{KmerSize: HyperMinHash.KmerSize, Registers: HyperMinHash.Registers})
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary is a method to restore a sketch from the state encoded by MarshalBinary
+func (HyperMinHash *HyperMinHash) UnmarshalBinary(data []byte) error {
+	state := &hmhState{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(state); err != nil {
+		return err
+	}
+	HyperMinHash.KmerSize = state.KmerSize
+	HyperMinHash.Md5sum = ""
+	HyperMinHash.Registers = state.Registers
+	return HyperMinHash.Restore()
+}
+
+// checkCompatible is an unexported method to check two HyperMinHash sketches can be combined or compared
+func (HyperMinHash *HyperMinHash) checkCompatible(query *HyperMinHash) error {
+	if HyperMinHash.KmerSize != query.KmerSize {
+		return fmt.Errorf("HyperMinHash sketches have different k-mer sizes: %d vs. %d", HyperMinHash.KmerSize, query.KmerSize)
+	}
+	if len(HyperMinHash.Registers) != len(query.Registers) {
+		return fmt.Errorf("HyperMinHash sketches have different numbers of registers: %d vs. %d", len(HyperMinHash.Registers), len(query.Registers))
+	}
+	return nil
+}
+
+// better is a helper function to check if a register holds a smaller hash than another (i.e. a later leading one, or the same leading one and smaller following bits)
+func better(register, current uint64) bool {
+	if current == 0 {
+		return register != 0
+	}
+	rho, currentRho := register>>HMH_R, current>>HMH_R
+	return rho > currentRho || (rho == currentRho && register < current)
+}
+
+// expectedCollisions is a helper function to return the number of registers expected to match by chance for two disjoint sets of the given cardinalities
+// a register matches if the smallest hash in the bucket falls in the same (rho, r bits) interval for both sets, so this sums the probability of that over all the intervals
+func expectedCollisions(n, m, numRegisters float64) float64 {
+	if n == 0 || m == 0 {
+		return 0.0
+	}
+
+	// minHashIn returns the probability that the smallest hash in a bucket, from a set of the given cardinality, is in [a, b)
+	minHashIn := func(cardinality, a, b float64) float64 {
+		return math.Exp(cardinality*math.Log1p(-a/numRegisters)) - math.Exp(cardinality*math.Log1p(-b/numRegisters))
+	}
+	collisionProb := 0.0
+	subIntervals := float64(uint64(1) << HMH_R)
+	for rho := uint64(1); rho <= maxRho; rho++ {
+		width := math.Pow(2, -float64(rho))
+
+		// skip the leading one positions that neither set is likely to reach
+		if minHashIn(n, width, 2*width)*minHashIn(m, width, 2*width) < 1e-12 {
+			continue
+		}
+		for j := 0.0; j < subIntervals; j++ {
+			a, b := width*(1+j/subIntervals), width*(1+(j+1)/subIntervals)
+			collisionProb += minHashIn(n, a, b) * minHashIn(m, a, b)
+		}
+	}
+	return collisionProb * numRegisters
+}
+
+// mix64 is a helper function to spread the bits of a hash value across all 64 bits (the murmur3 finaliser)
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -1,6 +1,8 @@
 package minhash
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
 	"math"
 
@@ -45,13 +47,21 @@ func (KHFsketch *KHFsketch) AddHash(hv uint64) {
 }
 
 // Merge is a method to combine two MinHash objects
-// TODO: this should check for consistency between MinHash objects
-func (KHFsketch *KHFsketch) Merge(KHFsketch2 *KHFsketch) {
+func (KHFsketch *KHFsketch) Merge(KHFsketch2 *KHFsketch) error {
+
+	// check the sketches are compatible
+	if KHFsketch.KmerSize != KHFsketch2.KmerSize {
+		return fmt.Errorf("can't merge KHF sketches with different k-mer sizes: %d vs. %d", KHFsketch.KmerSize, KHFsketch2.KmerSize)
+	}
+	if KHFsketch.SketchSize != KHFsketch2.SketchSize || len(KHFsketch.Sketch) != len(KHFsketch2.Sketch) {
+		return fmt.Errorf("can't merge KHF sketches with different sizes: %d vs. %d", KHFsketch.SketchSize, KHFsketch2.SketchSize)
+	}
 	for i, minimum := range KHFsketch2.Sketch {
 		if minimum < KHFsketch.Sketch[i] {
 			KHFsketch.Sketch[i] = minimum
 		}
 	}
+	return nil
 }
 
 // GetSketch is a method to return the sketch held by a MinHash KHF sketch object
@@ -98,3 +108,34 @@ func (KHFsketch *KHFsketch) GetSimilarity(mh2 MinHash) (float64, error) {
 	}
 	return (intersect / float64(sharedLength)), nil
 }
+
+// khfState holds the fields of a KHFsketch that are saved by MarshalBinary
+type khfState struct {
+	KmerSize   uint
+	SketchSize uint
+	Sketch     []uint64
+}
+
+// MarshalBinary is a method to encode the sketch, so that more hashes can be added once it is restored
+func (KHFsketch *KHFsketch) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&khfState{KmerSize: KHFsketch.KmerSize, SketchSize: KHFsketch.SketchSize, Sketch: KHFsketch.Sketch})
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary is a method to restore a sketch from the state encoded by MarshalBinary
+func (KHFsketch *KHFsketch) UnmarshalBinary(data []byte) error {
+	state := &khfState{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(state); err != nil {
+		return err
+	}
+	if uint(len(state.Sketch)) != state.SketchSize {
+		return fmt.Errorf("KHF sketch holds %d minimums, but its size is %d", len(state.Sketch), state.SketchSize)
+	}
+	KHFsketch.algo = "khf"
+	KHFsketch.KmerSize = state.KmerSize
+	KHFsketch.Md5sum = ""
+	KHFsketch.Sketch = state.Sketch
+	KHFsketch.SketchSize = state.SketchSize
+	return nil
+}
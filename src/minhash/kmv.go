@@ -1,7 +1,9 @@
 package minhash
 
 import (
+	"bytes"
 	"container/heap"
+	"encoding/gob"
 	"fmt"
 	"sort"
 
@@ -16,8 +18,9 @@ type KMVsketch struct {
 	Sketch          []uint64 `json:"mins"`
 	SketchSize      uint     `json:"num"`
 	heap            *IntHeap
-	maxCardinalty   int // not yet used
-	multiplicitySum int // not yet used
+	inHeap          map[uint64]struct{} // the hashes currently held in the heap, for duplicate checks
+	maxCardinalty   int                 // not yet used
+	multiplicitySum int                 // not yet used
 }
 
 // NewKMVsketch is the constructor for a KMVsketch
@@ -27,6 +30,7 @@ func NewKMVsketch(k, s uint) *KMVsketch {
 		KmerSize:        k,
 		SketchSize:      s,
 		heap:            &IntHeap{},
+		inHeap:          make(map[uint64]struct{}),
 		maxCardinalty:   0,
 		multiplicitySum: 0,
 	}
@@ -54,14 +58,21 @@ func (KMVsketch *KMVsketch) AddHash(hv uint64) {
 	// increment the multiplicity
 	KMVsketch.multiplicitySum++
 
+	// ignore hashes that are too large for a full sketch, or are already in the sketch (the bottom-k is a set)
+	if (len(*KMVsketch.heap) == int(KMVsketch.SketchSize) && hv >= (*KMVsketch.heap)[0]) || KMVsketch.contains(hv) {
+		return
+	}
+
 	// if the heap isn't full yet, go ahead and add the hash
 	if len(*KMVsketch.heap) < int(KMVsketch.SketchSize) {
-		heap.Push(KMVsketch.heap, hv)
+		KMVsketch.push(hv)
 
 		// or if the incoming hash is smaller than the hash at the top of the heap, add the hash and remove the larger one from the heap
-	} else if hv < (*KMVsketch.heap)[0] {
+	} else {
 
 		// replace the largest value currently in the sketch with the new hash
+		delete(KMVsketch.inHeap, (*KMVsketch.heap)[0])
+		KMVsketch.inHeap[hv] = struct{}{}
 		(*KMVsketch.heap)[0] = hv
 
 		// re-establish the heap ordering after adding the new hash
@@ -70,52 +81,56 @@ func (KMVsketch *KMVsketch) AddHash(hv uint64) {
 	return
 }
 
-/*
-// Merge is a method to combine two bottomK MinHash objects
-func (KMVsketch *KMVsketch) Merge(querySketch *KMVsketch) {
-
-	// TODO: add sketch compatibility check
+// Merge is a method to combine two bottom-k MinHash objects
+func (KMVsketch *KMVsketch) Merge(querySketch *KMVsketch) error {
 
-	// make sure the incoming sketch is set and sorted
-	querySketch.SetSketch()
-
-	// duplicateChecker stops the same hash being added to the sketch multiple times (we over-sketched the incoming sketch to allow for this)
-	duplicateChecker := make(map[uint64]struct{})
-
-	// set up the duplicateChecker with the existing sketch
-	for _, hv := range *KMVsketch.heap {
-		duplicateChecker[hv] = struct{}{}
+	// check the sketches are compatible
+	if KMVsketch.KmerSize != querySketch.KmerSize {
+		return fmt.Errorf("can't merge KMV sketches with different k-mer sizes: %d vs. %d", KMVsketch.KmerSize, querySketch.KmerSize)
+	}
+	// the sizes are the configured sizes, which match even if a sketch isn't full (e.g. a small sequencing lane)
+	// NOTE: sketches written before the configured size was kept recorded the number of minimums held as their size
+	if KMVsketch.SketchSize != querySketch.SketchSize {
+		return fmt.Errorf("can't merge KMV sketches with different sizes: %d vs. %d", KMVsketch.SketchSize, querySketch.SketchSize)
 	}
 
-	// check each hash value in the incoming sketch
-	for _, hv := range *querySketch.heap {
-
-		// ignore hash values already seen
-		if _, ok := duplicateChecker[hv]; ok {
-			continue
+	// either sketch may have been loaded from disk, in which case it only has the sorted minimums
+	if KMVsketch.heap == nil {
+		KMVsketch.heap = &IntHeap{}
+		KMVsketch.inHeap = make(map[uint64]struct{})
+		for _, hv := range KMVsketch.Sketch {
+			KMVsketch.push(hv)
 		}
-		duplicateChecker[hv] = struct{}{}
+	}
+	mins := querySketch.Sketch
+	if querySketch.heap != nil {
+		mins = *querySketch.heap
+	}
 
-		// if the heap isn't full yet, go ahead and add in the hash from the incoming sketch
-		if len(*KMVsketch.heap) < int(KMVsketch.SketchSize) {
-			heap.Push(KMVsketch.heap, hv)
-			continue
-		}
+	// offer each minimum in the incoming sketch to this one (AddHash ignores duplicates)
+	for _, hv := range mins {
+		KMVsketch.AddHash(hv)
+	}
 
-		// if the heap is full but the new hash is smaller than the largest value in the heap, swap it in
-		if hv < (*KMVsketch.heap)[0] {
-			(*KMVsketch.heap)[0] = hv
-			heap.Fix(KMVsketch.heap, 0)
+	// any previously set sketch is now out of date
+	KMVsketch.Sketch = nil
+	return nil
+}
 
-			// as the incoming sketch is sorted low -> high, we can end the merge early if the heap is full and the current hash is too large
-		} else {
-			break
-		}
-	}
+// push is an unexported method to add a hash to the heap and record that it is held
+func (KMVsketch *KMVsketch) push(hv uint64) {
+	heap.Push(KMVsketch.heap, hv)
+	KMVsketch.inHeap[hv] = struct{}{}
 }
-*/
 
-// Similarity computes a similarity estimate for two KMV sketches
+// contains is an unexported method to check if a hash is already in the heap
+func (KMVsketch *KMVsketch) contains(hv uint64) bool {
+	_, ok := KMVsketch.inHeap[hv]
+	return ok
+}
+
+// GetSimilarity computes a Jaccard similarity estimate for two KMV sketches
+// the bottom-k of the union is found by merging the two sorted sketches, the estimate is then the proportion of these minimums found in both sketches
 func (mh1 *KMVsketch) GetSimilarity(mh2 MinHash) (float64, error) {
 
 	// check this is a pair of KMV
@@ -127,44 +142,40 @@ func (mh1 *KMVsketch) GetSimilarity(mh2 MinHash) (float64, error) {
 		return 0.0, fmt.Errorf("could not assert sketch is a KMV")
 	}
 
-	// check the sketch lengths match
-	//if mh1.SketchSize != sketch2.SketchSize {
-	//	return 0.0, fmt.Errorf("KMV sketch lengths don't match")
-	//}
-
-	// assign longer and shorter heaps
-	longer, shorter := &IntHeap{}, &IntHeap{}
-	if len(*mh1.heap) > len(*sketch2.heap) {
-		longer, shorter = mh1.heap, sketch2.heap
-	} else {
-		shorter, longer = mh1.heap, sketch2.heap
+	// get the sorted sketches (this works for sketches loaded from disk, which don't have a heap)
+	mins1, mins2 := mh1.GetSketch(), sketch2.GetSketch()
+	bottomK := len(mins1)
+	if len(mins2) < bottomK {
+		bottomK = len(mins2)
 	}
-
-	// make a map of one of the sketches, recording each unique minimum and its count
-	minimums := make(map[uint64]int, len(*longer))
-	for _, v := range *longer {
-		minimums[v]++
+	if bottomK == 0 {
+		return 0.0, fmt.Errorf("can't estimate similarity for an empty KMV sketch")
 	}
 
-	// iterate over the other sketch and calculate the intersect
-	intersect := 0
-	for _, minimum := range *shorter {
-		if count, ok := minimums[minimum]; ok && count > 0 {
-			minimums[minimum] = count - 1
+	// walk the union of the sketches in ascending order, counting the shared minimums in the bottom-k
+	intersect, union := 0, 0
+	for i, j := 0, 0; union < bottomK && i < len(mins1) && j < len(mins2); union++ {
+		switch {
+		case mins1[i] == mins2[j]:
 			intersect++
+			i++
+			j++
+		case mins1[i] < mins2[j]:
+			i++
+		default:
+			j++
 		}
 	}
-
-	return (float64(intersect) / float64(len(*longer))), nil
+	return (float64(intersect) / float64(union)), nil
 }
 
 // SetSketch converts the current IntHeap into a []uint64 and sorts it low -> high
+// the SketchSize is left as the configured size, so a sketch that isn't full holds fewer minimums than its size
 func (KMVsketch *KMVsketch) SetSketch() {
 	KMVsketch.Sketch = make([]uint64, len(*KMVsketch.heap))
 	for i, val := range *KMVsketch.heap {
 		KMVsketch.Sketch[i] = val
 	}
-	KMVsketch.SketchSize = uint(len(KMVsketch.Sketch))
 	sort.Slice(KMVsketch.Sketch, func(i, j int) bool { return KMVsketch.Sketch[i] < KMVsketch.Sketch[j] })
 }
 
@@ -191,3 +202,37 @@ func (KMVsketch *KMVsketch) GetMD5() string {
 func (KMVsketch *KMVsketch) GetAlgo() string {
 	return KMVsketch.algo
 }
+
+// kmvState holds the fields of a KMVsketch that are saved by MarshalBinary
+type kmvState struct {
+	KmerSize   uint
+	SketchSize uint
+	Mins       []uint64
+}
+
+// MarshalBinary is a method to encode the sketch, so that more hashes can be added once it is restored
+func (KMVsketch *KMVsketch) MarshalBinary() ([]byte, error) {
+	mins := KMVsketch.Sketch
+	if KMVsketch.heap != nil {
+		mins = *KMVsketch.heap
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&kmvState{KmerSize: KMVsketch.KmerSize, SketchSize: KMVsketch.SketchSize, Mins: mins})
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary is a method to restore a sketch from the state encoded by MarshalBinary
+func (KMVsketch *KMVsketch) UnmarshalBinary(data []byte) error {
+	state := &kmvState{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(state); err != nil {
+		return err
+	}
+	if uint(len(state.Mins)) > state.SketchSize {
+		return fmt.Errorf("KMV sketch holds %d minimums, but its size is %d", len(state.Mins), state.SketchSize)
+	}
+	*KMVsketch = *NewKMVsketch(state.KmerSize, state.SketchSize)
+	for _, hv := range state.Mins {
+		KMVsketch.push(hv)
+	}
+	return nil
+}
@@ -1,4 +1,4 @@
-// Package minhash contains implementations of KMV and KHF MinHash algorithms
+// Package minhash contains implementations of KMV, KHF and HyperMinHash MinHash algorithms
 package minhash
 
 // MinHash is an interface to group the different flavours of MinHash implemented here
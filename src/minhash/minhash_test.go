@@ -1,15 +1,18 @@
 package minhash
 
 import (
+	"math"
+	"math/rand"
+	"sort"
 	"testing"
 )
 
 var (
-	kmerSize   = uint(7)
-	sketchSize = uint(10)
-	sequence   = []byte("ACTGCGTGCGTGAAACGTGCACGTGACGTG")
-	sequence2  = []byte("TGACGCACGCACTTTGCACGTGCACTGCAC")
-	hashvalues = []uint64{12345, 54321, 9999999, 98765}
+	kmerSize    = uint(7)
+	sketchSize  = uint(10)
+	sequence    = []byte("ACTGCGTGCGTGAAACGTGCACGTGACGTG")
+	sequence2   = []byte("TGACGCACGCACTTTGCACGTGCACTGCAC")
+	hashvalues  = []uint64{12345, 54321, 9999999, 98765}
 	hashvalues2 = []uint64{12345, 54321, 111111, 222222}
 )
 
@@ -49,3 +52,257 @@ func BenchmarkKMV(b *testing.B) {
 		}
 	}
 }
+
+func TestKMVsimilarity(t *testing.T) {
+	mhKMV1 := NewKMVsketch(kmerSize, sketchSize)
+	for _, hash := range hashvalues {
+		mhKMV1.AddHash(hash)
+	}
+	mhKMV2 := NewKMVsketch(kmerSize, sketchSize)
+	for _, hash := range hashvalues2 {
+		mhKMV2.AddHash(hash)
+	}
+	js, err := mhKMV1.GetSimilarity(mhKMV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if js != 0.5 {
+		t.Fatalf("incorrect similarity estimate: %f", js)
+	}
+
+	// sketches loaded from JSON only have the sorted minimums
+	loaded := &KMVsketch{KmerSize: kmerSize, Sketch: mhKMV2.GetSketch()}
+	if js, err = mhKMV1.GetSimilarity(loaded); err != nil || js != 0.5 {
+		t.Fatalf("incorrect similarity estimate for loaded sketch: %f (%v)", js, err)
+	}
+}
+
+func TestKMVmerge(t *testing.T) {
+	mhKMV1 := NewKMVsketch(kmerSize, 4)
+	for _, hash := range hashvalues {
+		mhKMV1.AddHash(hash)
+		mhKMV1.AddHash(hash)
+	}
+	if len(mhKMV1.GetSketch()) != len(hashvalues) {
+		t.Fatal("KMV sketch should not hold duplicate hashes")
+	}
+	mhKMV2 := NewKMVsketch(kmerSize, 4)
+	for _, hash := range hashvalues2 {
+		mhKMV2.AddHash(hash)
+	}
+	if err := mhKMV1.Merge(mhKMV2); err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint64{12345, 54321, 98765, 111111}
+	for i, hv := range mhKMV1.GetSketch() {
+		if hv != expected[i] {
+			t.Fatalf("merged sketch is incorrect: %v", mhKMV1.GetSketch())
+		}
+	}
+	if err := mhKMV1.Merge(NewKMVsketch(kmerSize+1, 4)); err == nil {
+		t.Fatal("shouldn't merge sketches with different k-mer sizes")
+	}
+
+	// sketches that aren't full keep their configured size once they are set (e.g. written to JSON), so they can still be merged
+	laneA, laneB := NewKMVsketch(kmerSize, sketchSize), NewKMVsketch(kmerSize, sketchSize)
+	for _, hash := range hashvalues {
+		laneA.AddHash(hash)
+	}
+	for _, hash := range hashvalues2[:3] {
+		laneB.AddHash(hash)
+	}
+	loadedA := &KMVsketch{KmerSize: kmerSize, Sketch: laneA.GetSketch(), SketchSize: laneA.SketchSize}
+	loadedB := &KMVsketch{KmerSize: kmerSize, Sketch: laneB.GetSketch(), SketchSize: laneB.SketchSize}
+	if loadedA.SketchSize != sketchSize || loadedB.SketchSize != sketchSize {
+		t.Fatalf("sketches that aren't full should keep their configured size: %d and %d", loadedA.SketchSize, loadedB.SketchSize)
+	}
+	if err := loadedA.Merge(loadedB); err != nil {
+		t.Fatal(err)
+	}
+	if len(loadedA.GetSketch()) != 5 {
+		t.Fatalf("merged sketch should hold the 5 distinct hashes: %v", loadedA.GetSketch())
+	}
+}
+
+// check that duplicate hashes are ignored after larger hashes have been evicted from a full sketch
+func TestKMVduplicates(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	hashes := make([]uint64, 1000)
+	for i := range hashes {
+		hashes[i] = rnd.Uint64()
+	}
+	mhKMV := NewKMVsketch(kmerSize, sketchSize)
+	for _, i := range append(rnd.Perm(len(hashes)), rnd.Perm(len(hashes))...) {
+		mhKMV.AddHash(hashes[i])
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	for i, hv := range mhKMV.GetSketch() {
+		if hv != hashes[i] {
+			t.Fatalf("KMV sketch does not hold the bottom-k hashes: %v", mhKMV.GetSketch())
+		}
+	}
+}
+
+func TestKHFmerge(t *testing.T) {
+	mhKHF1 := NewKHFsketch(kmerSize, sketchSize)
+	mhKHF2 := NewKHFsketch(kmerSize, sketchSize)
+	mhKHFall := NewKHFsketch(kmerSize, sketchSize)
+	for _, hash := range hashvalues {
+		mhKHF1.AddHash(hash)
+		mhKHFall.AddHash(hash)
+	}
+	for _, hash := range hashvalues2 {
+		mhKHF2.AddHash(hash)
+		mhKHFall.AddHash(hash)
+	}
+	if err := mhKHF1.Merge(mhKHF2); err != nil {
+		t.Fatal(err)
+	}
+	for i, hv := range mhKHF1.GetSketch() {
+		if hv != mhKHFall.Sketch[i] {
+			t.Fatalf("merged sketch does not match a sketch of both sets")
+		}
+	}
+	if err := mhKHF1.Merge(NewKHFsketch(kmerSize, sketchSize+1)); err == nil {
+		t.Fatal("shouldn't merge sketches with different sizes")
+	}
+	if err := mhKHF1.Merge(NewKHFsketch(kmerSize+1, sketchSize)); err == nil {
+		t.Fatal("shouldn't merge sketches with different k-mer sizes")
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	mhKMV := NewKMVsketch(kmerSize, 4)
+	mhKHF := NewKHFsketch(kmerSize, sketchSize)
+	for _, hash := range hashvalues {
+		mhKMV.AddHash(hash)
+		mhKHF.AddHash(hash)
+	}
+	kmvState, err := mhKMV.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	khfState, err := mhKHF.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredKMV, restoredKHF := &KMVsketch{}, &KHFsketch{}
+	if err := restoredKMV.UnmarshalBinary(kmvState); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoredKHF.UnmarshalBinary(khfState); err != nil {
+		t.Fatal(err)
+	}
+
+	// the restored sketches should keep sketching in the same way as the originals
+	for _, hash := range hashvalues2 {
+		mhKMV.AddHash(hash)
+		mhKHF.AddHash(hash)
+		restoredKMV.AddHash(hash)
+		restoredKHF.AddHash(hash)
+	}
+	if restoredKMV.SketchSize != 4 || restoredKMV.GetAlgo() != "kmv" || restoredKHF.GetAlgo() != "khf" {
+		t.Fatal("restored sketches have different settings")
+	}
+	for i, hv := range mhKMV.GetSketch() {
+		if hv != restoredKMV.GetSketch()[i] {
+			t.Fatalf("restored KMV sketch is incorrect: %v", restoredKMV.GetSketch())
+		}
+	}
+	for i, hv := range mhKHF.GetSketch() {
+		if hv != restoredKHF.GetSketch()[i] {
+			t.Fatalf("restored KHF sketch is incorrect: %v", restoredKHF.GetSketch())
+		}
+	}
+}
+
+func TestHyperMinHash(t *testing.T) {
+	hmh := NewHyperMinHash(kmerSize, sketchSize)
+	if hmh.SketchSize != 16 || len(hmh.GetSketch()) != 16 || hmh.GetAlgo() != "hyperminhash" {
+		t.Fatalf("NewHyperMinHash constructor did not round the sketch size up to 16 registers: %d", hmh.SketchSize)
+	}
+	if NewHyperMinHash(kmerSize, 1000).SketchSize != 1024 {
+		t.Fatal("NewHyperMinHash constructor did not round the sketch size up to a power of 2")
+	}
+
+	// sketch two sets of 20000 hashes that share 10000
+	hmh1, hmh2 := NewHyperMinHash(kmerSize, 1024), NewHyperMinHash(kmerSize, 1024)
+	for i := uint64(0); i < 20000; i++ {
+		hmh1.AddHash(i)
+		hmh2.AddHash(i + 10000)
+	}
+	checkEstimate := func(name string, estimate, expected float64) {
+		if math.Abs(estimate-expected)/expected > 0.1 {
+			t.Fatalf("incorrect %v estimate: %f (expected %f)", name, estimate, expected)
+		}
+	}
+	checkEstimate("cardinality", hmh1.Cardinality(), 20000)
+	union, err := hmh1.UnionCardinality(hmh2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEstimate("union", union, 30000)
+	intersection, err := hmh1.IntersectionCardinality(hmh2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEstimate("intersection", intersection, 10000)
+	js, err := hmh1.GetSimilarity(hmh2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEstimate("similarity", js, 1.0/3.0)
+
+	// disjoint sets should only share the registers expected by chance
+	hmh3 := NewHyperMinHash(kmerSize, 1024)
+	for i := uint64(0); i < 20000; i++ {
+		hmh3.AddHash(i + 1000000)
+	}
+	if js, err := hmh1.GetSimilarity(hmh3); err != nil || js > 0.02 {
+		t.Fatalf("incorrect similarity estimate for disjoint sets: %f (%v)", js, err)
+	}
+	if _, err := hmh1.GetSimilarity(NewHyperMinHash(kmerSize+1, 1024)); err == nil {
+		t.Fatal("expected an error when comparing sketches with different k-mer sizes")
+	}
+	if _, err := hmh1.GetSimilarity(NewKHFsketch(kmerSize, 1024)); err == nil {
+		t.Fatal("expected an error when comparing a HyperMinHash with a KHF sketch")
+	}
+
+	// merging gives the sketch of the union
+	if err := hmh1.Merge(hmh2); err != nil {
+		t.Fatal(err)
+	}
+	if hmh1.Cardinality() != union {
+		t.Fatalf("merged sketch does not match the union estimate: %f vs. %f", hmh1.Cardinality(), union)
+	}
+}
+
+func TestHyperMinHashMarshalBinary(t *testing.T) {
+	hmh := NewHyperMinHash(kmerSize, 64)
+	for _, hash := range hashvalues {
+		hmh.AddHash(hash)
+	}
+	state, err := hmh.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &HyperMinHash{}
+	if err := restored.UnmarshalBinary(state); err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range hashvalues2 {
+		hmh.AddHash(hash)
+		restored.AddHash(hash)
+	}
+	if restored.SketchSize != 64 || restored.KmerSize != kmerSize || restored.GetAlgo() != "hyperminhash" {
+		t.Fatal("restored sketch has different settings")
+	}
+	for i, register := range hmh.GetSketch() {
+		if register != restored.GetSketch()[i] {
+			t.Fatalf("restored HyperMinHash sketch is incorrect: %v", restored.GetSketch())
+		}
+	}
+	if err := (&HyperMinHash{Registers: make([]uint64, 20)}).Restore(); err == nil {
+		t.Fatal("expected an error for a sketch without a power of 2 registers")
+	}
+}
@@ -1,4 +1,4 @@
-// Package minimizer takes a sequence and finds the minimizers (for w consecutive k-mers)
+// Package minimizer takes a sequence and finds the minimizers (for w consecutive k-mers), or other seeds such as syncmers and randstrobes (see Seeder)
 // NOTE: currently it uses mapset to store the minimizers - this is unordered, which is fine for HULK but the minimizer sketch isn't that useful for anything else yet
 package minimizer
 
@@ -29,6 +29,24 @@ var seq_nt4_table = [256]uint8{
 	4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4,
 }
 
+// HASH_FUNCS are the hash functions available for hashing canonical k-mers
+// hash64 is the invertible integer hash from minimap2 and ntHash is a rolling hash, which can be seeded
+var HASH_FUNCS = []string{"hash64", "ntHash"}
+
+// CheckHash is a function to check that a hash function is available and can use the supplied seed
+func CheckHash(hashFunc string, seed uint64) error {
+	switch hashFunc {
+	case "hash64":
+		if seed != 0 {
+			return fmt.Errorf("the hash64 function can't be seeded")
+		}
+	case "ntHash":
+	default:
+		return fmt.Errorf("unknown hash function: %v (please select one of the following: %v)", hashFunc, HASH_FUNCS)
+	}
+	return nil
+}
+
 // hash64 - from minimap2
 func hash64(key, mask uint64) uint64 {
 	key = (^key + (key << 21)) & mask // key = (key << 21) - key - 1;
@@ -43,11 +61,13 @@ func hash64(key, mask uint64) uint64 {
 
 // minimizerSketch
 type minimizerSketch struct {
-	k      int32 // k-mer size
-	w      int32 // number of consecutive k-mers to minimize
-	seq    []byte
-	seqLen int32
-	sketch mapset.Set // NOTE: this implementation of set is unordered TODO: get an ordered set, so the minimizer sketch can be kept
+	k       int32 // k-mer size
+	w       int32 // number of consecutive k-mers to minimize
+	seq     []byte
+	seqLen  int32
+	sketch  mapset.Set // NOTE: this implementation of set is unordered TODO: get an ordered set, so the minimizer sketch can be kept
+	skipped int        // the number of k-mers skipped because they contain an ambiguous base (i.e. not ACGT)
+	nt      *ntHasher  // the rolling ntHash (nil if the hash64 function is used)
 }
 
 // GetMinimizers returns the sketch minimizers via a channel
@@ -55,8 +75,18 @@ func (minimizerSketch *minimizerSketch) GetMinimizers() <-chan interface{} {
 	return minimizerSketch.sketch.Iter()
 }
 
-// NewMinimizerSketch is the constructor for a minimizerSketch
+// GetSkipped returns the number of k-mers that were skipped because they contain an ambiguous base
+func (minimizerSketch *minimizerSketch) GetSkipped() int {
+	return minimizerSketch.skipped
+}
+
+// NewMinimizerSketch is the constructor for a minimizerSketch, which uses the hash64 function
 func NewMinimizerSketch(k, w uint, seq []byte) (*minimizerSketch, error) {
+	return NewMinimizerSketchWithHash(k, w, "hash64", 0, seq)
+}
+
+// NewMinimizerSketchWithHash is the constructor for a minimizerSketch that uses the specified hash function and seed
+func NewMinimizerSketchWithHash(k, w uint, hashFunc string, seed uint64, seq []byte) (*minimizerSketch, error) {
 
 	// check the parameters
 	if w < 0 || w > 256 {
@@ -65,14 +95,8 @@ func NewMinimizerSketch(k, w uint, seq []byte) (*minimizerSketch, error) {
 	if k < 0 || k > 31 {
 		return nil, fmt.Errorf("k size must be: 0 < k < 32")
 	}
-
-	// check length of sequence
-	len := int32(len(seq))
-	if len < 1 {
-		return nil, fmt.Errorf("sequence length must be > 0")
-	}
-	if len < int32(w+k-1) {
-		return nil, fmt.Errorf("sequence length must be >= w + k - 1")
+	if err := CheckHash(hashFunc, seed); err != nil {
+		return nil, err
 	}
 
 	// create the sketcher
@@ -80,9 +104,17 @@ func NewMinimizerSketch(k, w uint, seq []byte) (*minimizerSketch, error) {
 		k:      int32(k),
 		w:      int32(w),
 		seq:    seq,
-		seqLen: len,
+		seqLen: int32(len(seq)),
 		sketch: mapset.NewThreadUnsafeSet(),
 	}
+	if hashFunc == "ntHash" {
+		sketcher.nt = newNThasher(k, seed)
+	}
+
+	// sequences shorter than a single window (w + k - 1) have no minimizers, so return the empty sketch
+	if sketcher.seqLen < int32(w+k-1) {
+		return sketcher, nil
+	}
 
 	// find the minimizers
 	err := sketcher.findMinimizers()
@@ -105,21 +137,29 @@ func (minimizerSketch *minimizerSketch) findMinimizers() error {
 
 	q := queue.NewQueue()
 
+	// validBases is the number of consecutive unambiguous bases seen, which is reset whenever an ambiguous base is found
+	validBases := int32(0)
+
 	// start processing the sequence
 	for i := int32(0); i < minimizerSketch.seqLen; i++ {
 
-		// windowIndex helps keeps track of how many consecutive k-mers have been processed
-		windowIndex := i - minimizerSketch.w + 1
-
 		// get the nucleotide and convert to uint8
 		c := seq_nt4_table[minimizerSketch.seq[i]]
 
-		// if the nucleotide == N
+		// if the nucleotide is ambiguous (N or any other IUPAC code), reset the rolling k-mers and the window
 		if c > 3 {
-
-			// TODO: handle these, by skiping the base and starting w again?
-
+			validBases = 0
+			kmers[0], kmers[1] = 0, 0
+			q = queue.NewQueue()
+			if i >= minimizerSketch.k-1 {
+				minimizerSketch.skipped++
+			}
+			continue
 		}
+		validBases++
+
+		// windowIndex helps keeps track of how many consecutive k-mers have been processed since the last reset
+		windowIndex := validBases - minimizerSketch.w
 
 		// TODO: could do some homopolymer handling here (ala minimap2)
 
@@ -136,11 +176,23 @@ func (minimizerSketch *minimizerSketch) findMinimizers() error {
 		// get the reverse k-mer
 		kmers[1] = (kmers[1] >> 2) | (uint64(3)^uint64(c))<<bitshift
 
-		// don't try for minimizers until a full k-mer has been collected
-		if i < minimizerSketch.k-1 {
+		// don't try for minimizers until a full k-mer has been collected (any k-mer spanning an ambiguous base is skipped)
+		if validBases < minimizerSketch.k {
+			if i >= minimizerSketch.k-1 {
+				minimizerSketch.skipped++
+			}
 			continue
 		}
 
+		// update the rolling ntHash, starting with a fresh k-mer after each reset
+		if minimizerSketch.nt != nil {
+			if validBases == minimizerSketch.k {
+				minimizerSketch.nt.init(minimizerSketch.seq[i-minimizerSketch.k+1 : i+1])
+			} else {
+				minimizerSketch.nt.roll(seq_nt4_table[minimizerSketch.seq[i-minimizerSketch.k]], c)
+			}
+		}
+
 		// skip symmetric k-mers as we don't know the the strand
 		if kmers[0] == kmers[1] {
 			continue
@@ -153,8 +205,14 @@ func (minimizerSketch *minimizerSketch) findMinimizers() error {
 		}
 
 		// hash the canonical k-mer
+		var hashValue uint64
+		if minimizerSketch.nt != nil {
+			hashValue = minimizerSketch.nt.hash()
+		} else {
+			hashValue = hash64(kmers[strand], bitmask)
+		}
 		currentKmer := queue.Pair{
-			X: hash64(kmers[strand], bitmask)<<8 | uint64(kmerSpan),
+			X: hashValue<<8 | uint64(kmerSpan),
 			Y: i, // this is the location of the minimizer in the sequence
 		}
 
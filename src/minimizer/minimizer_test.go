@@ -1,6 +1,7 @@
 package minimizer
 
 import (
+	"math/rand"
 	"testing"
 )
 
@@ -59,3 +60,305 @@ func TestSketching(t *testing.T) {
 	}
 
 }
+
+func TestAmbiguousBases(t *testing.T) {
+	seg1, seg2 := []byte("ACTGAAAATTTTGCGC"), []byte("TTGACCCAGTAGGATC")
+	sketch1, err := NewMinimizerSketch(k, w, seg1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sketch2, err := NewMinimizerSketch(k, w, seg2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make(map[uint64]struct{})
+	for minimizer := range sketch1.GetMinimizers() {
+		expected[minimizer.(uint64)] = struct{}{}
+	}
+	for minimizer := range sketch2.GetMinimizers() {
+		expected[minimizer.(uint64)] = struct{}{}
+	}
+
+	// the N should reset the scanner, so the minimizers are those of the two segments
+	joined := append(append(append([]byte{}, seg1...), 'N'), seg2...)
+	sketch, err := NewMinimizerSketch(k, w, joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for minimizer := range sketch.GetMinimizers() {
+		if _, ok := expected[minimizer.(uint64)]; !ok {
+			t.Fatal("spurious minimizer found for k-mer spanning an N")
+		}
+		found++
+	}
+	if found != len(expected) {
+		t.Fatalf("expected %d minimizers, found %d", len(expected), found)
+	}
+
+	// the k k-mers overlapping the N should be skipped
+	if sketch.GetSkipped() != int(k) {
+		t.Fatalf("expected %d skipped k-mers, got %d", k, sketch.GetSkipped())
+	}
+}
+
+func TestShortSequence(t *testing.T) {
+	for _, short := range [][]byte{[]byte(""), []byte("ACG"), seq[:w+k-2]} {
+		sketch, err := NewMinimizerSketch(k, w, short)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sketch.sketch.Cardinality() != 0 {
+			t.Fatal("short sequence should not have any minimizers")
+		}
+	}
+}
+
+// revComp returns the reverse complement of a sequence
+func revComp(seq []byte) []byte {
+	comp := map[byte]byte{'A': 'T', 'C': 'G', 'G': 'C', 'T': 'A'}
+	rc := make([]byte, len(seq))
+	for i, base := range seq {
+		rc[len(seq)-1-i] = comp[base]
+	}
+	return rc
+}
+
+// minimizerSet collects the minimizers for a sequence using the supplied window size and hash function
+func minimizerSet(t *testing.T, wSize uint, hashFunc string, seq []byte) map[uint64]struct{} {
+	sketch, err := NewMinimizerSketchWithHash(k, wSize, hashFunc, 0, seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := make(map[uint64]struct{})
+	for minimizer := range sketch.GetMinimizers() {
+		set[minimizer.(uint64)] = struct{}{}
+	}
+	return set
+}
+
+func TestNThash(t *testing.T) {
+	testSeq := []byte("ACGTTGCATGGCATTACGGATCCAGTTAGCAAGTCCGATGCAAAT")
+	kSize := uint(11)
+
+	// rolling the hash should give the same values as hashing each k-mer from scratch
+	rolling, fresh := newNThasher(kSize, 0), newNThasher(kSize, 0)
+	rolling.init(testSeq[:kSize])
+	for i := 1; i+int(kSize) <= len(testSeq); i++ {
+		rolling.roll(seq_nt4_table[testSeq[i-1]], seq_nt4_table[testSeq[i+int(kSize)-1]])
+		fresh.init(testSeq[i : i+int(kSize)])
+		if rolling.hash() != fresh.hash() {
+			t.Fatalf("rolling hash does not match for k-mer %d", i)
+		}
+
+		// a k-mer and its reverse complement should have the same canonical hash
+		rc := newNThasher(kSize, 0)
+		rc.init(revComp(testSeq[i : i+int(kSize)]))
+		if rc.hash() != fresh.hash() {
+			t.Fatalf("canonical hash does not match the reverse complement for k-mer %d", i)
+		}
+	}
+
+	// seeding should change the hash values
+	seeded := newNThasher(kSize, 42)
+	seeded.init(testSeq[:kSize])
+	fresh.init(testSeq[:kSize])
+	if seeded.hash() == fresh.hash() {
+		t.Fatal("seeded hash should differ from the unseeded hash")
+	}
+}
+
+func TestNThashMinimizers(t *testing.T) {
+	testSeq := []byte("ACGTTGCATGGCATTACGGATCCAGTTAGCAAGTCCGATGCAAATGGCATTACGGATCCAGTT")
+
+	// with a window of 1, every k-mer is a minimizer, so the sequence and its reverse complement should match as the hash is canonical
+	forward := minimizerSet(t, 1, "ntHash", testSeq)
+	reverse := minimizerSet(t, 1, "ntHash", revComp(testSeq))
+	if len(forward) == 0 || len(forward) != len(reverse) {
+		t.Fatalf("expected the same number of minimizers on each strand: %d vs. %d", len(forward), len(reverse))
+	}
+	for minimizer := range forward {
+		if _, ok := reverse[minimizer]; !ok {
+			t.Fatal("minimizers of the reverse complement do not match")
+		}
+	}
+
+	// hash64 minimizers should be unchanged by the choice of constructor, and differ from ntHash
+	hash64Set := minimizerSet(t, w, "hash64", testSeq)
+	defaultSketch, err := NewMinimizerSketch(k, w, testSeq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for minimizer := range defaultSketch.GetMinimizers() {
+		if _, ok := hash64Set[minimizer.(uint64)]; !ok {
+			t.Fatal("NewMinimizerSketch should use hash64")
+		}
+	}
+	ntHashSet := minimizerSet(t, w, "ntHash", testSeq)
+	same := true
+	for minimizer := range hash64Set {
+		if _, ok := ntHashSet[minimizer]; !ok {
+			same = false
+		}
+	}
+	if same {
+		t.Fatal("ntHash and hash64 minimizers should differ")
+	}
+
+	// bad hash settings
+	if _, err := NewMinimizerSketchWithHash(k, w, "hash64", 1, testSeq); err == nil {
+		t.Fatal("hash64 should not accept a seed")
+	}
+	if _, err := NewMinimizerSketchWithHash(k, w, "md5", 0, testSeq); err == nil {
+		t.Fatal("unknown hash function should be rejected")
+	}
+}
+
+// randomSeq generates a random ACGT sequence
+func randomSeq(length int, seed int64) []byte {
+	r := rand.New(rand.NewSource(seed))
+	seq := make([]byte, length)
+	for i := range seq {
+		seq[i] = "ACGT"[r.Intn(4)]
+	}
+	return seq
+}
+
+// seedSet runs a seeder over a sequence and collects the seeds
+func seedSet(t *testing.T, seeder Seeder, seq []byte) map[uint64]struct{} {
+	seeds, _, err := seeder.Seed(seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := make(map[uint64]struct{})
+	for _, seed := range seeds {
+		if _, ok := set[seed]; ok {
+			t.Fatal("seeder returned a duplicate seed")
+		}
+		set[seed] = struct{}{}
+	}
+	return set
+}
+
+func TestMinimizerSeeder(t *testing.T) {
+	testSeq := randomSeq(500, 1)
+	params, err := NewSeederParams("minimizer", k, w, 0, -1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeder, err := NewSeeder(params, "hash64", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeds := seedSet(t, seeder, testSeq)
+	expected := minimizerSet(t, w, "hash64", testSeq)
+	if len(seeds) != len(expected) {
+		t.Fatalf("minimizer seeder found %d seeds, expected %d", len(seeds), len(expected))
+	}
+	for seed := range seeds {
+		if _, ok := expected[seed]; !ok {
+			t.Fatal("minimizer seeder does not match the minimizer sketch")
+		}
+	}
+}
+
+func TestSyncmerSeeder(t *testing.T) {
+	testSeq := randomSeq(20000, 2)
+	kSize, sSize := uint(15), uint(7) // k-s is even, so that the open syncmer offset is in the middle of the k-mer on both strands
+	for _, name := range []string{"closed-syncmer", "open-syncmer"} {
+		for _, hashFunc := range HASH_FUNCS {
+			params, err := NewSeederParams(name, kSize, 0, sSize, -1, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seeder, err := NewSeeder(params, hashFunc, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// syncmers use canonical hashes, so the seeds should be the same for both strands
+			forward := seedSet(t, seeder, testSeq)
+			reverse := seedSet(t, seeder, revComp(testSeq))
+			if len(forward) != len(reverse) {
+				t.Fatalf("%v (%v): different number of seeds for each strand: %d vs. %d", name, hashFunc, len(forward), len(reverse))
+			}
+			for seed := range forward {
+				if _, ok := reverse[seed]; !ok {
+					t.Fatalf("%v (%v): seeds don't match for each strand", name, hashFunc)
+				}
+			}
+
+			// check the density is close to the expected 2/(k-s+1) for closed syncmers and 1/(k-s+1) for open syncmers
+			expected := 1.0 / float64(kSize-sSize+1)
+			if name == "closed-syncmer" {
+				expected *= 2
+			}
+			density := float64(len(forward)) / float64(len(testSeq)-int(kSize)+1)
+			if density < expected*0.8 || density > expected*1.2 {
+				t.Fatalf("%v (%v): density is %.3f, expected approx. %.3f", name, hashFunc, density, expected)
+			}
+		}
+	}
+}
+
+func TestRandstrobeSeeder(t *testing.T) {
+	testSeq := randomSeq(1000, 3)
+	params, err := NewSeederParams("randstrobe", 10, 0, 0, -1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.WMin != 10 || params.WMax != 20 {
+		t.Fatalf("incorrect default randstrobe window: %d-%d", params.WMin, params.WMax)
+	}
+	seeder, err := NewSeeder(params, "ntHash", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeds := seedSet(t, seeder, testSeq)
+	if len(seeds) < len(testSeq)-int(params.K+params.WMax) {
+		t.Fatalf("too few randstrobes found: %d", len(seeds))
+	}
+
+	// a mutation should only change the strobes that cover it
+	mutated := append([]byte{}, testSeq...)
+	mutated[500] = 'A'
+	if testSeq[500] == 'A' {
+		mutated[500] = 'C'
+	}
+	shared := 0
+	for seed := range seedSet(t, seeder, mutated) {
+		if _, ok := seeds[seed]; ok {
+			shared++
+		}
+	}
+	if shared < len(seeds)-int(params.K+params.WMax)*2 {
+		t.Fatalf("a single mutation changed too many randstrobes: %d of %d shared", shared, len(seeds))
+	}
+}
+
+func TestSeederParams(t *testing.T) {
+	params, err := NewSeederParams("closed-syncmer", 21, 9, 0, -1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.S != 12 || params.W != 0 {
+		t.Fatalf("incorrect syncmer params: %+v", params)
+	}
+	params, err = NewSeederParams("open-syncmer", 21, 9, 11, -1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.T != 5 {
+		t.Fatalf("open syncmer offset should default to the middle of the k-mer, not %d", params.T)
+	}
+	for _, bad := range []func() (SeederParams, error){
+		func() (SeederParams, error) { return NewSeederParams("spaced-seed", 21, 9, 0, -1, 0, 0) },
+		func() (SeederParams, error) { return NewSeederParams("closed-syncmer", 21, 9, 21, -1, 0, 0) },
+		func() (SeederParams, error) { return NewSeederParams("open-syncmer", 21, 9, 15, 7, 0, 0) },
+		func() (SeederParams, error) { return NewSeederParams("randstrobe", 21, 9, 0, -1, 30, 20) },
+	} {
+		if _, err := bad(); err == nil {
+			t.Fatal("expected an error for bad seeder params")
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package minimizer
+
+/*
+ this is a rolling implementation of ntHash (Mohamadi et al. 2016, doi: 10.1093/bioinformatics/btw397), which hashes canonical k-mers
+*/
+
+import (
+	"math/bits"
+)
+
+// ntSeeds are the ntHash base seeds, indexed by the 2-bit base encoding used by seq_nt4_table (A, C, G, T)
+var ntSeeds = [4]uint64{
+	0x3c8bfbb395c60474,
+	0x3193c18562a02b4c,
+	0x20323ed082572324,
+	0x295549f54be24456,
+}
+
+// ntMultiSeed and ntMultiShift are used by ntHash to derive additional hash values from the canonical hash, which is how the hash is seeded
+const (
+	ntMultiSeed  uint64 = 0x90b45d39fb6da1fa
+	ntMultiShift uint   = 27
+)
+
+// ntHasher holds the forward and reverse strand hash values for the current k-mer
+type ntHasher struct {
+	k    uint
+	seed uint64
+	fh   uint64 // forward strand hash value
+	rh   uint64 // reverse strand hash value
+}
+
+// newNThasher is the constructor function
+func newNThasher(k uint, seed uint64) *ntHasher {
+	return &ntHasher{k: k, seed: seed}
+}
+
+// init is a method to hash the first k-mer, which must only contain ACGT bases
+func (ntHasher *ntHasher) init(kmer []byte) {
+	ntHasher.fh, ntHasher.rh = 0, 0
+	for i, base := range kmer {
+		c := seq_nt4_table[base]
+		ntHasher.fh ^= bits.RotateLeft64(ntSeeds[c], int(ntHasher.k)-1-i)
+		ntHasher.rh ^= bits.RotateLeft64(ntSeeds[3-c], i)
+	}
+}
+
+// roll is a method to move the k-mer along by one base, removing the out base and adding the in base (both 2-bit encoded)
+func (ntHasher *ntHasher) roll(out, in uint8) {
+	ntHasher.fh = bits.RotateLeft64(ntHasher.fh, 1) ^ bits.RotateLeft64(ntSeeds[out], int(ntHasher.k)) ^ ntSeeds[in]
+	ntHasher.rh = bits.RotateLeft64(ntHasher.rh, -1) ^ bits.RotateLeft64(ntSeeds[3-out], -1) ^ bits.RotateLeft64(ntSeeds[3-in], int(ntHasher.k)-1)
+}
+
+// hash is a method to return the canonical hash value of the current k-mer (the smaller of the forward and reverse values)
+// a non-zero seed uses the ntHash multi-hash scheme to give a different, independent hash value
+func (ntHasher *ntHasher) hash() uint64 {
+	h := ntHasher.fh
+	if ntHasher.rh < h {
+		h = ntHasher.rh
+	}
+	if ntHasher.seed != 0 {
+		h *= ntHasher.seed ^ uint64(ntHasher.k)*ntMultiSeed
+		h ^= h >> ntMultiShift
+	}
+	return h
+}
@@ -0,0 +1,276 @@
+package minimizer
+
+/*
+ this part of the package provides the different schemes for selecting seeds from a sequence:
+ -minimizers, the smallest k-mer in each window of w consecutive k-mers
+ -closed syncmers, k-mers whose smallest s-mer is at the start or end of the k-mer (Edgar 2021, doi: 10.7717/peerj.10805)
+ -open syncmers, k-mers whose smallest s-mer is at offset t in the k-mer
+ -randstrobes, pairs of k-mers where the second strobe is chosen from a downstream window (Sahlin 2021, doi: 10.1101/gr.275648.121)
+*/
+
+import (
+	"fmt"
+)
+
+// SEEDERS are the available seed selection schemes
+var SEEDERS = []string{"minimizer", "closed-syncmer", "open-syncmer", "randstrobe"}
+
+// Seeder is the interface for selecting seeds from a sequence
+type Seeder interface {
+
+	// Seed returns the distinct seeds (as hash values) selected from a sequence and the number of k-mers skipped because they contain an ambiguous base
+	Seed(seq []byte) ([]uint64, int, error)
+
+	// GetParams returns the settings used by the seeder
+	GetParams() SeederParams
+}
+
+// SeederParams holds the settings for a seeder, only the settings used by the named seeder are set
+type SeederParams struct {
+	Name string `json:"name"`            // the seed selection scheme (see SEEDERS)
+	K    uint   `json:"k"`               // the k-mer size (the strobe length for randstrobes)
+	W    uint   `json:"w,omitempty"`     // the minimizer window size
+	S    uint   `json:"s,omitempty"`     // the syncmer s-mer size
+	T    uint   `json:"t,omitempty"`     // the position of the smallest s-mer in an open syncmer
+	WMin uint   `json:"w_min,omitempty"` // the start of the window for the second randstrobe, as an offset from the first strobe
+	WMax uint   `json:"w_max,omitempty"` // the end of the window for the second randstrobe, as an offset from the first strobe
+}
+
+// NewSeederParams is the constructor function, which checks the settings needed by the named seeder and fills in the defaults for any left as 0
+// the syncmer s-mer size defaults to k-w (giving closed syncmers the same density as minimizers), the open syncmer offset defaults to the middle of the k-mer (t < 0) and the randstrobe window defaults to k..2k
+// NOTE: open syncmers are only independent of the strand if the offset is in the middle of the k-mer, which needs k-s to be even
+func NewSeederParams(name string, k, w, s uint, t int, wMin, wMax uint) (SeederParams, error) {
+	params := SeederParams{Name: name, K: k}
+	if k < 1 || k > 31 {
+		return params, fmt.Errorf("k size must be: 0 < k < 32")
+	}
+	switch name {
+	case "minimizer":
+		params.W = w
+	case "closed-syncmer", "open-syncmer":
+		if s == 0 {
+			s = 1
+			if k > w {
+				s = k - w
+			}
+		}
+		if s >= k {
+			return params, fmt.Errorf("syncmer s-mer size must be smaller than k (%d vs. %d)", s, k)
+		}
+		params.S = s
+		if name == "open-syncmer" {
+			if t < 0 {
+				t = int(k-s) / 2
+			}
+			if t > int(k-s) {
+				return params, fmt.Errorf("open syncmer offset must be between 0 and k-s (%d)", k-s)
+			}
+			params.T = uint(t)
+		}
+	case "randstrobe":
+		if wMin == 0 {
+			wMin = k
+		}
+		if wMax == 0 {
+			wMax = 2 * k
+		}
+		if wMax < wMin {
+			return params, fmt.Errorf("randstrobe window end must not be before the window start (%d vs. %d)", wMax, wMin)
+		}
+		params.WMin, params.WMax = wMin, wMax
+	default:
+		return params, fmt.Errorf("unknown seeder: %v (please select one of the following: %v)", name, SEEDERS)
+	}
+	return params, nil
+}
+
+// NewSeeder is the constructor function, which returns the seeder described by the params, using the supplied hash function and seed
+func NewSeeder(params SeederParams, hashFunc string, seed uint64) (Seeder, error) {
+	if err := CheckHash(hashFunc, seed); err != nil {
+		return nil, err
+	}
+	settings := seederSettings{params: params, hashFunc: hashFunc, seed: seed}
+	switch params.Name {
+	case "minimizer":
+		return &minimizerSeeder{settings}, nil
+	case "closed-syncmer", "open-syncmer":
+		return &syncmerSeeder{settings}, nil
+	case "randstrobe":
+		return &randstrobeSeeder{settings}, nil
+	default:
+		return nil, fmt.Errorf("unknown seeder: %v (please select one of the following: %v)", params.Name, SEEDERS)
+	}
+}
+
+// seederSettings holds the settings common to all the seeders
+type seederSettings struct {
+	params   SeederParams
+	hashFunc string
+	seed     uint64
+}
+
+// GetParams is a method to return the settings used by a seeder
+func (seederSettings *seederSettings) GetParams() SeederParams {
+	return seederSettings.params
+}
+
+// minimizerSeeder selects minimizers
+type minimizerSeeder struct {
+	seederSettings
+}
+
+// Seed is a method to select the minimizers from a sequence
+func (minimizerSeeder *minimizerSeeder) Seed(seq []byte) ([]uint64, int, error) {
+	sketch, err := NewMinimizerSketchWithHash(minimizerSeeder.params.K, minimizerSeeder.params.W, minimizerSeeder.hashFunc, minimizerSeeder.seed, seq)
+	if err != nil {
+		return nil, 0, err
+	}
+	seeds := make([]uint64, 0, sketch.sketch.Cardinality())
+	for _, minimizer := range sketch.sketch.ToSlice() {
+		seeds = append(seeds, minimizer.(uint64))
+	}
+	return seeds, sketch.GetSkipped(), nil
+}
+
+// syncmerSeeder selects open or closed syncmers
+type syncmerSeeder struct {
+	seederSettings
+}
+
+// Seed is a method to select the syncmers from a sequence
+func (syncmerSeeder *syncmerSeeder) Seed(seq []byte) ([]uint64, int, error) {
+	k, s := syncmerSeeder.params.K, syncmerSeeder.params.S
+	kHashes, kValid, skipped := kmerHashes(seq, k, syncmerSeeder.hashFunc, syncmerSeeder.seed, true)
+	sHashes, _, _ := kmerHashes(seq, s, syncmerSeeder.hashFunc, syncmerSeeder.seed, false)
+	span := int(k - s)
+	closed := syncmerSeeder.params.Name == "closed-syncmer"
+	selected := make(map[uint64]struct{})
+	seeds := []uint64{}
+	for i, valid := range kValid {
+		if !valid {
+			continue
+		}
+
+		// find the smallest s-mer in the k-mer, a tie is allowed so that the result doesn't depend on the strand
+		minHash := sHashes[i]
+		for j := 1; j <= span; j++ {
+			if sHashes[i+j] < minHash {
+				minHash = sHashes[i+j]
+			}
+		}
+		if closed && sHashes[i] != minHash && sHashes[i+span] != minHash {
+			continue
+		}
+		if !closed && sHashes[i+int(syncmerSeeder.params.T)] != minHash {
+			continue
+		}
+		if _, ok := selected[kHashes[i]]; ok {
+			continue
+		}
+		selected[kHashes[i]] = struct{}{}
+		seeds = append(seeds, kHashes[i])
+	}
+	return seeds, skipped, nil
+}
+
+// randstrobeSeeder selects order 2 randstrobes
+type randstrobeSeeder struct {
+	seederSettings
+}
+
+// Seed is a method to select the randstrobes from a sequence
+// the second strobe is the k-mer in the window that minimises the XOR of the two strobe hashes, and the strobes are combined asymmetrically (h1/2 + h2/3)
+// NOTE: the strobes are collected from the forward strand of each sequence, so unlike the other seeders they are not canonical
+func (randstrobeSeeder *randstrobeSeeder) Seed(seq []byte) ([]uint64, int, error) {
+	kHashes, kValid, skipped := kmerHashes(seq, randstrobeSeeder.params.K, randstrobeSeeder.hashFunc, randstrobeSeeder.seed, true)
+	wMin, wMax := int(randstrobeSeeder.params.WMin), int(randstrobeSeeder.params.WMax)
+	selected := make(map[uint64]struct{})
+	seeds := []uint64{}
+	for i, valid := range kValid {
+		if !valid {
+			continue
+		}
+
+		// find the second strobe, the window is shortened at the end of the sequence
+		second := -1
+		for j := i + wMin; j <= i+wMax && j < len(kHashes); j++ {
+			if !kValid[j] {
+				continue
+			}
+			if second == -1 || (kHashes[i]^kHashes[j]) < (kHashes[i]^kHashes[second]) {
+				second = j
+			}
+		}
+		if second == -1 {
+			continue
+		}
+		strobe := kHashes[i]/2 + kHashes[second]/3
+		if _, ok := selected[strobe]; ok {
+			continue
+		}
+		selected[strobe] = struct{}{}
+		seeds = append(seeds, strobe)
+	}
+	return seeds, skipped, nil
+}
+
+// kmerHashes is a helper function to get the canonical hash value of every k-mer in a sequence, indexed by the k-mer start position
+// k-mers containing an ambiguous base are marked as invalid and counted, as are symmetric k-mers if requested (as their strand is unknown)
+func kmerHashes(seq []byte, k uint, hashFunc string, seed uint64, skipSymmetric bool) ([]uint64, []bool, int) {
+	numKmers := len(seq) - int(k) + 1
+	if numKmers <= 0 {
+		return nil, nil, 0
+	}
+	hashes := make([]uint64, numKmers)
+	valid := make([]bool, numKmers)
+	skipped := 0
+	var nt *ntHasher
+	if hashFunc == "ntHash" {
+		nt = newNThasher(k, seed)
+	}
+	bitmask := (uint64(1) << uint64(2*k)) - uint64(1)
+	bitshift := uint64(2 * (k - 1))
+	kmers := [2]uint64{0, 0}
+	validBases := uint(0)
+	for i := 0; i < len(seq); i++ {
+		c := seq_nt4_table[seq[i]]
+		if c > 3 {
+			validBases = 0
+			kmers[0], kmers[1] = 0, 0
+			if i >= int(k)-1 {
+				skipped++
+			}
+			continue
+		}
+		validBases++
+		kmers[0] = (kmers[0]<<2 | uint64(c)) & bitmask
+		kmers[1] = (kmers[1] >> 2) | (uint64(3)^uint64(c))<<bitshift
+		if validBases < k {
+			if i >= int(k)-1 {
+				skipped++
+			}
+			continue
+		}
+		start := i - int(k) + 1
+		if nt != nil {
+			if validBases == k {
+				nt.init(seq[start : i+1])
+			} else {
+				nt.roll(seq_nt4_table[seq[start-1]], c)
+			}
+		}
+		if skipSymmetric && kmers[0] == kmers[1] {
+			continue
+		}
+		switch {
+		case nt != nil:
+			hashes[start] = nt.hash()
+		case kmers[0] < kmers[1]:
+			hashes[start] = hash64(kmers[0], bitmask)
+		default:
+			hashes[start] = hash64(kmers[1], bitmask)
+		}
+		valid[start] = true
+	}
+	return hashes, valid, skipped
+}
@@ -1,57 +1,96 @@
 package pipeline
 
 import (
-	"github.com/will-rowe/hulk/src/helpers"
+	"sync"
+
 	"github.com/will-rowe/hulk/src/kmerspectrum"
 	"github.com/will-rowe/hulk/src/minhash"
 )
 
 // theBoss is used to orchestrate the workers
 type theBoss struct {
-	inputSequences   chan []byte                // the boss uses this channel to receive sequence data from the main sketching pipeline
-	theCollector     chan *kmerspectrum.Bin     // the boss uses this channel to send minimizer frequency data back to the main sketching pipeline
-	minimizerChan    chan uint64                // minions send minimizers down this channel, back to the boss
-	flush            chan bool                  // controls flushing of the minions
+	info             *Info                      // the runtime info, which the boss reports any errors to
+	inputSequences   chan [][]byte              // the boss uses this channel to receive batches of sequence data from the main sketching pipeline
+	batch            [][]byte                   // the batch of sequences currently being filled by AddSeq
+	theCollector     chan *Interval             // the boss uses this channel to send minimizer frequency data back to the main sketching pipeline
+	flush            chan *Interval             // controls flushing of the minions
 	finish           chan bool                  // the boss uses this channel to stop the minions
+	finished         chan struct{}              // the boss closes this channel once the minions have stopped and their sketches have been collected
+	inFlight         sync.WaitGroup             // keeps track of the batches that have been handed to minions but not yet processed
 	minionRegister   []*Minion                  // a slice of all the minions controlled by this boss
-	kmerSpectrum     *kmerspectrum.KmerSpectrum // the boss stores the minimizer frequencies in a k-mer spectrum
-	kmvSketch        *minhash.KMVsketch         // optional sketch (not used yet)
-	khfSketch        *minhash.KHFsketch         // optional sketch (not used yet)
+	kmerSpectrum     *kmerspectrum.KmerSpectrum // the boss merges the minion k-mer spectra into this k-mer spectrum at each flush
+	kmvSketch        *minhash.KMVsketch         // optional sketch (nil unless requested)
+	khfSketch        *minhash.KHFsketch         // optional sketch (nil unless requested)
+	hmhSketch        *minhash.HyperMinHash      // optional sketch (nil unless requested)
 	minimizerCounter int                        // a count of the minimizers the Boss has collected
+	skippedCounter   int                        // a count of the k-mers the minions skipped because they contain ambiguous bases
 }
 
 // AddSeq is a method to give the boss a sequence
+// sequences are sent to the boss in batches, so AddSeq, Flush and StopWork must all be called from the same go routine
 func (theBoss *theBoss) AddSeq(seq []byte) {
-	theBoss.inputSequences <- seq
+	theBoss.batch = append(theBoss.batch, seq)
+	if len(theBoss.batch) == BATCHSIZE {
+		theBoss.sendBatch()
+	}
 }
 
 // StopWork is a method to initiate a controlled shut down of the boss and minions
+// it will block until every sequence has been processed and all the minion sketches have been collected
 func (theBoss *theBoss) StopWork() {
+	theBoss.sendBatch()
 	theBoss.finish <- true
+	<-theBoss.finished
 }
 
 // Flush is a method to flush the current value held in the k-mer spectrum and then wipe it
-func (theBoss *theBoss) Flush() {
-	theBoss.flush <- true
+// the flushed k-mer spectrum bins are added to the supplied interval, which is then sent back to the main sketching pipeline
+func (theBoss *theBoss) Flush(interval *Interval) {
+	theBoss.sendBatch()
+	theBoss.flush <- interval
 }
 
 // GetMinimizerCount is a method to return the number of minimizers the boss has collected
+// it should only be called once the boss has stopped work
 func (theBoss *theBoss) GetMinimizerCount() int {
 	return theBoss.minimizerCounter
 }
 
+// GetSkippedCount is a method to return the number of k-mers the minions skipped because they contain ambiguous bases
+// it should only be called once the boss has stopped work
+func (theBoss *theBoss) GetSkippedCount() int {
+	return theBoss.skippedCounter
+}
+
 // CollectKHFsketch is a method to collect the KHF sketch
+// it should only be called once the boss has stopped work
 func (theBoss *theBoss) CollectKHFsketch() *minhash.KHFsketch {
 	return theBoss.khfSketch
 }
 
+// CollectHyperMinHash is a method to collect the HyperMinHash sketch
+// it should only be called once the boss has stopped work
+func (theBoss *theBoss) CollectHyperMinHash() *minhash.HyperMinHash {
+	return theBoss.hmhSketch
+}
+
 // CollectKMVsketch is a method to collect the KMV sketch
+// it should only be called once the boss has stopped work
 func (theBoss *theBoss) CollectKMVsketch() *minhash.KMVsketch {
 	return theBoss.kmvSketch
 }
 
+// sendBatch is a method to send the current batch of sequences to the boss go routine and start a new batch
+func (theBoss *theBoss) sendBatch() {
+	if len(theBoss.batch) == 0 {
+		return
+	}
+	theBoss.inputSequences <- theBoss.batch
+	theBoss.batch = make([][]byte, 0, BATCHSIZE)
+}
+
 // findMinimizers is a function to start off the minions to find minimizers, returning their boss
-func findMinimizers(returnChannel chan *kmerspectrum.Bin, runtimeInfo *Info) (*theBoss, error) {
+func findMinimizers(returnChannel chan *Interval, runtimeInfo *Info) (*theBoss, error) {
 
 	// set up the base k-mer spectrum
 	ks, err := kmerspectrum.NewKmerSpectrum(int32(runtimeInfo.Sketch.SpectrumSize))
@@ -61,23 +100,57 @@ func findMinimizers(returnChannel chan *kmerspectrum.Bin, runtimeInfo *Info) (*t
 
 	// create a boss to orchestrate the minions
 	boss := &theBoss{
-		inputSequences: make(chan []byte),
+		inputSequences: make(chan [][]byte),
+		batch:          make([][]byte, 0, BATCHSIZE),
+		info:           runtimeInfo,
 		theCollector:   returnChannel,
-		minimizerChan:  make(chan uint64),
 		finish:         make(chan bool),
-		flush:          make(chan bool),
+		finished:       make(chan struct{}),
+		flush:          make(chan *Interval),
 		kmerSpectrum:   ks,
-		kmvSketch:      minhash.NewKMVsketch(runtimeInfo.Sketch.KmerSize, runtimeInfo.Sketch.SketchSize),
-		khfSketch:      minhash.NewKHFsketch(runtimeInfo.Sketch.KmerSize, runtimeInfo.Sketch.SketchSize),
+	}
+
+	// set up any optional sketches
+	if runtimeInfo.Sketch.KMV {
+		boss.kmvSketch = minhash.NewKMVsketch(runtimeInfo.Sketch.KmerSize, runtimeInfo.Sketch.SketchSize)
+	}
+	if runtimeInfo.Sketch.KHF {
+		boss.khfSketch = minhash.NewKHFsketch(runtimeInfo.Sketch.KmerSize, runtimeInfo.Sketch.SketchSize)
+	}
+	if runtimeInfo.Sketch.HMH {
+		boss.hmhSketch = minhash.NewHyperMinHash(runtimeInfo.Sketch.KmerSize, runtimeInfo.Sketch.SketchSize)
+	}
+
+	// if resuming, carry on from the checkpointed sketches and counts, and add back the k-mer spectrum of the unfinished interval
+	if checkpoint := runtimeInfo.Sketch.Resume; checkpoint != nil {
+		if boss.kmvSketch != nil && checkpoint.KMV != nil {
+			boss.kmvSketch = checkpoint.KMV
+		}
+		if boss.khfSketch != nil && checkpoint.KHF != nil {
+			boss.khfSketch = checkpoint.KHF
+		}
+		if boss.hmhSketch != nil && checkpoint.HMH != nil {
+			boss.hmhSketch = checkpoint.HMH
+		}
+		boss.minimizerCounter = checkpoint.Progress.Minimizers
+		boss.skippedCounter = checkpoint.Progress.Skipped
+		for _, bin := range checkpoint.Pending {
+			if err := boss.kmerSpectrum.AddBin(bin); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	// set up the minion pool
-	minionQueue := make(chan chan []byte)
+	minionQueue := make(chan chan [][]byte)
 	boss.minionRegister = make([]*Minion, runtimeInfo.Sketch.NumMinions)
 	for id := 0; id < runtimeInfo.Sketch.NumMinions; id++ {
 
 		// create a minion
-		minion := newMinion(id, runtimeInfo, minionQueue, boss.minimizerChan)
+		minion, err := newMinion(id, runtimeInfo, minionQueue, &boss.inFlight)
+		if err != nil {
+			return nil, err
+		}
 
 		// start it running
 		minion.Start()
@@ -86,64 +159,78 @@ func findMinimizers(returnChannel chan *kmerspectrum.Bin, runtimeInfo *Info) (*t
 		boss.minionRegister[id] = minion
 	}
 
-	// start collecting the minimizers from the minions
-	go func() {
-		for minimizer := range boss.minimizerChan {
-			boss.kmerSpectrum.AddHash(minimizer)
-			boss.minimizerCounter++
-		}
-	}()
-
 	// start processing the sequences
 	go func() {
 		for {
 			select {
 
-			// if there's a sequence to be processed, send it to a minion
-			case sequence := <-boss.inputSequences:
+			// if there's a batch of sequences to be processed, send it to a minion
+			case batch := <-boss.inputSequences:
 
 				// wait for a minion to be available
 				minion := <-minionQueue
 
-				// hand the sequence over
-				minion <- sequence
+				// hand the batch over, recording that it is being worked on
+				boss.inFlight.Add(1)
+				minion <- batch
 
 			// flush the boss's k-mer spectrum - which sends it back to the main pipeline sketching process and then wipes it
-			case <-boss.flush:
+			case interval := <-boss.flush:
 
-				// TODO: pause the minimizer chan before doing this....
+				// wait for the minions to finish the sequences from this interval, so that the spectrum covers exactly the reads sent so far
+				boss.inFlight.Wait()
 
-				// send the minimizers and frequencies to the main pipeline sketching process
+				// merge the minion k-mer spectra into the boss's spectrum
+				for _, minion := range boss.minionRegister {
+					count, err := minion.flushSpectrum(boss.kmerSpectrum)
+					boss.info.fail(err)
+					boss.minimizerCounter += count
+				}
+
+				// collect the minimizers and frequencies for this interval
 				if boss.kmerSpectrum.Cardinality() != 0 {
 					dump, err := boss.kmerSpectrum.Dump()
-					helpers.ErrorCheck(err)
-					for bin := range dump {
-						if bin.Frequency != 0.0 {
-							boss.theCollector <- bin
+					if !boss.info.fail(err) {
+						for bin := range dump {
+							if bin.Frequency != 0.0 {
+								interval.Bins = append(interval.Bins, bin)
+							}
 						}
 					}
 
-					// wipe the minion's spectrum, ready to collect more k-mers
+					// wipe the boss's spectrum, ready to collect more k-mers
 					boss.kmerSpectrum.Wipe()
 				}
 
+				// send the interval to the main pipeline sketching process (even if it is empty, so that every interval is accounted for)
+				boss.theCollector <- interval
+
 			// stop the minions working when the boss receives word
 			case <-boss.finish:
 
-				// send the finish signal to the minions
+				// wait for any sequences currently with the minions to be processed
+				boss.inFlight.Wait()
+
+				// send the finish signal to the minions and merge their optional sketches
 				for _, minion := range boss.minionRegister {
 					minion.Finish()
+					boss.skippedCounter += minion.skippedCounter
+					if boss.kmvSketch != nil {
+						boss.info.fail(boss.kmvSketch.Merge(minion.kmvSketch))
+					}
+					if boss.khfSketch != nil {
+						boss.info.fail(boss.khfSketch.Merge(minion.khfSketch))
+					}
+					if boss.hmhSketch != nil {
+						boss.info.fail(boss.hmhSketch.Merge(minion.hmhSketch))
+					}
 				}
 
 				// close the channel sending sequences to the minions
 				close(boss.inputSequences)
 
-				// close the channel receiving minimizers from the minions
-				close(boss.minimizerChan)
-
-				// close the channel sending minimizer frequencies to the main pipeline
-				close(boss.theCollector)
-
+				// let the main pipeline know that the boss is done
+				close(boss.finished)
 				return
 			}
 		}
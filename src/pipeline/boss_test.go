@@ -0,0 +1,189 @@
+package pipeline
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/will-rowe/hulk/src/kmerspectrum"
+	"github.com/will-rowe/hulk/src/minimizer"
+)
+
+var (
+	testNumReads = 1000
+	testReadLen  = 150
+	testInterval = 97 // deliberately not a factor of testNumReads, so that the final interval is partial
+)
+
+// testReads generates a set of random reads
+func testReads(numReads, readLen int) [][]byte {
+	r := rand.New(rand.NewSource(42))
+	bases := []byte("ACGT")
+	reads := make([][]byte, numReads)
+	for i := range reads {
+		reads[i] = make([]byte, readLen)
+		for j := range reads[i] {
+			reads[i][j] = bases[r.Intn(4)]
+		}
+	}
+	return reads
+}
+
+// testInfo returns the runtime info needed to set up a boss
+func testInfo(numMinions int) *Info {
+	return &Info{
+		Sketch: &SketchCmd{
+			KmerSize:     11,
+			WindowSize:   5,
+			HashFunc:     "hash64",
+			Seeder:       minimizer.SeederParams{Name: "minimizer", K: 11, W: 5},
+			SpectrumSize: 10000,
+			SketchSize:   10,
+			NumMinions:   numMinions,
+			KMV:          true,
+		},
+	}
+}
+
+// expectedSpectrum builds the k-mer spectrum for a set of reads without using the boss and minions
+func expectedSpectrum(t *testing.T, info *Info, reads [][]byte) map[int32]float64 {
+	ks, err := kmerspectrum.NewKmerSpectrum(info.Sketch.SpectrumSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeder, err := minimizer.NewSeeder(info.Sketch.Seeder, info.Sketch.HashFunc, info.Sketch.HashSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, read := range reads {
+		seeds, _, err := seeder.Seed(read)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, seed := range seeds {
+			ks.AddHash(seed)
+		}
+	}
+	spectrum := make(map[int32]float64)
+	if ks.Cardinality() == 0 {
+		return spectrum
+	}
+	dump, err := ks.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for bin := range dump {
+		spectrum[bin.BinID] = bin.Frequency
+	}
+	return spectrum
+}
+
+// test that each interval flushed by the boss covers exactly the reads sent since the previous interval
+func TestIntervalBoundaries(t *testing.T) {
+	reads := testReads(testNumReads, testReadLen)
+	for _, numMinions := range []int{1, 2, 3, 8} {
+		info := testInfo(numMinions)
+		numIntervals := (testNumReads / testInterval) + 1
+		collector := make(chan *Interval, numIntervals)
+		boss, err := findMinimizers(collector, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// send the reads, flushing at every interval and once more at the end
+		intervalID := 0
+		for i, read := range reads {
+			boss.AddSeq(read)
+			if (i+1)%testInterval == 0 {
+				intervalID++
+				boss.Flush(&Interval{ID: intervalID, ReadCount: uint(i + 1), Timestamp: time.Now()})
+			}
+		}
+		boss.Flush(&Interval{ID: intervalID + 1, ReadCount: uint(len(reads)), Timestamp: time.Now(), Final: true})
+		boss.StopWork()
+		close(collector)
+
+		// check each interval against the expected spectrum for its reads
+		start, received := 0, 0
+		for interval := range collector {
+			received++
+			end := int(interval.ReadCount)
+			expected := expectedSpectrum(t, info, reads[start:end])
+			if len(interval.Bins) != len(expected) {
+				t.Fatalf("minions=%d interval=%d: expected %d used bins, got %d", numMinions, interval.ID, len(expected), len(interval.Bins))
+			}
+			for _, bin := range interval.Bins {
+				if expected[bin.BinID] != bin.Frequency {
+					t.Fatalf("minions=%d interval=%d: bin %d has frequency %.0f, expected %.0f", numMinions, interval.ID, bin.BinID, bin.Frequency, expected[bin.BinID])
+				}
+			}
+			start = end
+		}
+		if received != numIntervals {
+			t.Fatalf("minions=%d: expected %d intervals, got %d", numMinions, numIntervals, received)
+		}
+		if boss.CollectKMVsketch() == nil || len(boss.CollectKMVsketch().GetSketch()) != int(info.Sketch.SketchSize) {
+			t.Fatalf("minions=%d: KMV sketch was not populated", numMinions)
+		}
+	}
+}
+
+// failingSeeder is a seeder that can't seed any sequence
+type failingSeeder struct {
+	minimizer.Seeder
+}
+
+// Seed is a method to return an error for every sequence
+func (failingSeeder) Seed(seq []byte) ([]uint64, int, error) {
+	return nil, 0, fmt.Errorf("can't seed sequence")
+}
+
+// test that a minion reports a seeding error to the runtime info, rather than exiting, so that other samples in a batch can carry on
+func TestMinionSeedError(t *testing.T) {
+	info := testInfo(2)
+	collector := make(chan *Interval, 1)
+	boss, err := findMinimizers(collector, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, minion := range boss.minionRegister {
+		minion.seeder = failingSeeder{minion.seeder}
+	}
+	for _, read := range testReads(BATCHSIZE*2, testReadLen) {
+		boss.AddSeq(read)
+	}
+	boss.Flush(&Interval{ID: 1, ReadCount: uint(BATCHSIZE * 2), Timestamp: time.Now(), Final: true})
+	boss.StopWork()
+	if info.Err() == nil || info.Err().Error() != "can't seed sequence" {
+		t.Fatalf("expected the seeding error to be reported, got: %v", info.Err())
+	}
+}
+
+// benchmark the boss and minions, using the default sketching parameters, to show how throughput scales with the number of minions (-p)
+func BenchmarkFindMinimizers(b *testing.B) {
+	reads := testReads(10000, testReadLen)
+	for _, numMinions := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("minions=%d", numMinions), func(b *testing.B) {
+			info := testInfo(numMinions)
+			info.Sketch.KmerSize = 21
+			info.Sketch.WindowSize = 9
+			info.Sketch.SpectrumSize = 194481
+			b.SetBytes(int64(len(reads) * testReadLen))
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				collector := make(chan *Interval, 1)
+				boss, err := findMinimizers(collector, info)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, read := range reads {
+					boss.AddSeq(read)
+				}
+				boss.Flush(&Interval{ReadCount: uint(len(reads)), Final: true})
+				boss.StopWork()
+				<-collector
+			}
+		})
+	}
+}
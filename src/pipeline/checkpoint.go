@@ -0,0 +1,236 @@
+package pipeline
+
+/*
+ this part of the pipeline saves the sketching state to a checkpoint once all the reads have been sketched, so that a later run can resume sketching from it
+
+ the reads since the last sketching interval haven't finished their interval, so their k-mer spectrum is saved alongside a histosketch that doesn't include them
+ a resumed run adds this spectrum back to the boss's spectrum, which gives the same intervals and the same sketches as a single uninterrupted run
+*/
+
+import (
+	"compress/gzip"
+	"encoding"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/will-rowe/hulk/src/histosketch"
+	"github.com/will-rowe/hulk/src/kmerspectrum"
+	"github.com/will-rowe/hulk/src/minhash"
+	"github.com/will-rowe/hulk/src/minimizer"
+)
+
+// CHECKPOINT_VERSION is the version of the checkpoint format, which is increased whenever a change stops older checkpoints from being resumed
+const CHECKPOINT_VERSION int = 1
+
+// Progress holds the running totals for the sequences sketched by the SeqMinimizer
+type Progress struct {
+	Sequences  uint // the number of sequences (fragments for paired-end data) processed
+	Reads      int  // the number of reads processed
+	Length     int  // the total length of the reads processed
+	Intervals  int  // the number of sketching intervals reached
+	Minimizers int  // the number of seeds found
+	Skipped    int  // the number of k-mers skipped because they contain ambiguous bases
+}
+
+// CheckpointSettings are the sketch settings that must be the same for a run to resume from a checkpoint
+type CheckpointSettings struct {
+	KmerSize     uint
+	WindowSize   uint
+	HashFunc     string
+	HashSeed     uint64
+	Seeder       minimizer.SeederParams
+	SpectrumSize int32
+	SketchSize   uint
+	DecayRatio   float64
+	CountMin     histosketch.CountMinSettings
+	Seed         int64
+	Interval     uint
+	WindowReads  uint
+	WindowTime   time.Duration
+	KHF          bool
+	KMV          bool
+	HMH          bool
+	Spectrum     bool
+	Pairing      string
+	MinQual      int
+	MinLength    uint
+	MaxLength    uint
+	MaxN         float64
+}
+
+// newCheckpointSettings is a helper function to collect the checkpoint settings from a sketch command
+func newCheckpointSettings(sketchCmd *SketchCmd) CheckpointSettings {
+	return CheckpointSettings{
+		KmerSize:     sketchCmd.KmerSize,
+		WindowSize:   sketchCmd.WindowSize,
+		HashFunc:     sketchCmd.HashFunc,
+		HashSeed:     sketchCmd.HashSeed,
+		Seeder:       sketchCmd.Seeder,
+		SpectrumSize: sketchCmd.SpectrumSize,
+		SketchSize:   sketchCmd.SketchSize,
+		DecayRatio:   sketchCmd.DecayRatio,
+		CountMin:     sketchCmd.CountMin,
+		Seed:         sketchCmd.Seed,
+		Interval:     sketchCmd.Interval,
+		WindowReads:  sketchCmd.WindowReads,
+		WindowTime:   sketchCmd.WindowTime,
+		KHF:          sketchCmd.KHF,
+		KMV:          sketchCmd.KMV,
+		HMH:          sketchCmd.HMH,
+		Spectrum:     sketchCmd.Spectrum,
+		Pairing:      sketchCmd.Pairing,
+		MinQual:      sketchCmd.MinQual,
+		MinLength:    sketchCmd.MinLength,
+		MaxLength:    sketchCmd.MaxLength,
+		MaxN:         sketchCmd.MaxN,
+	}
+}
+
+// Checkpoint holds the full sketching state at the end of a run
+type Checkpoint struct {
+	Format       int                         // the checkpoint format (see CHECKPOINT_VERSION)
+	Version      string                      // the version of hulk that wrote the checkpoint
+	Settings     CheckpointSettings          // the settings used to sketch the reads
+	FileName     string                      // the input file(s) sketched so far
+	Progress     Progress                    // the running totals for the sequences sketched so far
+	DroppedReads map[string]uint             // the number of sequences dropped by each read filter so far
+	HistoSketch  *histosketch.HistoSketch    // the histosketch, without the unfinished interval
+	Pending      []*kmerspectrum.Bin         // the k-mer spectrum of the unfinished interval
+	Window       *WindowState                // the sliding window, without the unfinished interval (nil unless a window is used)
+	KMV          *minhash.KMVsketch          // optional sketch (nil unless requested)
+	KHF          *minhash.KHFsketch          // optional sketch (nil unless requested)
+	HMH          *minhash.HyperMinHash       // optional sketch (nil unless requested)
+	Spectrum     *kmerspectrum.SavedSpectrum // the k-mer spectrum of the reads, without the unfinished interval (nil unless requested, or if a window is used)
+}
+
+// LoadCheckpoint is a function to read a checkpoint from disk
+func LoadCheckpoint(fileName string) (*Checkpoint, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	gz, err := gzip.NewReader(fh)
+	if err != nil {
+		return nil, fmt.Errorf("%v is not a hulk checkpoint: %v", fileName, err)
+	}
+	defer gz.Close()
+	checkpoint := &Checkpoint{}
+	if err := gob.NewDecoder(gz).Decode(checkpoint); err != nil {
+		return nil, fmt.Errorf("could not read checkpoint %v: %v", fileName, err)
+	}
+	if checkpoint.Format != CHECKPOINT_VERSION {
+		return nil, fmt.Errorf("checkpoint %v has format %d, but this version of hulk needs format %d", fileName, checkpoint.Format, CHECKPOINT_VERSION)
+	}
+	if checkpoint.HistoSketch == nil {
+		return nil, fmt.Errorf("checkpoint %v is missing its histosketch", fileName)
+	}
+	if checkpoint.Window != nil && len(checkpoint.Window.Intervals) != len(checkpoint.Window.Starts) {
+		return nil, fmt.Errorf("checkpoint %v has a corrupt sliding window", fileName)
+	}
+	return checkpoint, nil
+}
+
+// Resume is a method to set up a sketch command to resume sketching from the checkpoint, adding its input files to the ones already sketched
+// the settings used for the checkpoint must match the sketch command, as otherwise the new reads wouldn't be sketched in the same way
+func (Checkpoint *Checkpoint) Resume(sketchCmd *SketchCmd) error {
+	saved, current := reflect.ValueOf(Checkpoint.Settings), reflect.ValueOf(newCheckpointSettings(sketchCmd))
+	for i := 0; i < saved.NumField(); i++ {
+		if !reflect.DeepEqual(saved.Field(i).Interface(), current.Field(i).Interface()) {
+			return fmt.Errorf("can't resume from a checkpoint made with a different %v setting (%v vs. %v)", saved.Type().Field(i).Name, saved.Field(i).Interface(), current.Field(i).Interface())
+		}
+	}
+	sketchCmd.FileName = strings.TrimSuffix(Checkpoint.FileName, ",") + "," + sketchCmd.FileName
+	sketchCmd.Resume = Checkpoint
+	return nil
+}
+
+// Write is a method to write the checkpoint to disk as gzipped gob
+// the checkpoint is written to a temporary file which then replaces any existing checkpoint, so that a failed write won't lose the previous checkpoint
+func (Checkpoint *Checkpoint) Write(fileName string) error {
+	fh, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fh.Name())
+	if err := fh.Chmod(0644); err != nil {
+		fh.Close()
+		return err
+	}
+	gz := gzip.NewWriter(fh)
+	if err := gob.NewEncoder(gz).Encode(Checkpoint); err != nil {
+		fh.Close()
+		return fmt.Errorf("could not encode checkpoint: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fh.Name(), fileName)
+}
+
+// saveState is a method for the Sketcher to start a checkpoint once the final interval is received, before the interval is added to the histosketch or window
+func (proc *Sketcher) saveState(hs *histosketch.HistoSketch, finalInterval *Interval) error {
+	saved := &histosketch.HistoSketch{}
+	if err := copySketch(hs, saved); err != nil {
+		return err
+	}
+	proc.checkpoint = &Checkpoint{
+		Format:      CHECKPOINT_VERSION,
+		Version:     proc.info.Version,
+		Settings:    newCheckpointSettings(proc.info.Sketch),
+		FileName:    proc.info.Sketch.FileName,
+		HistoSketch: saved,
+		Pending:     finalInterval.Bins,
+	}
+	if proc.window != nil {
+		proc.checkpoint.Window = proc.window.state()
+	}
+	if proc.spectrum != nil {
+		proc.checkpoint.Spectrum = proc.spectrum.Save(proc.info.Sketch.KmerSize)
+	}
+	return nil
+}
+
+// finishCheckpoint is a method for the Sketcher to add the rest of the sketching state to the checkpoint, once all the other processes have finished
+// the optional sketches are copied, as the KMV sketch is changed when it is added to a HULKdata
+func (proc *Sketcher) finishCheckpoint() error {
+	proc.checkpoint.Progress = *proc.progress
+	proc.checkpoint.DroppedReads = proc.info.Sketch.DroppedReads
+	for _, sketch := range *proc.sketches {
+		var err error
+		switch sketch := sketch.(type) {
+		case *minhash.KMVsketch:
+			proc.checkpoint.KMV = &minhash.KMVsketch{}
+			err = copySketch(sketch, proc.checkpoint.KMV)
+		case *minhash.KHFsketch:
+			proc.checkpoint.KHF = &minhash.KHFsketch{}
+			err = copySketch(sketch, proc.checkpoint.KHF)
+		case *minhash.HyperMinHash:
+			proc.checkpoint.HMH = &minhash.HyperMinHash{}
+			err = copySketch(sketch, proc.checkpoint.HMH)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySketch is a helper function to copy the full state of a sketch into another one
+func copySketch(from encoding.BinaryMarshaler, to encoding.BinaryUnmarshaler) error {
+	state, err := from.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return to.UnmarshalBinary(state)
+}
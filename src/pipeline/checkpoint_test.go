@@ -0,0 +1,171 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestFastq writes a set of reads to a FASTQ file, truncating every tenth read so that some are dropped by the minLength filter
+func writeTestFastq(t *testing.T, fileName string, reads [][]byte) {
+	var data strings.Builder
+	for i, read := range reads {
+		if i%10 == 0 {
+			read = read[:20]
+		}
+		fmt.Fprintf(&data, "@read%d\n%s\n+\n%s\n", i, read, strings.Repeat("I", len(read)))
+	}
+	if err := ioutil.WriteFile(fileName, []byte(data.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runTestPipeline runs the sketching pipeline with a read filter over a set of files
+func runTestPipeline(t *testing.T, info *Info, files []string) {
+	dataStream := NewDataStreamer(info)
+	fastqHandler := NewFastqHandler(info)
+	readFilter := NewReadFilter(info)
+	seqMinimizer := NewSeqMinimizer(info)
+	sketcher := NewSketcher(info)
+	dataStream.Connect(files)
+	fastqHandler.Connect(dataStream)
+	readFilter.Connect(fastqHandler)
+	seqMinimizer.ConnectFilter(readFilter)
+	sketcher.Connect(seqMinimizer)
+	pipeline := NewPipeline()
+	pipeline.AddProcesses(dataStream, fastqHandler, readFilter, seqMinimizer, sketcher)
+	pipeline.Run()
+	if err := info.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadTestSketch reads a sketch written by the pipeline, dropping the timestamp as it will differ between runs
+func loadTestSketch(t *testing.T, fileName string) map[string]interface{} {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sketch := make(map[string]interface{})
+	if err := json.Unmarshal(data, &sketch); err != nil {
+		t.Fatal(err)
+	}
+	delete(sketch, "timestamp")
+	return sketch
+}
+
+// test that resuming from a checkpoint gives the same sketches as a single uninterrupted run
+func TestCheckpointResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	reads := testReads(testNumReads, testReadLen)
+	files := []string{filepath.Join(dir, "a.fq"), filepath.Join(dir, "b.fq")}
+	writeTestFastq(t, files[0], reads[:testNumReads/2])
+	writeTestFastq(t, files[1], reads[testNumReads/2:])
+	tests := []struct {
+		name        string
+		interval    uint
+		windowReads uint
+		decayRatio  float64
+	}{
+		{"no interval", 0, 0, 1.0},
+		{"interval", uint(testInterval), 0, 1.0},
+		{"concept drift", uint(testInterval), 0, 0.5},
+		{"sliding window", uint(testInterval), uint(testInterval * 2), 1.0},
+	}
+	for i, test := range tests {
+		newInfo := func(outFile string, files []string) *Info {
+			info := testInfo(2)
+			info.Logger = log.New(ioutil.Discard, "", 0)
+			info.Sketch.Pairing = "single"
+			info.Sketch.FileName = strings.Join(files, ",") + ","
+			info.Sketch.OutFile = filepath.Join(dir, fmt.Sprintf("%d-%v", i, outFile))
+			info.Sketch.Interval = test.interval
+			info.Sketch.WindowReads = test.windowReads
+			info.Sketch.DecayRatio = test.decayRatio
+			info.Sketch.Seed = 1
+			info.Sketch.MinLength = 50
+			info.Sketch.MaxN = 1.0
+			info.Sketch.KMV = test.windowReads == 0
+			info.Sketch.KHF = test.windowReads == 0
+			info.Sketch.HMH = test.windowReads == 0
+			info.Sketch.Spectrum = true
+			return info
+		}
+		checkpointFile := filepath.Join(dir, fmt.Sprintf("%d.ckpt", i))
+
+		// sketch all the reads in one go
+		uninterrupted := newInfo("uninterrupted", files)
+		runTestPipeline(t, uninterrupted, files)
+
+		// sketch the first file and checkpoint, then resume with the second file
+		first := newInfo("first", files[:1])
+		first.Sketch.Checkpoint = checkpointFile
+		runTestPipeline(t, first, files[:1])
+		checkpoint, err := LoadCheckpoint(checkpointFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resumed := newInfo("resumed", files[1:])
+		resumed.Sketch.FileName = files[1] + ","
+		if err := checkpoint.Resume(resumed.Sketch); err != nil {
+			t.Fatal(err)
+		}
+		runTestPipeline(t, resumed, files[1:])
+
+		// the final sketches, and the snapshots taken after resuming, should match
+		expected, got := loadTestSketch(t, uninterrupted.Sketch.OutFile+".json"), loadTestSketch(t, resumed.Sketch.OutFile+".json")
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("%v: resumed sketch does not match the uninterrupted sketch:\n%v\n%v", test.name, expected, got)
+		}
+		if len(got["signatures"].([]interface{})) != 5 && test.windowReads == 0 {
+			t.Fatalf("%v: resumed sketch is missing the KMV, KHF, HyperMinHash or spectrum sketches", test.name)
+		}
+		if len(got["signatures"].([]interface{})) != 2 && test.windowReads != 0 {
+			t.Fatalf("%v: resumed sketch is missing the saved spectrum", test.name)
+		}
+		if test.interval == 0 {
+			continue
+		}
+		interval := checkpoint.Progress.Intervals + 1
+		for ; ; interval++ {
+			fileName := fmt.Sprintf(".interval-%d.json", interval)
+			if _, err := os.Stat(uninterrupted.Sketch.OutFile + fileName); os.IsNotExist(err) {
+				break
+			}
+			if !reflect.DeepEqual(loadTestSketch(t, uninterrupted.Sketch.OutFile+fileName), loadTestSketch(t, resumed.Sketch.OutFile+fileName)) {
+				t.Fatalf("%v: resumed snapshot for interval %d does not match the uninterrupted snapshot", test.name, interval)
+			}
+		}
+		if interval == checkpoint.Progress.Intervals+1 {
+			t.Fatalf("%v: no snapshots were taken after resuming", test.name)
+		}
+	}
+}
+
+// test that a checkpoint can't be resumed with different settings
+func TestCheckpointSettings(t *testing.T) {
+	info := testInfo(1)
+	checkpoint := &Checkpoint{Settings: newCheckpointSettings(info.Sketch), FileName: "a.fq,"}
+	info.Sketch.FileName = "b.fq,"
+	if err := checkpoint.Resume(info.Sketch); err != nil {
+		t.Fatal(err)
+	}
+	if info.Sketch.FileName != "a.fq,b.fq," || info.Sketch.Resume != checkpoint {
+		t.Fatalf("sketch command was not set up to resume: %v", info.Sketch.FileName)
+	}
+	info = testInfo(1)
+	info.Sketch.KmerSize++
+	if err := checkpoint.Resume(info.Sketch); err == nil || !strings.Contains(err.Error(), "KmerSize") {
+		t.Fatalf("expected a k-mer size error, got: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package pipeline
+
+/*
+ this part of the pipeline will read a sample manifest, so that many samples can be sketched in one run
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Sample holds the information for a single sample in a manifest
+type Sample struct {
+	ID         string            // the sample ID, which is used to name the sketch
+	Files      []string          // single-end or interleaved sequence files
+	R1         []string          // R1 files for paired-end data
+	R2         []string          // R2 files for paired-end data (same order as R1)
+	Label      string            // an optional label for the sketch (used by BANNER)
+	Attributes map[string]string // any extra columns in the manifest
+}
+
+// ReadManifest is a function to read a tab-separated sample manifest
+/* the manifest format:
+-1. lines that are empty or start with # are ignored
+-2. the first line is a header, which must contain a sample column and either a files column or r1 and r2 columns
+-3. each subsequent line describes one sample, files are separated by commas
+-4. an optional label column is used as the banner label, any other columns are kept as sample attributes
+*/
+func ReadManifest(r io.Reader) ([]*Sample, error) {
+	scanner := bufio.NewScanner(r)
+	var header []string
+	columns := make(map[string]int)
+	samples := []*Sample{}
+	seen := make(map[string]int)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+
+		// check the header
+		if header == nil {
+			header = fields
+			for i, column := range header {
+				column = strings.ToLower(strings.TrimSpace(column))
+				if _, ok := columns[column]; ok {
+					return nil, fmt.Errorf("manifest line %d: duplicate column: %v", lineNum, column)
+				}
+				columns[column] = i
+			}
+			if _, ok := columns["sample"]; !ok {
+				return nil, fmt.Errorf("manifest line %d: header must have a sample column", lineNum)
+			}
+			_, hasFiles := columns["files"]
+			_, hasR1 := columns["r1"]
+			_, hasR2 := columns["r2"]
+			if hasR1 != hasR2 {
+				return nil, fmt.Errorf("manifest line %d: header must have both r1 and r2 columns for paired-end data", lineNum)
+			}
+			if !hasFiles && !hasR1 {
+				return nil, fmt.Errorf("manifest line %d: header must have a files column or r1 and r2 columns", lineNum)
+			}
+			continue
+		}
+
+		// get the sample
+		if len(fields) != len(header) {
+			return nil, fmt.Errorf("manifest line %d: expected %d columns, found %d", lineNum, len(header), len(fields))
+		}
+		sample := &Sample{ID: strings.TrimSpace(fields[columns["sample"]])}
+		if sample.ID == "" {
+			return nil, fmt.Errorf("manifest line %d: missing sample ID", lineNum)
+		}
+		if strings.ContainsAny(sample.ID, "/\\ ") {
+			return nil, fmt.Errorf("manifest line %d: sample ID can't contain slashes or spaces, as it is used to name the sketch: %v", lineNum, sample.ID)
+		}
+		if prev, ok := seen[sample.ID]; ok {
+			return nil, fmt.Errorf("manifest line %d: sample ID %v was already used on line %d", lineNum, sample.ID, prev)
+		}
+		seen[sample.ID] = lineNum
+		for i, column := range header {
+			column = strings.ToLower(strings.TrimSpace(column))
+			value := strings.TrimSpace(fields[i])
+			switch column {
+			case "sample":
+			case "files":
+				sample.Files = splitFiles(value)
+			case "r1":
+				sample.R1 = splitFiles(value)
+			case "r2":
+				sample.R2 = splitFiles(value)
+			case "label":
+				sample.Label = value
+			default:
+				if value == "" {
+					continue
+				}
+				if sample.Attributes == nil {
+					sample.Attributes = make(map[string]string)
+				}
+				sample.Attributes[strings.TrimSpace(header[i])] = value
+			}
+		}
+
+		// check the sample files
+		if len(sample.R1) != len(sample.R2) {
+			return nil, fmt.Errorf("manifest line %d: sample %v has a different number of R1 and R2 files (%d vs. %d)", lineNum, sample.ID, len(sample.R1), len(sample.R2))
+		}
+		if len(sample.Files) != 0 && len(sample.R1) != 0 {
+			return nil, fmt.Errorf("manifest line %d: sample %v can't have files as well as r1 and r2", lineNum, sample.ID)
+		}
+		if len(sample.Files) == 0 && len(sample.R1) == 0 {
+			return nil, fmt.Errorf("manifest line %d: sample %v has no sequence files", lineNum, sample.ID)
+		}
+		samples = append(samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no samples found in manifest")
+	}
+	return samples, nil
+}
+
+// splitFiles is a helper function to split a comma separated list of files
+func splitFiles(value string) []string {
+	files := []string{}
+	for _, file := range strings.Split(value, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
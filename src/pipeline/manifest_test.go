@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+// test that a manifest is read, with extra columns kept as attributes
+func TestReadManifest(t *testing.T) {
+	manifest := "# a comment\nsample\tfiles\tlabel\tsite\n\nS1\ta.fq, b.fq.gz\tgut\tLondon\nS2\tc.fq\t\t\n"
+	samples, err := ReadManifest(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(samples))
+	}
+	if samples[0].ID != "S1" || len(samples[0].Files) != 2 || samples[0].Files[1] != "b.fq.gz" || samples[0].Label != "gut" || samples[0].Attributes["site"] != "London" {
+		t.Fatalf("incorrect sample: %+v", samples[0])
+	}
+	if samples[1].Label != "" || samples[1].Attributes != nil {
+		t.Fatalf("empty columns should not be kept: %+v", samples[1])
+	}
+
+	// paired-end samples
+	samples, err = ReadManifest(strings.NewReader("sample\tr1\tr2\nS1\ta_1.fq,b_1.fq\ta_2.fq,b_2.fq\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples[0].R1) != 2 || samples[0].R2[1] != "b_2.fq" {
+		t.Fatalf("incorrect paired-end sample: %+v", samples[0])
+	}
+}
+
+// test that malformed manifests are rejected
+func TestReadManifestErrors(t *testing.T) {
+	for _, manifest := range []string{
+		"",
+		"sample\tfiles\n",
+		"files\nS1\n",
+		"sample\tr1\nS1\ta.fq\n",
+		"sample\tfiles\nS1\ta.fq\textra\n",
+		"sample\tfiles\nS1\ta.fq\nS1\tb.fq\n",
+		"sample\tfiles\nS/1\ta.fq\n",
+		"sample\tfiles\nS1\t\n",
+		"sample\tr1\tr2\nS1\ta_1.fq,b_1.fq\ta_2.fq\n",
+	} {
+		if _, err := ReadManifest(strings.NewReader(manifest)); err == nil {
+			t.Fatalf("expected an error for manifest: %q", manifest)
+		}
+	}
+}
@@ -3,31 +3,59 @@ package pipeline
 import (
 	"sync"
 
-	"github.com/will-rowe/hulk/src/helpers"
+	"github.com/will-rowe/hulk/src/kmerspectrum"
+	"github.com/will-rowe/hulk/src/minhash"
 	"github.com/will-rowe/hulk/src/minimizer"
 )
 
 // Minion is the base data type
 type Minion struct {
 	sync.RWMutex
-	id            int
-	info          *Info
-	minionQueue   chan chan []byte
-	inputChannel  chan []byte
-	outputChannel chan uint64
-	stop          chan struct{}
+	id               int
+	info             *Info
+	minionQueue      chan chan [][]byte
+	inputChannel     chan [][]byte
+	inFlight         *sync.WaitGroup
+	stop             chan struct{}
+	seeder           minimizer.Seeder           // selects the seeds (minimizers, syncmers or randstrobes) from each sequence
+	kmerSpectrum     *kmerspectrum.KmerSpectrum // each minion collects minimizer frequencies in its own k-mer spectrum, which is merged by the boss at each flush
+	kmvSketch        *minhash.KMVsketch         // optional sketch (nil unless requested)
+	khfSketch        *minhash.KHFsketch         // optional sketch (nil unless requested)
+	hmhSketch        *minhash.HyperMinHash      // optional sketch (nil unless requested)
+	minimizerCounter int                        // a count of the minimizers collected since the last flush
+	skippedCounter   int                        // a count of the k-mers skipped because they contain ambiguous bases
 }
 
 // newMinion is the constructor function
-func newMinion(id int, runtimeInfo *Info, minionQueue chan chan []byte, returnChan chan uint64) *Minion {
-	return &Minion{
-		id:            id,
-		info:          runtimeInfo,
-		minionQueue:   minionQueue,
-		inputChannel:  make(chan []byte),
-		outputChannel: returnChan,
-		stop:          make(chan struct{}),
+func newMinion(id int, runtimeInfo *Info, minionQueue chan chan [][]byte, inFlight *sync.WaitGroup) (*Minion, error) {
+	ks, err := kmerspectrum.NewKmerSpectrum(int32(runtimeInfo.Sketch.SpectrumSize))
+	if err != nil {
+		return nil, err
 	}
+	seeder, err := minimizer.NewSeeder(runtimeInfo.Sketch.Seeder, runtimeInfo.Sketch.HashFunc, runtimeInfo.Sketch.HashSeed)
+	if err != nil {
+		return nil, err
+	}
+	minion := &Minion{
+		id:           id,
+		info:         runtimeInfo,
+		minionQueue:  minionQueue,
+		inputChannel: make(chan [][]byte),
+		inFlight:     inFlight,
+		stop:         make(chan struct{}),
+		seeder:       seeder,
+		kmerSpectrum: ks,
+	}
+	if runtimeInfo.Sketch.KMV {
+		minion.kmvSketch = minhash.NewKMVsketch(runtimeInfo.Sketch.KmerSize, runtimeInfo.Sketch.SketchSize)
+	}
+	if runtimeInfo.Sketch.KHF {
+		minion.khfSketch = minhash.NewKHFsketch(runtimeInfo.Sketch.KmerSize, runtimeInfo.Sketch.SketchSize)
+	}
+	if runtimeInfo.Sketch.HMH {
+		minion.hmhSketch = minhash.NewHyperMinHash(runtimeInfo.Sketch.KmerSize, runtimeInfo.Sketch.SketchSize)
+	}
+	return minion, nil
 }
 
 // Start is a method to start the minion running
@@ -36,28 +64,37 @@ func (minion *Minion) Start() {
 		for {
 
 			// when the minion is available for work, place its data channel in the queue
-			minion.minionQueue <- minion.inputChannel
+			select {
+			case minion.minionQueue <- minion.inputChannel:
+			case <-minion.stop:
+				return
+			}
 
 			// wait for work or stop signal
 			select {
 
-			// the minion has receieved some data from the boss
-			case data := <-minion.inputChannel:
+			// the minion has receieved a batch of sequences from the boss
+			case batch := <-minion.inputChannel:
 
 				// make sure the boss knows work is happening, incase a finish signal is sent
 				minion.Lock()
 
-				// get the minimizers for this sequence
-				sketch, err := minimizer.NewMinimizerSketch(minion.info.Sketch.KmerSize, minion.info.Sketch.WindowSize, data)
-				helpers.ErrorCheck(err)
-
-				// send minimizers back to the boss
-				for minimizer := range sketch.GetMinimizers() {
-					minion.outputChannel <- minimizer.(uint64)
+				// get the seeds for each sequence and add them to the minion's spectrum and sketches
+				// a sequence that can't be seeded fails the pipeline (not the whole program, as other samples may be running)
+				for _, data := range batch {
+					seeds, skipped, err := minion.seeder.Seed(data)
+					if minion.info.fail(err) {
+						break
+					}
+					for _, seed := range seeds {
+						minion.addMinimizer(seed)
+					}
+					minion.skippedCounter += skipped
 				}
 
 				// this minion is done for now
 				minion.Unlock()
+				minion.inFlight.Done()
 
 			// end the minion go function if a stop signal has been sent
 			case <-minion.stop:
@@ -69,6 +106,40 @@ func (minion *Minion) Start() {
 
 // Finish is a method to close down a minion, after checking it isn't currently working on something
 func (minion *Minion) Finish() {
+	minion.Lock()
 	close(minion.stop)
+	minion.Unlock()
 	return
 }
+
+// addMinimizer is a method to add a minimizer to the minion's k-mer spectrum and any optional sketches
+func (minion *Minion) addMinimizer(minimizer uint64) {
+	minion.kmerSpectrum.AddHash(minimizer)
+	if minion.kmvSketch != nil {
+		minion.kmvSketch.AddHash(minimizer)
+	}
+	if minion.khfSketch != nil {
+		minion.khfSketch.AddHash(minimizer)
+	}
+	if minion.hmhSketch != nil {
+		minion.hmhSketch.AddHash(minimizer)
+	}
+	minion.minimizerCounter++
+}
+
+// flushSpectrum is a method to add the minion's k-mer spectrum to the supplied spectrum and then wipe it
+// it returns the number of minimizers collected by the minion since the last flush
+func (minion *Minion) flushSpectrum(ks *kmerspectrum.KmerSpectrum) (int, error) {
+	minion.Lock()
+	defer minion.Unlock()
+	count := minion.minimizerCounter
+	minion.minimizerCounter = 0
+	if minion.kmerSpectrum.Cardinality() == 0 {
+		return count, nil
+	}
+	if err := ks.Merge(minion.kmerSpectrum); err != nil {
+		return 0, err
+	}
+	minion.kmerSpectrum.Wipe()
+	return count, nil
+}
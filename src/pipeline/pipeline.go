@@ -1,32 +1,110 @@
 // Package pipeline contains a streaming pipeline implementation based on the Gopher Academy article by S. Lampa - Patterns for composable concurrent pipelines in Go (https://blog.gopheracademy.com/advent-2015/composable-pipelines-improvements/)
 package pipeline
 
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/will-rowe/hulk/src/histosketch"
+	"github.com/will-rowe/hulk/src/kmerspectrum"
+	"github.com/will-rowe/hulk/src/minimizer"
+)
+
 // BUFFERSIZE is the size of the buffer used by the pipeline channels
 const BUFFERSIZE int = 64
 
+// BATCHSIZE is the number of sequences the boss hands to a minion at a time
+const BATCHSIZE int = 256
+
 // Info stores the runtime information
 type Info struct {
 	Version string
 	Sketch  *SketchCmd
+	Logger  *log.Logger // the logger used by the pipeline processes (the standard logger is used if nil)
+	err     error       // the first error reported by a pipeline process
+	errLock sync.Mutex
+}
+
+// Logf is a method to log a message using the runtime logger
+func (Info *Info) Logf(format string, v ...interface{}) {
+	if Info.Logger == nil {
+		log.Printf(format, v...)
+		return
+	}
+	Info.Logger.Printf(format, v...)
+}
+
+// Err is a method to return the first error reported by the pipeline processes (nil if the pipeline ran without error)
+func (Info *Info) Err() error {
+	Info.errLock.Lock()
+	defer Info.errLock.Unlock()
+	return Info.err
+}
+
+// fail is an unexported method for a pipeline process to report an error, it returns true if the error was not nil
+// only the first error is kept, as later errors are usually caused by the first
+func (Info *Info) fail(err error) bool {
+	if err == nil {
+		return false
+	}
+	Info.errLock.Lock()
+	defer Info.errLock.Unlock()
+	if Info.err == nil {
+		Info.err = err
+	}
+	return true
+}
+
+// failed is an unexported method to check if any pipeline process has reported an error
+func (Info *Info) failed() bool {
+	return Info.Err() != nil
 }
 
 // SketchCmd stores the runtime info for the sketch command
 type SketchCmd struct {
-	FileName     string // this is the name of the input file(s) which has been sketched, or STDIN if -f was not provided
-	Fasta        bool
+	FileName     string            // this is the name of the input file(s) which has been sketched, or STDIN if -f was not provided
+	SampleID     string            // the sample ID, when sketching from a manifest
+	Attributes   map[string]string // any extra sample information from the manifest
+	Pairing      string            // the read pairing mode (single, paired or interleaved)
 	KmerSize     uint
 	WindowSize   uint
+	HashFunc     string                 // the function used to hash the canonical k-mers (see minimizer.HASH_FUNCS)
+	HashSeed     uint64                 // the seed for the hash function
+	Seeder       minimizer.SeederParams // the settings for selecting seeds (minimizers, syncmers or randstrobes) from the sequences
 	SpectrumSize int32
 	SketchSize   uint
 	ChunkSize    uint
 	DecayRatio   float64
+	CountMin     histosketch.CountMinSettings // the countmin sketch settings for the histosketch
+	Seed         int64                        // the seed for the histosketch consistent weighted samples
 	Stream       bool
 	Interval     uint
+	WindowReads  uint          // only sketch the intervals in the last N reads (0 = no read limit)
+	WindowTime   time.Duration // only sketch the intervals in the last T (0 = no time limit)
 	OutFile      string
 	NumMinions   int
 	BannerLabel  string
 	KHF          bool
 	KMV          bool
+	HMH          bool            // also make a HyperMinHash sketch
+	Spectrum     bool            // also save the k-mer spectrum
+	MinQual      int             // quality trim reads to this Phred score (0 = no trimming)
+	MinLength    uint            // drop sequences with reads shorter than this after trimming (0 = no minimum)
+	MaxLength    uint            // drop sequences with reads longer than this (0 = no maximum)
+	MaxN         float64         // drop sequences with reads containing a greater fraction of ambiguous bases than this (1.0 = no maximum)
+	DroppedReads map[string]uint // the number of sequences dropped by each read filter (set by the ReadFilter once it has finished)
+	Checkpoint   string          // save the sketching state to this file once all the reads have been sketched ("" = no checkpoint)
+	Resume       *Checkpoint     // the checkpoint to resume sketching from (nil = start a new sketch, see Checkpoint.Resume)
+}
+
+// Interval holds the k-mer spectrum data flushed by the boss once a sketching interval is reached
+type Interval struct {
+	ID        int                 // the sketching interval number (starts at 1)
+	ReadCount uint                // the total number of reads processed when the interval was reached
+	Timestamp time.Time           // the time the interval was reached
+	Final     bool                // marks the final flush, which happens once all reads have been processed
+	Bins      []*kmerspectrum.Bin // the used k-mer spectrum bins collected during this interval
 }
 
 // process is the interface used by pipeline
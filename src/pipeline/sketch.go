@@ -5,30 +5,32 @@ package pipeline
 */
 
 import (
-	"bufio"
-	"compress/gzip"
 	"fmt"
-	"log"
+	"io"
 	"os"
-	"strings"
+	"sync"
+	"time"
 
-	"github.com/will-rowe/hulk/src/helpers"
 	"github.com/will-rowe/hulk/src/histosketch"
 	"github.com/will-rowe/hulk/src/kmerspectrum"
 	"github.com/will-rowe/hulk/src/seqio"
 	"github.com/will-rowe/hulk/src/sketchio"
 )
 
-// DataStreamer is a pipeline process that streams data from STDIN/file
+// streamLock makes sure that only one sketch is written to STDOUT at a time
+var streamLock sync.Mutex
+
+// DataStreamer is a pipeline process that streams sequence records from STDIN/file
 type DataStreamer struct {
 	info   *Info
 	input  []string
-	output chan []byte
+	mates  []string // the R2 files for paired-end input, in the same order as the R1 files in input
+	output chan *seqio.FASTQread
 }
 
 // NewDataStreamer is the constructor
 func NewDataStreamer(info *Info) *DataStreamer {
-	return &DataStreamer{info: info, output: make(chan []byte, BUFFERSIZE)}
+	return &DataStreamer{info: info, output: make(chan *seqio.FASTQread, BUFFERSIZE)}
 }
 
 // Connect is the method to connect the DataStreamer to some data source
@@ -36,58 +38,138 @@ func (proc *DataStreamer) Connect(input []string) {
 	proc.input = input
 }
 
+// ConnectPairs is the method to connect the DataStreamer to paired-end data, where each R1 file has a corresponding R2 file
+func (proc *DataStreamer) ConnectPairs(r1, r2 []string) {
+	proc.input = r1
+	proc.mates = r2
+}
+
 // Run is the method to run this process, which satisfies the pipeline interface
 func (proc *DataStreamer) Run() {
 	defer close(proc.output)
-	var scanner *bufio.Scanner
 
-	// if an input file path has not been provided, scan the contents of STDIN
+	// if an input file path has not been provided, read the contents of STDIN
 	if len(proc.input) == 0 {
-		scanner = bufio.NewScanner(os.Stdin)
-		for scanner.Scan() {
+		proc.info.fail(proc.stream(os.Stdin, "STDIN"))
+		return
+	}
 
-			// important: copy content of scan to a new slice before sending, this avoids race conditions (as we are using multiple go routines) from concurrent slice access
-			proc.output <- append([]byte(nil), scanner.Bytes()...)
+	// otherwise, read each file in turn (paired-end files are read together)
+	for i := 0; i < len(proc.input); i++ {
+		var err error
+		if len(proc.mates) == 0 {
+			err = proc.streamFile(proc.input[i])
+		} else {
+			err = proc.streamPair(proc.input[i], proc.mates[i])
 		}
-		if scanner.Err() != nil {
-			log.Fatal(scanner.Err())
+		if proc.info.fail(err) {
+			return
 		}
-	} else {
-		for i := 0; i < len(proc.input); i++ {
-			fh, err := os.Open(proc.input[i])
-			helpers.ErrorCheck(err)
-			defer fh.Close()
-
-			// handle gzipped input
-			splitFilename := strings.Split(proc.input[i], ".")
-			if splitFilename[len(splitFilename)-1] == "gz" {
-				gz, err := gzip.NewReader(fh)
-				helpers.ErrorCheck(err)
-				defer gz.Close()
-				scanner = bufio.NewScanner(gz)
-			} else {
-				scanner = bufio.NewScanner(fh)
-			}
-			for scanner.Scan() {
-				proc.output <- append([]byte(nil), scanner.Bytes()...)
-			}
-			if scanner.Err() != nil {
-				log.Fatal(scanner.Err())
-			}
+	}
+}
+
+// streamFile is a method to send the records from a single-end or interleaved file
+func (proc *DataStreamer) streamFile(fileName string) error {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return proc.stream(fh, fileName)
+}
+
+// stream is a method to send the records read from an input, it stops early if another process has failed
+func (proc *DataStreamer) stream(r io.Reader, name string) error {
+	reader, err := proc.newSeqReader(r, name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for !proc.info.failed() {
+		read, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return readError(name, err)
 		}
+		proc.output <- read
 	}
+	return nil
 }
 
-// FastqHandler is a pipeline process to convert a pipeline to the FASTQ type
+// streamPair is a method to interleave the records from a pair of files, sending one record from each mate at a time
+func (proc *DataStreamer) streamPair(r1File, r2File string) error {
+	fh, err := os.Open(r1File)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	mateFH, err := os.Open(r2File)
+	if err != nil {
+		return err
+	}
+	defer mateFH.Close()
+	reader, err := proc.newSeqReader(fh, r1File)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	mateReader, err := proc.newSeqReader(mateFH, r2File)
+	if err != nil {
+		return err
+	}
+	defer mateReader.Close()
+	for !proc.info.failed() {
+		r1, err1 := reader.Next()
+		r2, err2 := mateReader.Next()
+		if err1 == io.EOF && err2 == io.EOF {
+			break
+		}
+		if err1 == io.EOF || err2 == io.EOF {
+			return fmt.Errorf("paired files have a different number of reads: %v and %v", r1File, r2File)
+		}
+		if err1 != nil {
+			return readError(r1File, err1)
+		}
+		if err2 != nil {
+			return readError(r2File, err2)
+		}
+		proc.output <- r1
+		proc.output <- r2
+	}
+	return nil
+}
+
+// newSeqReader is a method to set up a sequence reader and log the detected format
+func (proc *DataStreamer) newSeqReader(r io.Reader, name string) (*seqio.Reader, error) {
+	reader, err := seqio.NewReader(r)
+	if err != nil {
+		return nil, readError(name, err)
+	}
+	proc.info.Logf("\treading %v (format: %v, compression: %v)", name, reader.Format(), reader.Compression())
+	return reader, nil
+}
+
+// readError is a helper function to add the input name to any error from the sequence reader
+func readError(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("could not read sequences from %v: %v", name, err)
+}
+
+// FastqHandler is a pipeline process to group FASTQ reads into fragments, pairing mates for paired-end data
 type FastqHandler struct {
 	info   *Info
-	input  chan []byte
-	output chan *seqio.FASTQread
+	input  chan *seqio.FASTQread
+	output chan seqio.Fragment
+	mate   *seqio.FASTQread // holds the first mate of a pair until the second mate is received
 }
 
 // NewFastqHandler is the constructor
 func NewFastqHandler(info *Info) *FastqHandler {
-	return &FastqHandler{info: info, output: make(chan *seqio.FASTQread, BUFFERSIZE)}
+	return &FastqHandler{info: info, output: make(chan seqio.Fragment, BUFFERSIZE)}
 }
 
 // Connect is the method to join the input of this process with the output of a DataStreamer
@@ -97,80 +179,150 @@ func (proc *FastqHandler) Connect(previous *DataStreamer) {
 
 // Run is the method to run this process, which satisfies the pipeline interface
 func (proc *FastqHandler) Run() {
+	defer drainReads(proc.input)
 	defer close(proc.output)
-	var l1, l2, l3, l4 []byte
-	if proc.info.Sketch.Fasta {
-		for line := range proc.input {
-			if len(line) == 0 {
-				break
-			}
-			// check for chevron
-			if line[0] == 62 {
-				if l1 != nil {
-
-					// store current fasta entry (as FASTQ read)
-					l1[0] = 64
-					newRead, err := seqio.NewFASTQread(l1, l2, nil, nil)
-					if err != nil {
-						log.Fatal(err)
-					}
-
-					// send on the new read and reset the line stores
-					proc.output <- newRead
-				}
-				l1, l2 = line, nil
-			} else {
-				l2 = append(l2, line...)
-			}
+	for read := range proc.input {
+		if proc.info.fail(proc.send(read)) {
+			return
 		}
+	}
 
-		// flush final fasta
-		l1[0] = 64
-		newRead, err := seqio.NewFASTQread(l1, l2, nil, nil)
-		if err != nil {
-			log.Fatal(err)
+	// make sure there isn't a mate left over
+	if proc.mate != nil {
+		proc.info.fail(fmt.Errorf("odd number of reads received for paired-end input, last read has no mate: %v", string(proc.mate.ID)))
+	}
+}
+
+// send is a method to send a read on as a fragment, holding back the first mate of a pair until the second mate arrives
+func (proc *FastqHandler) send(read *seqio.FASTQread) error {
+	if proc.info.Sketch.Pairing == "single" || proc.info.Sketch.Pairing == "" {
+		fragment, _ := seqio.NewFragment(read)
+		proc.output <- fragment
+		return nil
+	}
+	if proc.mate == nil {
+		proc.mate = read
+		return nil
+	}
+	fragment, err := seqio.NewFragment(proc.mate, read)
+	if err != nil {
+		return err
+	}
+	proc.output <- fragment
+	proc.mate = nil
+	return nil
+}
+
+// drainReads is a helper function to empty the input of a process that has stopped early, so that the previous process is not left blocking
+func drainReads(input chan *seqio.FASTQread) {
+	for range input {
+	}
+}
+
+// READ_FILTERS are the read filters available to the ReadFilter, in the order they are applied
+var READ_FILTERS = []string{"minQual", "minLength", "maxLength", "maxN"}
+
+// ReadFilter is an optional pipeline process to quality trim reads and drop any sequences that fail the read filters
+type ReadFilter struct {
+	info    *Info
+	input   chan seqio.Fragment
+	output  chan seqio.Fragment
+	dropped map[string]uint // the number of sequences dropped by each filter
+}
+
+// NewReadFilter is the constructor
+func NewReadFilter(info *Info) *ReadFilter {
+	return &ReadFilter{info: info, output: make(chan seqio.Fragment, BUFFERSIZE), dropped: make(map[string]uint)}
+}
+
+// Connect is the method to join the input of this process with the output of FastqHandler
+func (proc *ReadFilter) Connect(previous *FastqHandler) {
+	proc.input = previous.output
+}
+
+// Run is the method to run this process, which satisfies the pipeline interface
+func (proc *ReadFilter) Run() {
+	defer close(proc.output)
+
+	// only report the filters that are in use
+	for _, filter := range READ_FILTERS {
+		if proc.enabled(filter) {
+			proc.dropped[filter] = 0
 		}
+	}
 
-		// send on the new read and reset the line stores
-		proc.output <- newRead
-	} else {
+	// carry on counting from a checkpoint if resuming
+	if proc.info.Sketch.Resume != nil {
+		for filter, dropped := range proc.info.Sketch.Resume.DroppedReads {
+			proc.dropped[filter] += dropped
+		}
+	}
 
-		// grab four lines and create a new FASTQread struct from them - perform some format checks and trim low quality bases
-		for line := range proc.input {
-			if l1 == nil {
-				l1 = line
-			} else if l2 == nil {
-				l2 = line
-			} else if l3 == nil {
-				l3 = line
-			} else if l4 == nil {
-				l4 = line
-
-				// create fastq read
-				newRead, err := seqio.NewFASTQread(l1, l2, l3, l4)
-				if err != nil {
-					log.Fatal(err)
-				}
+	// a sequence is dropped if any of its reads fail a filter, which keeps mates together
+	for fragment := range proc.input {
+		if filter := proc.check(fragment); filter != "" {
+			proc.dropped[filter]++
+			continue
+		}
+		proc.output <- fragment
+	}
+
+	// hand the counts over before the output is closed, so that they are ready for the later processes
+	proc.info.Sketch.DroppedReads = proc.dropped
+}
+
+// enabled is a method to check if a read filter has been requested
+func (proc *ReadFilter) enabled(filter string) bool {
+	switch filter {
+	case "minQual":
+		return proc.info.Sketch.MinQual > 0
+	case "minLength":
+		return proc.info.Sketch.MinLength > 0
+	case "maxLength":
+		return proc.info.Sketch.MaxLength > 0
+	case "maxN":
+		return proc.info.Sketch.MaxN < 1.0
+	default:
+		return false
+	}
+}
+
+// check is a method to quality trim the reads in a fragment and return the first filter that any read fails (or an empty string if the fragment passes)
+func (proc *ReadFilter) check(fragment seqio.Fragment) string {
+	for _, read := range fragment {
 
-				// send on the new read and reset the line stores
-				proc.output <- newRead
-				l1, l2, l3, l4 = nil, nil, nil, nil
+		// FASTA records have no quality scores, so are not trimmed
+		if proc.info.Sketch.MinQual > 0 && len(read.Qual) != 0 {
+			read.QualityTrim(proc.info.Sketch.MinQual)
+			if len(read.Seq) == 0 {
+				return "minQual"
 			}
 		}
+		if uint(len(read.Seq)) < proc.info.Sketch.MinLength {
+			return "minLength"
+		}
+		if proc.info.Sketch.MaxLength > 0 && uint(len(read.Seq)) > proc.info.Sketch.MaxLength {
+			return "maxLength"
+		}
+		if proc.info.Sketch.MaxN < 1.0 && read.AmbiguousFraction() > proc.info.Sketch.MaxN {
+			return "maxN"
+		}
 	}
+	return ""
 }
 
 // SeqMinimizer is a process to collect minimizers from sequences
 type SeqMinimizer struct {
 	info     *Info
-	input    chan *seqio.FASTQread
-	output   chan *kmerspectrum.Bin // the pair is used to hold a minimzer and its frequency
+	input    chan seqio.Fragment
+	output   chan *Interval // each interval holds the minimizer bins and their frequencies
 	sketches []sketchio.SketchObject
+	progress Progress // the running totals, which carry on from the checkpoint if resuming
 }
 
 // NewSeqMinimizer is the constructor
 func NewSeqMinimizer(info *Info) *SeqMinimizer {
-	return &SeqMinimizer{info: info, output: make(chan *kmerspectrum.Bin, BUFFERSIZE)}
+	return &SeqMinimizer{info: info, output: make(chan *Interval, BUFFERSIZE)}
 }
 
 // Connect is the method to join the input of this process with the output of FastqHandler
@@ -178,52 +330,65 @@ func (proc *SeqMinimizer) Connect(previous *FastqHandler) {
 	proc.input = previous.output
 }
 
+// ConnectFilter is the method to join the input of this process with the output of ReadFilter
+func (proc *SeqMinimizer) ConnectFilter(previous *ReadFilter) {
+	proc.input = previous.output
+}
+
 // Run is the method to run this process, which satisfies the pipeline interface
 func (proc *SeqMinimizer) Run() {
-	log.Printf("finding minimizers...")
+	defer close(proc.output)
+	proc.info.Logf("finding minimizers...")
 
-	// count the number of sequences and their lengths as we go
-	seqCount := uint(0)
+	// count the number of sequences (fragments for paired-end data), reads and their lengths as we go
+	if proc.info.Sketch.Resume != nil {
+		proc.progress = proc.info.Sketch.Resume.Progress
+		proc.info.Logf("\tresuming after %d sequences", proc.progress.Sequences)
+	}
 	reportInterval := uint(100000)
-	multiplier := uint(1)
-	lengthTotal := 0
 
 	// set up the boss and minion pool, ready to find minimizers
 	theBoss, err := findMinimizers(proc.output, proc.info)
-	helpers.ErrorCheck(err)
+	if proc.info.fail(err) {
+		drainFragments(proc.input)
+		return
+	}
 
 	// start processing sequences
-	sketchingInterval := 0
-	for sequence := range proc.input {
+	for fragment := range proc.input {
 
-		// add the seq to the queue for minimizer finding
-		theBoss.AddSeq(sequence.Seq)
+		// add the seq(s) to the queue for minimizer finding
+		for _, read := range fragment {
+			theBoss.AddSeq(read.Seq)
+			proc.progress.Length += len(read.Seq)
+			proc.progress.Reads++
+		}
 
 		// print progress to screen
-		seqCount++
-		if (seqCount % 100000) == 0 {
-			log.Printf("\tprocessed %d sequences", reportInterval*multiplier)
-			multiplier++
+		proc.progress.Sequences++
+		if (proc.progress.Sequences % reportInterval) == 0 {
+			proc.info.Logf("\tprocessed %d sequences", proc.progress.Sequences)
 		}
-		lengthTotal += len(sequence.Seq)
 
 		// if an interval is reached, get the minions to flush their k-mer spectra, sending the data to the next pipeline process
-		if (proc.info.Sketch.Interval) != 0 && (seqCount%proc.info.Sketch.Interval) == 0 {
-			sketchingInterval++
-			log.Printf("\treached interval %d -> histosketching", sketchingInterval)
-			theBoss.Flush()
+		if (proc.info.Sketch.Interval) != 0 && (proc.progress.Sequences%proc.info.Sketch.Interval) == 0 {
+			proc.progress.Intervals++
+			proc.info.Logf("\treached interval %d -> histosketching", proc.progress.Intervals)
+			theBoss.Flush(&Interval{ID: proc.progress.Intervals, ReadCount: proc.progress.Sequences, Timestamp: time.Now()})
 		}
 
 	} // all sequences have been sent for processing
 
 	// final flush of the minions
-	log.Printf("generating final histosketch of k-mer spectra...")
-	theBoss.Flush()
+	proc.info.Logf("generating final histosketch of k-mer spectra...")
+	theBoss.Flush(&Interval{ID: proc.progress.Intervals + 1, ReadCount: proc.progress.Sequences, Timestamp: time.Now(), Final: true})
 
-	// signal the end of the sequences and close the channels
+	// signal the end of the sequences and wait for the minions to finish up
 	theBoss.StopWork()
+	proc.progress.Minimizers = theBoss.GetMinimizerCount()
+	proc.progress.Skipped = theBoss.GetSkippedCount()
 
-	// collect the secondary sketches if applicable TODO: add this functionality back in
+	// collect the secondary sketches if applicable (these must be added before this process closes its output)
 	if proc.info.Sketch.KMV {
 		sketch := theBoss.CollectKMVsketch()
 		proc.sketches = append(proc.sketches, sketch)
@@ -232,28 +397,55 @@ func (proc *SeqMinimizer) Run() {
 		sketch := theBoss.CollectKHFsketch()
 		proc.sketches = append(proc.sketches, sketch)
 	}
+	if proc.info.Sketch.HMH {
+		sketch := theBoss.CollectHyperMinHash()
+		proc.sketches = append(proc.sketches, sketch)
+	}
 
 	// check we received some sequence data & print some info
-	if seqCount == 0 {
-		helpers.ErrorCheck(fmt.Errorf("no sequences received"))
-	}
-	meanRL := uint(float64(lengthTotal) / float64(seqCount))
-	log.Printf("\tprocessed %d sequences in total\n", seqCount)
-	log.Printf("\tmean sequence length: %d\n", meanRL)
-	log.Printf("\tfound %d minimizers\n", theBoss.GetMinimizerCount())
-	log.Printf("\thistosketching across %d bins\n", proc.info.Sketch.SpectrumSize)
+	if proc.info.failed() {
+		return
+	}
+	if proc.progress.Sequences == 0 {
+		proc.info.fail(fmt.Errorf("no sequences received"))
+		return
+	}
+	meanRL := uint(float64(proc.progress.Length) / float64(proc.progress.Reads))
+	proc.info.Logf("\tprocessed %d sequences in total\n", proc.progress.Sequences)
+	for _, filter := range READ_FILTERS {
+		if dropped, ok := proc.info.Sketch.DroppedReads[filter]; ok {
+			proc.info.Logf("\tdropped %d sequences with the %v filter\n", dropped, filter)
+		}
+	}
+	if proc.progress.Reads != int(proc.progress.Sequences) {
+		proc.info.Logf("\tprocessed %d reads in total\n", proc.progress.Reads)
+	}
+	proc.info.Logf("\tmean sequence length: %d\n", meanRL)
+	proc.info.Logf("\tfound %d seeds (%v)\n", proc.progress.Minimizers, proc.info.Sketch.Seeder.Name)
+	proc.info.Logf("\tskipped %d k-mers containing ambiguous bases\n", proc.progress.Skipped)
+	proc.info.Logf("\thistosketching across %d bins\n", proc.info.Sketch.SpectrumSize)
 	if proc.info.Sketch.NumMinions > 1 {
-		log.Printf("merging sketches and cleaning up...")
+		proc.info.Logf("merging sketches and cleaning up...")
 	} else {
-		log.Printf("cleaning up...")
+		proc.info.Logf("cleaning up...")
+	}
+}
+
+// drainFragments is a helper function to empty the input of a process that has stopped early, so that the previous process is not left blocking
+func drainFragments(input chan seqio.Fragment) {
+	for range input {
 	}
 }
 
 // Sketcher is a pipeline process that receives k-mer spectra data from minions and histosketches it
 type Sketcher struct {
-	info     *Info
-	input    chan *kmerspectrum.Bin
-	sketches *[]sketchio.SketchObject
+	info       *Info
+	input      chan *Interval
+	sketches   *[]sketchio.SketchObject
+	progress   *Progress
+	window     *sketchWindow              // the sliding window of intervals (nil unless only the recent reads are sketched)
+	spectrum   *kmerspectrum.KmerSpectrum // the k-mer spectrum of all the reads (nil unless the spectrum is saved without a window)
+	checkpoint *Checkpoint                // the sketching state to save once all the reads have been sketched (nil unless a checkpoint was requested)
 }
 
 // NewSketcher is the constructor
@@ -265,37 +457,189 @@ func NewSketcher(info *Info) *Sketcher {
 func (proc *Sketcher) Connect(previous *SeqMinimizer) {
 	proc.input = previous.output
 	proc.sketches = &previous.sketches
+	proc.progress = &previous.progress
 }
 
 // Run is the method to run this process, which satisfies the pipeline interface
 func (proc *Sketcher) Run() {
-
-	// create the HULKdata object, ready to store the sketches
-	hulkData := sketchio.NewHULKdata()
+	defer drainIntervals(proc.input)
 
 	// create the histosketch
-	hs, err := histosketch.NewHistoSketch(proc.info.Sketch.KmerSize, proc.info.Sketch.SketchSize, proc.info.Sketch.SpectrumSize, proc.info.Sketch.DecayRatio)
-	helpers.ErrorCheck(err)
+	hs, err := newHistoSketch(proc.info.Sketch)
+	if proc.info.fail(err) {
+		return
+	}
+	if proc.info.Sketch.WindowReads != 0 || proc.info.Sketch.WindowTime != 0 {
+		proc.window = newSketchWindow(proc.info.Sketch.WindowReads, proc.info.Sketch.WindowTime)
+	}
+
+	// if the spectrum is to be saved, keep a running total of the intervals (a window already holds the intervals it covers)
+	if proc.info.Sketch.Spectrum && proc.window == nil {
+		if proc.spectrum, err = kmerspectrum.NewKmerSpectrum(proc.info.Sketch.SpectrumSize); proc.info.fail(err) {
+			return
+		}
+	}
+
+	// if resuming, carry on from the checkpointed histosketch, window and spectrum
+	if checkpoint := proc.info.Sketch.Resume; checkpoint != nil {
+		hs = checkpoint.HistoSketch
+		if proc.window != nil && checkpoint.Window != nil {
+			proc.window.restore(checkpoint.Window)
+		}
+		if proc.spectrum != nil && checkpoint.Spectrum != nil {
+			for _, bin := range checkpoint.Spectrum.GetBins() {
+				if proc.info.fail(proc.spectrum.AddBin(bin)) {
+					return
+				}
+			}
+		}
+	}
+
+	// collect the k-mer spectra data from minions and histosketch it, one interval at a time
+	var finalInterval *Interval
+	for interval := range proc.input {
+		if proc.info.failed() {
+			continue
+		}
+
+		// the checkpoint is taken before the final interval is added, as the interval is unfinished if more reads are sketched
+		if interval.Final && proc.info.Sketch.Checkpoint != "" {
+			if proc.info.fail(proc.saveState(hs, interval)) {
+				return
+			}
+		}
+
+		// in window mode, the histosketch is rebuilt from the intervals that are still in the window
+		if proc.window != nil {
+			proc.window.add(interval)
+			if hs, err = proc.window.sketch(proc.info.Sketch); proc.info.fail(err) {
+				return
+			}
+		} else {
+			for _, bin := range interval.Bins {
+
+				// TODO: change histosketch to accept int32 as binID
+				hs.AddElement(uint64(bin.BinID), bin.Frequency)
+				if proc.spectrum != nil && proc.info.fail(proc.spectrum.AddBin(bin)) {
+					return
+				}
+			}
+		}
 
-	// collect the k-mer spectra data from minions and histosketch it
-	for bin := range proc.input {
+		// the final interval is written once all the other sketches are ready
+		if interval.Final {
+			finalInterval = interval
+			continue
+		}
 
-		// TODO: change histosketch to accept int32 as binID
-		hs.AddElement(uint64(bin.BinID), bin.Frequency)
+		// snapshot the histosketch for this interval
+		snapshot := proc.newHULKdata(interval)
+		snapshot.Interval = interval.ID
+		snapshot.Metadata.CountMin = sketchio.NewCountMin(hs)
+		if proc.info.fail(snapshot.Add(hs)) || proc.info.fail(proc.write(snapshot, fmt.Sprintf("%v.interval-%d.json", proc.info.Sketch.OutFile, interval.ID))) {
+			return
+		}
+	}
+
+	// don't write a final sketch if an earlier process failed, as it won't contain all the data
+	if proc.info.failed() {
+		return
+	}
+	if finalInterval == nil {
+		proc.info.fail(fmt.Errorf("sketcher did not receive the final k-mer spectrum"))
+		return
 	}
 
 	// once we get here, the previous process has finished and we are ready to save all the HULK data
 	// add the histosketch to the HULKdata
-	helpers.ErrorCheck(hulkData.Add(hs))
+	hulkData := proc.newHULKdata(finalInterval)
+	hulkData.Metadata.CountMin = sketchio.NewCountMin(hs)
+	if proc.info.fail(hulkData.Add(hs)) {
+		return
+	}
+
+	// the read filter counts are only complete once all the sequences have been processed
+	hulkData.Metadata.ReadFilters = proc.info.Sketch.DroppedReads
+
+	// finish the checkpoint before the other sketches are added to the HULKdata
+	if proc.checkpoint != nil && proc.info.fail(proc.finishCheckpoint()) {
+		return
+	}
 
 	// add any other sketches we asked the previous process for
 	for _, sketch := range *proc.sketches {
-		helpers.ErrorCheck(hulkData.Add(sketch))
+		if proc.info.fail(hulkData.Add(sketch)) {
+			return
+		}
+	}
+
+	// add the k-mer spectrum if it is to be saved
+	if proc.info.Sketch.Spectrum {
+		spectrum, err := proc.saveSpectrum()
+		if proc.info.fail(err) || proc.info.fail(hulkData.Add(spectrum)) {
+			return
+		}
+	}
+
+	// write the final sketch, followed by the checkpoint
+	if proc.info.fail(proc.write(hulkData, proc.info.Sketch.OutFile+".json")) || proc.checkpoint == nil {
+		return
+	}
+	if proc.info.fail(proc.checkpoint.Write(proc.info.Sketch.Checkpoint)) {
+		return
+	}
+	proc.info.Logf("\twritten checkpoint to disk: %v\n", proc.info.Sketch.Checkpoint)
+}
+
+// saveSpectrum is a method to save the k-mer spectrum of the sketched reads (or of the reads in the window)
+func (proc *Sketcher) saveSpectrum() (*kmerspectrum.SavedSpectrum, error) {
+	if proc.window != nil {
+		return kmerspectrum.NewSavedSpectrum(proc.info.Sketch.KmerSize, proc.info.Sketch.SpectrumSize, proc.window.spectrum())
 	}
+	return proc.spectrum.Save(proc.info.Sketch.KmerSize), nil
+}
+
+// newHistoSketch is a helper function to create a histosketch with the sketch command settings
+func newHistoSketch(sketchCmd *SketchCmd) (*histosketch.HistoSketch, error) {
+	return histosketch.NewHistoSketch(sketchCmd.KmerSize, sketchCmd.SketchSize, sketchCmd.SpectrumSize, sketchCmd.DecayRatio, sketchCmd.Seed, sketchCmd.CountMin)
+}
 
-	// add any final info to the HULKdata before writing the sketch to disk
+// newHULKdata is a method to create a HULKdata and add the runtime info for the supplied interval
+func (proc *Sketcher) newHULKdata(interval *Interval) *sketchio.HULKdata {
+	hulkData := sketchio.NewHULKdata()
 	hulkData.FileName = proc.info.Sketch.FileName
+	hulkData.HashFunc = proc.info.Sketch.HashFunc
+	hulkData.HashSeed = proc.info.Sketch.HashSeed
+	hulkData.Seeder = proc.info.Sketch.Seeder
 	hulkData.Banner = proc.info.Sketch.BannerLabel
-	hulkData.WriteJSON(proc.info.Sketch.OutFile + ".json")
-	log.Printf("\twritten sketch to disk: %v\n", proc.info.Sketch.OutFile+".json")
+	hulkData.ReadCount = interval.ReadCount
+	hulkData.Timestamp = interval.Timestamp.Format(time.RFC3339)
+	hulkData.Metadata.Pairing = proc.info.Sketch.Pairing
+	hulkData.Metadata.Sample = proc.info.Sketch.SampleID
+	hulkData.Metadata.Attributes = proc.info.Sketch.Attributes
+	if proc.window != nil {
+		hulkData.Metadata.Window = proc.window.describe()
+	}
+	return hulkData
+}
+
+// write is a method to write a HULKdata to disk and, if streaming, to STDOUT
+// STDOUT is shared by all pipelines, so the streamed sketches are written one at a time
+func (proc *Sketcher) write(hulkData *sketchio.HULKdata, fileName string) error {
+	if err := hulkData.WriteJSON(fileName); err != nil {
+		return err
+	}
+	proc.info.Logf("\twritten sketch to disk: %v\n", fileName)
+	if proc.info.Sketch.Stream {
+		streamLock.Lock()
+		defer streamLock.Unlock()
+		return hulkData.StreamJSON(os.Stdout)
+	}
+	return nil
+}
+
+// drainIntervals is a helper function to empty the input of a process that has stopped early, so that the previous process is not left blocking
+func drainIntervals(input chan *Interval) {
+	for range input {
+	}
 }
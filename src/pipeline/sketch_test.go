@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/will-rowe/hulk/src/seqio"
+)
+
+// testFragment builds a single-end fragment from a sequence and quality string
+func testFragment(seq, qual string) seqio.Fragment {
+	fragment, _ := seqio.NewFragment(&seqio.FASTQread{Sequence: seqio.Sequence{ID: []byte("@read"), Seq: []byte(seq)}, Qual: []byte(qual)})
+	return fragment
+}
+
+// test that the read filter trims reads and drops sequences, attributing each drop to the first filter that failed
+func TestReadFilter(t *testing.T) {
+	info := testInfo(1)
+	info.Sketch.MinQual = 20
+	info.Sketch.MinLength = 6
+	info.Sketch.MaxLength = 10
+	info.Sketch.MaxN = 0.2
+	filter := NewReadFilter(info)
+	filter.input = make(chan seqio.Fragment, 8)
+	filter.input <- testFragment("ACGTACGT", "IIIIIIII")     // passes
+	filter.input <- testFragment("ACGTACGTAA", "IIIIIIII##") // passes after trimming the 3' end
+	filter.input <- testFragment("ACGTACGT", "########")     // trimmed to nothing
+	filter.input <- testFragment("ACGTACGT", "III#####")     // too short after trimming
+	filter.input <- testFragment("ACGTACGTACGT", "IIIIIIIIIIII")
+	filter.input <- testFragment("ACNNACGT", "IIIIIIII")
+	filter.input <- testFragment("ACGTACGT", "") // FASTA records are not trimmed
+	close(filter.input)
+	go filter.Run()
+
+	// check the passing sequences
+	passed := []string{}
+	for fragment := range filter.output {
+		passed = append(passed, string(fragment[0].Seq))
+	}
+	if len(passed) != 3 || passed[0] != "ACGTACGT" || passed[1] != "ACGTACGT" || passed[2] != "ACGTACGT" {
+		t.Fatalf("unexpected sequences passed the read filter: %v", passed)
+	}
+
+	// check the drop counts
+	expected := map[string]uint{"minQual": 1, "minLength": 1, "maxLength": 1, "maxN": 1}
+	for filter, count := range expected {
+		if info.Sketch.DroppedReads[filter] != count {
+			t.Fatalf("expected %d sequences dropped by %v, got %d", count, filter, info.Sketch.DroppedReads[filter])
+		}
+	}
+}
+
+// test that mates are dropped together
+func TestReadFilterPairs(t *testing.T) {
+	info := testInfo(1)
+	info.Sketch.MinLength = 5
+	info.Sketch.MaxN = 1.0
+	filter := NewReadFilter(info)
+	r1 := &seqio.FASTQread{Sequence: seqio.Sequence{ID: []byte("@read/1"), Seq: []byte("ACGTACGT")}}
+	r2 := &seqio.FASTQread{Sequence: seqio.Sequence{ID: []byte("@read/2"), Seq: []byte("ACG")}}
+	fragment, err := seqio.NewFragment(r1, r2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.check(fragment) != "minLength" {
+		t.Fatal("pair with a short mate should fail the minLength filter")
+	}
+	if _, ok := filter.dropped["maxN"]; ok || filter.enabled("maxN") {
+		t.Fatal("maxN filter should not be enabled")
+	}
+}
+
+// test that an error in one process is returned by the pipeline, instead of a sketch being written
+func TestPipelineError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk-pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	badFile := filepath.Join(dir, "bad.fq")
+	data := strings.Repeat("@read\nACGTACGTACGTACGTACGTACGT\n+\nIIIIIIIIIIIIIIIIIIIIIIII\n", 1000) + "@truncated\nACGT\n"
+	if err := ioutil.WriteFile(badFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := testInfo(2)
+	info.Sketch.OutFile = filepath.Join(dir, "sketch")
+	info.Logger = log.New(ioutil.Discard, "", 0)
+
+	// run the pipeline
+	dataStream := NewDataStreamer(info)
+	fastqHandler := NewFastqHandler(info)
+	seqMinimizer := NewSeqMinimizer(info)
+	sketcher := NewSketcher(info)
+	dataStream.Connect([]string{badFile})
+	fastqHandler.Connect(dataStream)
+	seqMinimizer.Connect(fastqHandler)
+	sketcher.Connect(seqMinimizer)
+	pipeline := NewPipeline()
+	pipeline.AddProcesses(dataStream, fastqHandler, seqMinimizer, sketcher)
+	pipeline.Run()
+
+	// check the error was reported and no sketch was written
+	if info.Err() == nil || !strings.Contains(info.Err().Error(), "truncated") {
+		t.Fatalf("expected a truncated record error, got: %v", info.Err())
+	}
+	if _, err := os.Stat(info.Sketch.OutFile + ".json"); !os.IsNotExist(err) {
+		t.Fatal("sketch should not be written when the pipeline fails")
+	}
+}
@@ -0,0 +1,123 @@
+package pipeline
+
+/*
+ this part of the pipeline keeps the k-mer spectra for a sliding window of sketching intervals, so that a sketch can reflect only the recent reads
+
+ a histosketch can't forget an element once it has been added, so the windowed histosketch is rebuilt from the summed spectra of the intervals in the window each time the window moves
+*/
+
+import (
+	"sort"
+	"time"
+
+	"github.com/will-rowe/hulk/src/histosketch"
+	"github.com/will-rowe/hulk/src/kmerspectrum"
+	"github.com/will-rowe/hulk/src/sketchio"
+)
+
+// windowEntry holds an interval in the sliding window, along with the read count at the start of the interval
+type windowEntry struct {
+	interval *Interval
+	start    uint
+}
+
+// sketchWindow holds the sketching intervals in the current sliding window
+type sketchWindow struct {
+	reads     uint          // the maximum number of reads in the window (0 = no read limit)
+	duration  time.Duration // the maximum length of time covered by the window (0 = no time limit)
+	entries   []*windowEntry
+	readCount uint // the read count at the end of the last interval added to the window
+}
+
+// WindowState holds the intervals in a sliding window, so that the window can be saved to a checkpoint
+type WindowState struct {
+	Intervals []*Interval // the intervals in the window
+	Starts    []uint      // the read count at the start of each interval
+	ReadCount uint        // the read count at the end of the last interval
+}
+
+// newSketchWindow is the constructor function
+func newSketchWindow(reads uint, duration time.Duration) *sketchWindow {
+	return &sketchWindow{reads: reads, duration: duration}
+}
+
+// add is a method to move the window on to include the supplied interval, expiring any intervals that have fallen out of the window
+// intervals are kept whole, so the window holds the intervals that started within the last N reads and that ended within the last T of the latest interval
+func (sketchWindow *sketchWindow) add(interval *Interval) {
+	sketchWindow.entries = append(sketchWindow.entries, &windowEntry{interval: interval, start: sketchWindow.readCount})
+	sketchWindow.readCount = interval.ReadCount
+	expired := 0
+	for _, entry := range sketchWindow.entries {
+		if sketchWindow.reads != 0 && interval.ReadCount-entry.start > sketchWindow.reads {
+			expired++
+			continue
+		}
+		if sketchWindow.duration != 0 && interval.Timestamp.Sub(entry.interval.Timestamp) > sketchWindow.duration {
+			expired++
+			continue
+		}
+		break
+	}
+	sketchWindow.entries = sketchWindow.entries[expired:]
+}
+
+// spectrum is a method to return the summed k-mer spectrum for the intervals in the window, ordered by bin ID
+func (sketchWindow *sketchWindow) spectrum() []*kmerspectrum.Bin {
+	frequencies := make(map[int32]float64)
+	for _, entry := range sketchWindow.entries {
+		for _, bin := range entry.interval.Bins {
+			frequencies[bin.BinID] += bin.Frequency
+		}
+	}
+	bins := make([]*kmerspectrum.Bin, 0, len(frequencies))
+	for binID, frequency := range frequencies {
+		bins = append(bins, &kmerspectrum.Bin{BinID: binID, Frequency: frequency})
+	}
+	sort.Slice(bins, func(i, j int) bool { return bins[i].BinID < bins[j].BinID })
+	return bins
+}
+
+// sketch is a method to histosketch the k-mer spectrum of the intervals in the window
+func (sketchWindow *sketchWindow) sketch(sketchCmd *SketchCmd) (*histosketch.HistoSketch, error) {
+	hs, err := newHistoSketch(sketchCmd)
+	if err != nil {
+		return nil, err
+	}
+	for _, bin := range sketchWindow.spectrum() {
+		hs.AddElement(uint64(bin.BinID), bin.Frequency)
+	}
+	return hs, nil
+}
+
+// state is a method to return the intervals currently in the window
+func (sketchWindow *sketchWindow) state() *WindowState {
+	state := &WindowState{ReadCount: sketchWindow.readCount}
+	for _, entry := range sketchWindow.entries {
+		state.Intervals = append(state.Intervals, entry.interval)
+		state.Starts = append(state.Starts, entry.start)
+	}
+	return state
+}
+
+// restore is a method to replace the intervals in the window with a saved set
+func (sketchWindow *sketchWindow) restore(state *WindowState) {
+	sketchWindow.entries = make([]*windowEntry, len(state.Intervals))
+	for i, interval := range state.Intervals {
+		sketchWindow.entries[i] = &windowEntry{interval: interval, start: state.Starts[i]}
+	}
+	sketchWindow.readCount = state.ReadCount
+}
+
+// describe is a method to return the sketch metadata for the current window
+func (sketchWindow *sketchWindow) describe() *sketchio.Window {
+	window := &sketchio.Window{Reads: sketchWindow.reads}
+	if sketchWindow.duration != 0 {
+		window.Duration = sketchWindow.duration.String()
+	}
+	if len(sketchWindow.entries) != 0 {
+		window.FirstInterval = sketchWindow.entries[0].interval.ID
+		window.LastInterval = sketchWindow.entries[len(sketchWindow.entries)-1].interval.ID
+		window.ReadCount = sketchWindow.readCount - sketchWindow.entries[0].start
+	}
+	return window
+}
@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/will-rowe/hulk/src/kmerspectrum"
+)
+
+// testWindowIntervals returns a set of intervals of 10 reads, one minute apart, where each interval uses its own k-mer spectrum bin
+func testWindowIntervals(numIntervals int) []*Interval {
+	start := time.Now()
+	intervals := make([]*Interval, numIntervals)
+	for i := range intervals {
+		intervals[i] = &Interval{
+			ID:        i + 1,
+			ReadCount: uint(i+1) * 10,
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			Bins:      []*kmerspectrum.Bin{{BinID: int32(i), Frequency: 1.0}, {BinID: 100, Frequency: 1.0}},
+		}
+	}
+	return intervals
+}
+
+// check that intervals are expired once they fall out of the window
+func TestSketchWindow(t *testing.T) {
+	tests := []struct {
+		reads    uint
+		duration time.Duration
+		first    int
+	}{
+		{30, 0, 3},
+		{0, 90 * time.Second, 4},
+		{30, 90 * time.Second, 4},
+		{30, time.Hour, 3},
+		{1000, 0, 1},
+	}
+	for _, test := range tests {
+		window := newSketchWindow(test.reads, test.duration)
+		for _, interval := range testWindowIntervals(5) {
+			window.add(interval)
+		}
+		description := window.describe()
+		if description.FirstInterval != test.first || description.LastInterval != 5 {
+			t.Fatalf("window (%d reads, %v) covers intervals %d-%d, expected %d-5", test.reads, test.duration, description.FirstInterval, description.LastInterval, test.first)
+		}
+		if expected := uint(5-test.first+1) * 10; description.ReadCount != expected {
+			t.Fatalf("window (%d reads, %v) covers %d reads, expected %d", test.reads, test.duration, description.ReadCount, expected)
+		}
+
+		// the spectrum should only have the bins from the intervals in the window, with the shared bin summed across them
+		spectrum := window.spectrum()
+		if len(spectrum) != 5-test.first+2 {
+			t.Fatalf("window spectrum has %d bins, expected %d", len(spectrum), 5-test.first+2)
+		}
+		if spectrum[0].BinID != int32(test.first-1) {
+			t.Fatalf("window spectrum starts with bin %d, expected %d", spectrum[0].BinID, test.first-1)
+		}
+		if shared := spectrum[len(spectrum)-1]; shared.BinID != 100 || shared.Frequency != float64(5-test.first+1) {
+			t.Fatalf("shared bin has frequency %f, expected %d", shared.Frequency, 5-test.first+1)
+		}
+	}
+}
+
+// check that the windowed histosketch only reflects the intervals in the window
+func TestSketchWindowHistosketch(t *testing.T) {
+	sketchCmd := &SketchCmd{KmerSize: 3, SketchSize: 20, SpectrumSize: 200, DecayRatio: 1.0, Seed: 1}
+	intervals := testWindowIntervals(6)
+	window := newSketchWindow(30, 0)
+	for _, interval := range intervals {
+		window.add(interval)
+	}
+	windowed, err := window.sketch(sketchCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the windowed sketch should match a sketch of only the recent intervals
+	recent := newSketchWindow(0, 0)
+	recent.readCount = intervals[2].ReadCount
+	for _, interval := range intervals[3:] {
+		recent.add(interval)
+	}
+	expected, err := recent.sketch(sketchCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, bin := range windowed.Sketch {
+		if bin != expected.Sketch[i] {
+			t.Fatalf("windowed sketch does not match a sketch of the intervals in the window")
+		}
+		if bin < 3 {
+			t.Fatalf("windowed sketch contains a bin from an expired interval: %d", bin)
+		}
+	}
+}
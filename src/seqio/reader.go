@@ -0,0 +1,239 @@
+package seqio
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// READER_BUFFER is the size of the buffer used by the sequence reader (lines can be longer than this)
+const READER_BUFFER int = 1 << 20
+
+// magic bytes used to detect compressed input
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ParseError is returned by the Reader when it encounters a malformed record
+type ParseError struct {
+	Line int    // the line number where the problem was found
+	Msg  string // a description of the problem
+}
+
+// Error satisfies the error interface
+func (ParseError *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", ParseError.Line, ParseError.Msg)
+}
+
+// Reader is a streaming FASTA/FASTQ reader, which detects the sequence format and any compression from the input data
+type Reader struct {
+	br          *bufio.Reader
+	closer      func()
+	format      string // FASTA or FASTQ
+	compression string // none, gzip, bzip2 or zstd
+	lineNum     int    // the number of lines read so far
+	pending     []byte // holds a line that has been read but not yet used
+	hasPending  bool
+}
+
+// NewReader is the constructor function, which sniffs the compression and sequence format from the start of the input
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{compression: "none", closer: func() {}}
+
+	// check for compression
+	raw := bufio.NewReaderSize(r, READER_BUFFER)
+	magic, err := raw.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) == 0 {
+		return nil, fmt.Errorf("no sequence data found")
+	}
+	var decompressed io.Reader
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(raw)
+		if err != nil {
+			return nil, fmt.Errorf("could not read gzip data: %v", err)
+		}
+		reader.compression, reader.closer = "gzip", func() { gz.Close() }
+		decompressed = gz
+	case bytes.HasPrefix(magic, bzip2Magic):
+		reader.compression = "bzip2"
+		decompressed = bzip2.NewReader(raw)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(raw)
+		if err != nil {
+			return nil, fmt.Errorf("could not read zstd data: %v", err)
+		}
+		reader.compression, reader.closer = "zstd", zr.Close
+		decompressed = zr
+	default:
+		reader.br = raw
+	}
+	if decompressed != nil {
+		reader.br = bufio.NewReaderSize(decompressed, READER_BUFFER)
+	}
+
+	// check the sequence format, using the first non-empty line
+	line, err := reader.peekLine()
+	if err == io.EOF {
+		return nil, fmt.Errorf("no sequence data found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '>':
+		reader.format = "FASTA"
+	case '@':
+		reader.format = "FASTQ"
+	default:
+		return nil, &ParseError{reader.lineNum, "unrecognised sequence format (expected a record starting with > or @)"}
+	}
+	return reader, nil
+}
+
+// Format is a method to return the detected sequence format (FASTA or FASTQ)
+func (Reader *Reader) Format() string {
+	return Reader.format
+}
+
+// Compression is a method to return the detected compression (none, gzip, bzip2 or zstd)
+func (Reader *Reader) Compression() string {
+	return Reader.compression
+}
+
+// Close is a method to release any decompressor used by the Reader (it does not close the underlying io.Reader)
+func (Reader *Reader) Close() {
+	Reader.closer()
+}
+
+// Next is a method to return the next record, it returns io.EOF once the input is exhausted
+// FASTA records are returned as FASTQreads without quality scores
+func (Reader *Reader) Next() (*FASTQread, error) {
+	if Reader.format == "FASTA" {
+		return Reader.nextFASTA()
+	}
+	return Reader.nextFASTQ()
+}
+
+// nextFASTA is an unexported method to read a (possibly multi-line) FASTA record
+func (Reader *Reader) nextFASTA() (*FASTQread, error) {
+	header, err := Reader.nextLine()
+	if err != nil {
+		return nil, err
+	}
+	if header[0] != '>' {
+		return nil, &ParseError{Reader.lineNum, fmt.Sprintf("FASTA header does not begin with >: %v", truncate(header))}
+	}
+
+	// collect sequence lines until the next header or the end of the input
+	var seq []byte
+	for {
+		line, err := Reader.peekLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line[0] == '>' {
+			break
+		}
+		Reader.hasPending = false
+		seq = append(seq, line...)
+	}
+	return &FASTQread{Sequence: Sequence{ID: header, Seq: seq}}, nil
+}
+
+// nextFASTQ is an unexported method to read a (possibly multi-line) FASTQ record
+func (Reader *Reader) nextFASTQ() (*FASTQread, error) {
+	header, err := Reader.nextLine()
+	if err != nil {
+		return nil, err
+	}
+	if header[0] != '@' {
+		return nil, &ParseError{Reader.lineNum, fmt.Sprintf("FASTQ header does not begin with @: %v", truncate(header))}
+	}
+
+	// collect sequence lines until the + separator
+	var seq, misc []byte
+	for {
+		line, err := Reader.nextLine()
+		if err == io.EOF {
+			return nil, &ParseError{Reader.lineNum, fmt.Sprintf("truncated FASTQ record: %v", truncate(header))}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line[0] == '+' {
+			misc = line
+			break
+		}
+		seq = append(seq, line...)
+	}
+
+	// collect quality lines until they match the sequence length (quality lines can start with @ or +, so the length is used)
+	var qual []byte
+	for len(qual) < len(seq) {
+		line, err := Reader.nextLine()
+		if err == io.EOF {
+			return nil, &ParseError{Reader.lineNum, fmt.Sprintf("truncated FASTQ record: %v", truncate(header))}
+		}
+		if err != nil {
+			return nil, err
+		}
+		qual = append(qual, line...)
+	}
+	if len(qual) != len(seq) {
+		return nil, &ParseError{Reader.lineNum, fmt.Sprintf("sequence and quality lengths differ (%d vs. %d): %v", len(seq), len(qual), truncate(header))}
+	}
+	return &FASTQread{Sequence: Sequence{ID: header, Seq: seq}, Misc: misc, Qual: qual}, nil
+}
+
+// nextLine is an unexported method to return the next non-empty line, it will return io.EOF once the input is exhausted
+func (Reader *Reader) nextLine() ([]byte, error) {
+	line, err := Reader.peekLine()
+	Reader.hasPending = false
+	return line, err
+}
+
+// peekLine is an unexported method to return the next non-empty line without consuming it
+// lines of any length are supported and line endings (\n or \r\n) are removed
+func (Reader *Reader) peekLine() ([]byte, error) {
+	if Reader.hasPending {
+		return Reader.pending, nil
+	}
+	for {
+		line, err := Reader.br.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return nil, err
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		Reader.lineNum++
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			continue
+		}
+		Reader.pending, Reader.hasPending = line, true
+		return line, nil
+	}
+}
+
+// truncate is a helper function to shorten a line for error messages
+func truncate(line []byte) string {
+	if len(line) > 50 {
+		return string(line[:50]) + "..."
+	}
+	return string(line)
+}
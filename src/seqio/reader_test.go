@@ -0,0 +1,118 @@
+package seqio
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	fastq = "@r1 comment\nACGT\n+\nIIII\n\n@r2\nAC\nGT\n+\n@I\nII\n"
+	fasta = ">s1\nACGT\nACGT\n\n>s2\r\nTTTT\r\n"
+
+	// bzip2 compressed "@r1\nACGT\n+\nIIII\n"
+	bzip2Data = []byte{0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xaf, 0x85, 0x72, 0x8b, 0x00, 0x00, 0x03, 0xde, 0x80, 0x40, 0x10, 0x00, 0x08, 0x20, 0x00, 0x68, 0xa0, 0x04, 0x00, 0x10, 0x00, 0x20, 0x00, 0x22, 0x01, 0xa3, 0x4d, 0x08, 0x06, 0x9a, 0x68, 0x3d, 0x20, 0x05, 0x0c, 0x78, 0xbd, 0x25, 0xe2, 0xee, 0x48, 0xa7, 0x0a, 0x12, 0x15, 0xf0, 0xae, 0x51, 0x60}
+)
+
+// readAll is a helper function to collect all the records from a reader
+func readAll(t *testing.T, r io.Reader) (*Reader, []*FASTQread) {
+	reader, err := NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reads := []*FASTQread{}
+	for {
+		read, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		reads = append(reads, read)
+	}
+	return reader, reads
+}
+
+func TestReaderFASTQ(t *testing.T) {
+	reader, reads := readAll(t, strings.NewReader(fastq))
+	if reader.Format() != "FASTQ" || reader.Compression() != "none" {
+		t.Fatalf("incorrect format detected: %v %v", reader.Format(), reader.Compression())
+	}
+	if len(reads) != 2 {
+		t.Fatalf("expected 2 reads, got %d", len(reads))
+	}
+	if string(reads[1].Seq) != "ACGT" || string(reads[1].Qual) != "@III" {
+		t.Fatalf("multi-line FASTQ record parsed incorrectly: %v %v", string(reads[1].Seq), string(reads[1].Qual))
+	}
+}
+
+func TestReaderFASTA(t *testing.T) {
+	reader, reads := readAll(t, strings.NewReader(fasta))
+	if reader.Format() != "FASTA" {
+		t.Fatalf("incorrect format detected: %v", reader.Format())
+	}
+	if len(reads) != 2 || string(reads[0].Seq) != "ACGTACGT" || string(reads[1].Seq) != "TTTT" {
+		t.Fatal("multi-line FASTA records parsed incorrectly")
+	}
+}
+
+func TestReaderLongLines(t *testing.T) {
+	seq := strings.Repeat("ACGT", 100000)
+	_, reads := readAll(t, strings.NewReader(">contig\n"+seq+"\n"))
+	if len(reads) != 1 || len(reads[0].Seq) != len(seq) {
+		t.Fatal("failed to read a sequence line longer than 64KB")
+	}
+}
+
+func TestReaderCompression(t *testing.T) {
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write([]byte(fastq))
+	gzw.Close()
+	var zs bytes.Buffer
+	zsw, err := zstd.NewWriter(&zs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zsw.Write([]byte(fastq))
+	zsw.Close()
+	for compression, data := range map[string][]byte{"gzip": gz.Bytes(), "bzip2": bzip2Data, "zstd": zs.Bytes()} {
+		reader, reads := readAll(t, bytes.NewReader(data))
+		if reader.Compression() != compression || reader.Format() != "FASTQ" {
+			t.Fatalf("incorrect compression detected: %v (expected %v)", reader.Compression(), compression)
+		}
+		if len(reads) == 0 || string(reads[0].Seq) != "ACGT" {
+			t.Fatalf("failed to read %v compressed data", compression)
+		}
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	if _, err := NewReader(strings.NewReader("")); err == nil {
+		t.Fatal("empty input should return an error")
+	}
+	if _, err := NewReader(strings.NewReader("\n\nACGT\n")); err == nil {
+		t.Fatal("unrecognised format should return an error")
+	}
+	reader, err := NewReader(strings.NewReader("@r1\nACGT\n+\nIIII\n@r2\nACGT\n+\nIII\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = reader.Next()
+	parseErr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected a ParseError for a truncated record, got: %v", err)
+	}
+	if parseErr.Line != 8 {
+		t.Fatalf("expected error on line 8, got line %d", parseErr.Line)
+	}
+}
@@ -2,6 +2,7 @@
 package seqio
 
 import (
+	"bytes"
 	"fmt"
 	"unicode"
 )
@@ -32,6 +33,38 @@ type FASTQread struct {
 	RC   bool
 }
 
+// Fragment holds the read(s) sequenced from a single DNA fragment (one read for single-end data, two reads for paired-end data)
+type Fragment []*FASTQread
+
+// NewFragment is the constructor function, which will check that any supplied mates have matching read IDs
+func NewFragment(reads ...*FASTQread) (Fragment, error) {
+	switch len(reads) {
+	case 1:
+	case 2:
+		if !bytes.Equal(reads[0].Name(), reads[1].Name()) {
+			return nil, fmt.Errorf("read IDs of mates do not match: %v vs. %v", string(reads[0].ID), string(reads[1].ID))
+		}
+	default:
+		return nil, fmt.Errorf("a fragment must have 1 or 2 reads, not %d", len(reads))
+	}
+	return Fragment(reads), nil
+}
+
+// Name is a method to return the read name, without the leading @ or >, any comment or any /1 /2 mate suffix
+func (Sequence *Sequence) Name() []byte {
+	name := Sequence.ID
+	if len(name) > 0 && (name[0] == '@' || name[0] == '>') {
+		name = name[1:]
+	}
+	if i := bytes.IndexAny(name, " \t"); i != -1 {
+		name = name[:i]
+	}
+	if len(name) > 1 && name[len(name)-2] == '/' && (name[len(name)-1] == '1' || name[len(name)-1] == '2') {
+		name = name[:len(name)-2]
+	}
+	return name
+}
+
 // NewFASTQread is the constructor function, which takes 4 lines of a fastq entry and returns the FASTQread object
 // TODO: this is garbage - needs replacing
 func NewFASTQread(l1 []byte, l2 []byte, l3 []byte, l4 []byte) (*FASTQread, error) {
@@ -69,6 +102,22 @@ func (Sequence *Sequence) BaseCheck() error {
 	return nil
 }
 
+// AmbiguousFraction is a method to return the fraction of bases in a sequence that are not A, C, G or T (in either case)
+func (Sequence *Sequence) AmbiguousFraction() float64 {
+	if len(Sequence.Seq) == 0 {
+		return 0
+	}
+	ambiguous := 0
+	for _, base := range Sequence.Seq {
+		switch base {
+		case 'A', 'C', 'G', 'T', 'a', 'c', 'g', 't':
+		default:
+			ambiguous++
+		}
+	}
+	return float64(ambiguous) / float64(len(Sequence.Seq))
+}
+
 // ReverseComplement is a method to reverse complement a sequence held by a FASTQread
 func (FASTQread *FASTQread) ReverseComplement() {
 	for i, j := 0, len(FASTQread.Seq); i < j; i++ {
@@ -0,0 +1,53 @@
+package seqio
+
+import (
+	"testing"
+)
+
+var (
+	l1 = []byte("@0_chr1_0_2246203_2246302/1")
+	l2 = []byte("TTGGCTTTGTATTCTTTCATTTTTTAG")
+	l3 = []byte("+")
+	l4 = []byte("====@==@AAD?>D@@==DACBC?@BB")
+)
+
+func TestName(t *testing.T) {
+	for _, id := range []string{"@read1", "@read1/1", "@read1/2", "@read1 1:N:0:ATCACG", ">read1 description"} {
+		seq := &Sequence{ID: []byte(id)}
+		if string(seq.Name()) != "read1" {
+			t.Fatalf("incorrect read name for %v: %v", id, string(seq.Name()))
+		}
+	}
+}
+
+func TestNewFragment(t *testing.T) {
+	r1, err := NewFASTQread(l1, l2, l3, l4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := NewFASTQread([]byte("@0_chr1_0_2246203_2246302/2"), l2, l3, l4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fragment, err := NewFragment(r1, r2); err != nil || len(fragment) != 2 {
+		t.Fatal("failed to create paired fragment")
+	}
+	r3, err := NewFASTQread([]byte("@1_chr1_0_1098448_1098547/2"), l2, l3, l4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFragment(r1, r3); err == nil {
+		t.Fatal("mates with different read IDs should not form a fragment")
+	}
+}
+
+func TestAmbiguousFraction(t *testing.T) {
+	seq := &Sequence{Seq: []byte("ACGTNnacgR")}
+	if seq.AmbiguousFraction() != 0.3 {
+		t.Fatalf("expected ambiguous fraction of 0.3, got %v", seq.AmbiguousFraction())
+	}
+	empty := &Sequence{}
+	if empty.AmbiguousFraction() != 0 {
+		t.Fatal("empty sequence should have an ambiguous fraction of 0")
+	}
+}
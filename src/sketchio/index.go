@@ -0,0 +1,344 @@
+package sketchio
+
+/*
+ this part of the package holds a persistent LSH Forest index of sketches, which is used to search for the sketches most similar to a query sketch
+
+ the index file holds the sketches rather than the forest, and the forest is rebuilt when the index is loaded, so that sketches can be added to an existing index
+ histosketch and KHF sketches are indexed by their slots, KMV sketches are binned into slots by hash value first (as a bottom-k sketch doesn't have aligned slots)
+ a KMV sketch with fewer hash values than slots will have empty slots, which are given a value unique to the sketch so that sparse sketches aren't matched on their empty slots
+*/
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/will-rowe/hulk/src/distances"
+	"github.com/will-rowe/hulk/src/histosketch"
+	"github.com/will-rowe/hulk/src/lshforest"
+	"github.com/will-rowe/hulk/src/minhash"
+	"github.com/will-rowe/hulk/src/minimizer"
+)
+
+// INDEX_VERSION is the version of the index format, which is increased whenever a change stops older indexes from being loaded
+const INDEX_VERSION int = 1
+
+// IndexAlgorithms is a list of the sketching algorithms that can be indexed
+var IndexAlgorithms = []string{"histosketch", "kmv", "khf"}
+
+// IndexSettings are the settings that every sketch in an index must share
+type IndexSettings struct {
+	Algorithm    string                 // the sketching algorithm
+	KmerSize     uint                   // the k-mer size of the sketches
+	SketchSize   uint                   // the number of slots in each indexed sketch
+	Trees        int                    // the number of trees in the LSH Forest
+	HashFunc     string                 // the hash function used to make the sketches
+	HashSeed     uint64                 // the seed used by the hash function
+	Seeder       minimizer.SeederParams // the settings used to select seeds from the sequences
+	Seed         int64                  // the histosketch seed (histosketches only)
+	SampleScheme int                    // the histosketch CWS sample scheme (histosketches only)
+}
+
+// IndexEntry is a sketch held by an index
+type IndexEntry struct {
+	Key       string   // the sketch file
+	FileName  string   // the sequence file(s) that were sketched
+	Banner    string   // the banner label of the sketch
+	Md5sum    string   // the md5sum of the sketch
+	ReadCount uint     // the number of reads sketched
+	Sketch    []uint64 // the sketch
+}
+
+// SearchHit is a sketch found by searching an index
+type SearchHit struct {
+	*IndexEntry
+	Similarity float64 // the estimated similarity between the query and the indexed sketch
+}
+
+// SketchIndex is an LSH Forest index of sketches
+type SketchIndex struct {
+	Format   int                      // the index format (see INDEX_VERSION)
+	Version  string                   // the version of hulk that wrote the index
+	Settings IndexSettings            // the settings shared by the indexed sketches
+	Entries  []*IndexEntry            // the indexed sketches
+	lookup   map[string]int           // the position of each sketch in Entries
+	forest   *lshforest.LSHforest     // the forest (nil until it is needed)
+	template *histosketch.HistoSketch // holds the histosketch settings to check new histosketches against
+}
+
+// NewSketchIndex is the constructor function
+// the rest of the settings are taken from the first sketch that is added
+func NewSketchIndex(algo string, kSize uint, numTrees int, version string) (*SketchIndex, error) {
+	algoCheck := false
+	for _, supported := range IndexAlgorithms {
+		if algo == supported {
+			algoCheck = true
+		}
+	}
+	if !algoCheck {
+		return nil, fmt.Errorf("can't index %v sketches, please select one of the following: %v", algo, IndexAlgorithms)
+	}
+	if numTrees < 1 {
+		return nil, fmt.Errorf("an index needs at least 1 tree")
+	}
+	return &SketchIndex{
+		Format:   INDEX_VERSION,
+		Version:  version,
+		Settings: IndexSettings{Algorithm: algo, KmerSize: kSize, Trees: numTrees},
+		Entries:  []*IndexEntry{},
+		lookup:   make(map[string]int),
+	}, nil
+}
+
+// LoadSketchIndex is a function to read an index from disk
+func LoadSketchIndex(fileName string) (*SketchIndex, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	gz, err := gzip.NewReader(fh)
+	if err != nil {
+		return nil, fmt.Errorf("%v is not a hulk index: %v", fileName, err)
+	}
+	defer gz.Close()
+	index := &SketchIndex{}
+	if err := gob.NewDecoder(gz).Decode(index); err != nil {
+		return nil, fmt.Errorf("could not read index %v: %v", fileName, err)
+	}
+	if index.Format != INDEX_VERSION {
+		return nil, fmt.Errorf("index %v has format %d, but this version of hulk needs format %d", fileName, index.Format, INDEX_VERSION)
+	}
+	index.lookup = make(map[string]int, len(index.Entries))
+	for i, entry := range index.Entries {
+		index.lookup[entry.Key] = i
+	}
+	return index, nil
+}
+
+// Write is a method to write the index to disk as gzipped gob
+// the index is written to a temporary file which then replaces any existing index, so that a failed write won't lose the previous index
+func (SketchIndex *SketchIndex) Write(fileName string) error {
+	fh, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fh.Name())
+	if err := fh.Chmod(0644); err != nil {
+		fh.Close()
+		return err
+	}
+	gz := gzip.NewWriter(fh)
+	if err := gob.NewEncoder(gz).Encode(SketchIndex); err != nil {
+		fh.Close()
+		return fmt.Errorf("could not encode index: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fh.Name(), fileName)
+}
+
+// Size is a method to return the number of sketches in the index
+func (SketchIndex *SketchIndex) Size() int {
+	return len(SketchIndex.Entries)
+}
+
+// Add is a method to add a sketch to the index, using the sketch file as its key
+// it returns false if the sketch is already in the index, a sketch file that has changed since it was indexed is replaced
+func (SketchIndex *SketchIndex) Add(key string, hulkData *HULKdata) (bool, error) {
+	sketchObj, err := SketchIndex.checkSketch(hulkData)
+	if err != nil {
+		return false, err
+	}
+
+	// the first sketch sets the rest of the index settings
+	if len(SketchIndex.Entries) == 0 {
+		SketchIndex.Settings.HashFunc = hulkData.HashFunc
+		SketchIndex.Settings.HashSeed = hulkData.HashSeed
+		SketchIndex.Settings.Seeder = hulkData.Seeder
+		SketchIndex.Settings.SketchSize = uint(len(sketchObj.GetSketch()))
+		if int(SketchIndex.Settings.SketchSize) < SketchIndex.Settings.Trees {
+			return false, fmt.Errorf("sketch size (%d) is smaller than the number of trees in the index (%d)", SketchIndex.Settings.SketchSize, SketchIndex.Settings.Trees)
+		}
+		if hs, ok := sketchObj.(*histosketch.HistoSketch); ok {
+			SketchIndex.Settings.Seed = hs.Seed
+			SketchIndex.Settings.SampleScheme = hs.SampleScheme
+		}
+		SketchIndex.template = nil
+	}
+	entry := &IndexEntry{
+		Key:       key,
+		FileName:  hulkData.FileName,
+		Banner:    hulkData.Banner,
+		Md5sum:    sketchObj.GetMD5(),
+		ReadCount: hulkData.ReadCount,
+		Sketch:    append([]uint64{}, sketchObj.GetSketch()...),
+	}
+
+	// replacing a sketch means the forest has to be rebuilt
+	if i, ok := SketchIndex.lookup[key]; ok {
+		if SketchIndex.Entries[i].Md5sum == entry.Md5sum {
+			return false, nil
+		}
+		SketchIndex.Entries[i] = entry
+		SketchIndex.forest = nil
+		return true, nil
+	}
+	SketchIndex.lookup[key] = len(SketchIndex.Entries)
+	SketchIndex.Entries = append(SketchIndex.Entries, entry)
+	if SketchIndex.forest != nil {
+		return true, SketchIndex.forest.Add(key, SketchIndex.signature(entry.Sketch, emptySlot(SketchIndex.lookup[key])))
+	}
+	return true, nil
+}
+
+// Search is a method to find the indexed sketches most similar to a query sketch
+// it returns up to topK sketches, ranked by their estimated similarity to the query
+func (SketchIndex *SketchIndex) Search(query *HULKdata, topK int) ([]*SearchHit, error) {
+	if len(SketchIndex.Entries) == 0 {
+		return nil, fmt.Errorf("the index is empty")
+	}
+	if topK < 1 {
+		return nil, fmt.Errorf("need to search for at least 1 sketch")
+	}
+	sketchObj, err := SketchIndex.checkSketch(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := SketchIndex.buildForest(); err != nil {
+		return nil, err
+	}
+
+	// collect the candidates from the forest, then rank them by their similarity to the query
+	candidates, err := SketchIndex.forest.Query(SketchIndex.signature(sketchObj.GetSketch(), math.MaxUint64), topK)
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]*SearchHit, len(candidates))
+	for i, key := range candidates {
+		entry := SketchIndex.Entries[SketchIndex.lookup[key]]
+		similarity, err := SketchIndex.similarity(sketchObj.GetSketch(), entry.Sketch)
+		if err != nil {
+			return nil, err
+		}
+		hits[i] = &SearchHit{entry, similarity}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Similarity != hits[j].Similarity {
+			return hits[i].Similarity > hits[j].Similarity
+		}
+		return hits[i].Key < hits[j].Key
+	})
+	if len(hits) > topK {
+		hits = hits[:topK]
+	}
+	return hits, nil
+}
+
+// checkSketch is an unexported method to find the sketch to index in a HULKdata and check that it matches the index settings
+func (SketchIndex *SketchIndex) checkSketch(hulkData *HULKdata) (SketchObject, error) {
+	settings := SketchIndex.Settings
+	sketchObj, err := hulkData.FindSketch(settings.KmerSize, settings.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if len(SketchIndex.Entries) == 0 {
+		return sketchObj, nil
+	}
+
+	// sketches can only be compared if their seeds were selected and hashed in the same way
+	reference := &HULKdata{HashFunc: settings.HashFunc, HashSeed: settings.HashSeed, Seeder: settings.Seeder}
+	if err := reference.CheckHash(hulkData); err != nil {
+		return nil, err
+	}
+	if err := reference.CheckSeeder(hulkData); err != nil {
+		return nil, err
+	}
+
+	// histosketches can only be compared if they used the same consistent weighted samples, and slots can only be compared if the sketches are the same size
+	if hs, ok := sketchObj.(*histosketch.HistoSketch); ok {
+		if SketchIndex.template == nil {
+			SketchIndex.template = &histosketch.HistoSketch{Seed: settings.Seed, SampleScheme: settings.SampleScheme}
+		}
+		if err := SketchIndex.template.CheckCompatible(hs); err != nil {
+			return nil, err
+		}
+	}
+	if settings.Algorithm != "kmv" && uint(len(sketchObj.GetSketch())) != settings.SketchSize {
+		return nil, fmt.Errorf("sketch length mismatch: %d vs %d", len(sketchObj.GetSketch()), settings.SketchSize)
+	}
+	return sketchObj, nil
+}
+
+// buildForest is an unexported method to add all the indexed sketches to a new forest, if there isn't one already
+func (SketchIndex *SketchIndex) buildForest() error {
+	if SketchIndex.forest != nil {
+		return nil
+	}
+	forest, err := lshforest.NewLSHforest(int(SketchIndex.Settings.SketchSize), SketchIndex.Settings.Trees)
+	if err != nil {
+		return err
+	}
+	for i, entry := range SketchIndex.Entries {
+		if err := forest.Add(entry.Key, SketchIndex.signature(entry.Sketch, emptySlot(i))); err != nil {
+			return err
+		}
+	}
+	SketchIndex.forest = forest
+	return nil
+}
+
+// signature is an unexported method to get the slots that are added to the forest for a sketch
+// KMV sketches hold the smallest hash values rather than a minimum per slot, so each hash value is put in a slot using its remainder and the smallest value in each slot is kept
+// any slot without a hash value is set to empty, which should be unique to the sketch so that the empty slots of two sketches don't match
+func (SketchIndex *SketchIndex) signature(sketch []uint64, empty uint64) []uint64 {
+	if SketchIndex.Settings.Algorithm != "kmv" {
+		return sketch
+	}
+	slots := make([]uint64, SketchIndex.Settings.SketchSize)
+	filled := make([]bool, len(slots))
+	for _, hv := range sketch {
+		slot := hv % uint64(len(slots))
+		if !filled[slot] || hv < slots[slot] {
+			slots[slot] = hv
+			filled[slot] = true
+		}
+	}
+	for i := range slots {
+		if !filled[i] {
+			slots[i] = empty
+		}
+	}
+	return slots
+}
+
+// emptySlot is an unexported function to get the value used for the empty KMV slots of an indexed sketch, using its position in the index
+// the query sketch uses math.MaxUint64 for its empty slots, so the indexed sketches count down from the value below it
+func emptySlot(position int) uint64 {
+	return math.MaxUint64 - 1 - uint64(position)
+}
+
+// similarity is an unexported method to estimate the similarity of two sketches, using the same estimate as smash with the jaccard metric
+func (SketchIndex *SketchIndex) similarity(sketchA, sketchB []uint64) (float64, error) {
+	if SketchIndex.Settings.Algorithm == "kmv" {
+		return (&minhash.KMVsketch{Sketch: sketchA}).GetSimilarity(&minhash.KMVsketch{Sketch: sketchB})
+	}
+	setA := make([]float64, len(sketchA))
+	setB := make([]float64, len(sketchB))
+	for i := 0; i < len(setA); i++ {
+		setA[i] = float64(sketchA[i])
+		setB[i] = float64(sketchB[i])
+	}
+	distance, err := distances.GetDistance(setA, setB, "jaccard")
+	return 1.0 - distance, err
+}
@@ -0,0 +1,122 @@
+package sketchio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/will-rowe/hulk/src/histosketch"
+	"github.com/will-rowe/hulk/src/minhash"
+)
+
+// test that an index can be written, loaded, added to and searched
+func TestSketchIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk-sketchio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the fixture sketches overlapping sets of elements, so that each sketch is most similar to its neighbours
+	hulkDatas := testSketches(t, 10)
+	index, err := NewSketchIndex("histosketch", 21, 5, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := index.Add(fmt.Sprintf("%d.json", i), hulkDatas[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	indexFile := filepath.Join(dir, "test.hulk-index")
+	if err := index.Write(indexFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// add to the loaded index, skipping a sketch that is already indexed
+	loaded, err := LoadSketchIndex(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, err := loaded.Add("0.json", hulkDatas[0]); added || err != nil {
+		t.Fatalf("an indexed sketch should be skipped: %v", err)
+	}
+	for i := 5; i < 10; i++ {
+		if _, err := loaded.Add(fmt.Sprintf("%d.json", i), hulkDatas[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loaded.Size() != 10 {
+		t.Fatalf("expected 10 indexed sketches, got %d", loaded.Size())
+	}
+
+	// the query sketch should be found first, followed by its neighbours
+	hits, err := loaded.Search(hulkDatas[5], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 3 || hits[0].Key != "5.json" || hits[0].Similarity != 1.0 {
+		t.Fatalf("search did not find the query sketch first: %+v", hits)
+	}
+	for _, hit := range hits[1:] {
+		if hit.Key != "4.json" && hit.Key != "6.json" {
+			t.Fatalf("search did not find the neighbouring sketches: %v", hit.Key)
+		}
+	}
+
+	// sketches that don't match the index settings can't be added
+	seeded, err := histosketch.NewHistoSketch(21, 50, 1000, 1.0, histosketch.DISTRIBUTION_SEED+1, histosketch.CountMinSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeded.AddElement(1, 1.0)
+	seededData := NewHULKdata()
+	seededData.Add(seeded)
+	if _, err := loaded.Add("seeded.json", seededData); err == nil {
+		t.Fatal("histosketches with different seeds should not be indexed together")
+	}
+
+	// KMV sketches that aren't full have empty slots, which shouldn't make unrelated sketches share keys
+	kmvIndex, err := NewSketchIndex("kmv", 21, 5, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kmvSketch := func(hashes ...uint64) *HULKdata {
+		kmv := minhash.NewKMVsketch(21, 50)
+		for _, hv := range hashes {
+			kmv.AddHash(hv)
+		}
+		hulkData := NewHULKdata()
+		if err := hulkData.Add(kmv); err != nil {
+			t.Fatal(err)
+		}
+		return hulkData
+	}
+	full := make([]uint64, 50)
+	for i := range full {
+		full[i] = uint64(i) * 1000
+	}
+	for i, hashes := range [][]uint64{full, {1, 2, 3}, {4, 5, 6}} {
+		if _, err := kmvIndex.Add(fmt.Sprintf("%d.json", i), kmvSketch(hashes...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sparseA := kmvIndex.signature(kmvIndex.Entries[1].Sketch, emptySlot(1))
+	sparseB := kmvIndex.signature(kmvIndex.Entries[2].Sketch, emptySlot(2))
+	for i := range sparseA {
+		if sparseA[i] == sparseB[i] {
+			t.Fatalf("unrelated sparse KMV sketches share slot %d", i)
+		}
+	}
+
+	// the search should fall back to sketches that share no slots with the query when there aren't enough that do
+	hits, err = kmvIndex.Search(kmvSketch(1, 2, 3), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 3 || hits[0].Key != "1.json" || hits[0].Similarity != 1.0 {
+		t.Fatalf("KMV search did not find the query sketch first: %+v", hits)
+	}
+}
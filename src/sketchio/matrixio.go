@@ -0,0 +1,270 @@
+package sketchio
+
+/*
+ this part of the package writes the results of hulk smash to disk
+
+ the matrix formats (csv, tsv, phylip and npy) are written one row at a time, so that a tiled smash can write each row once it is finished
+ the long format is written one pair at a time, with each pair of sketches written once (the upper triangle of the matrix, without the diagonal)
+ npy files can't hold the row labels, so they are written to a separate file (one label per line, in row order)
+ phylip matrices are written in the relaxed phylip format, where the labels are separated from the values by whitespace rather than padded or truncated to 10 characters, as the labels are usually longer than that
+*/
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MatrixFormats are the formats that a smash matrix can be written in
+var MatrixFormats = []string{"csv", "tsv", "phylip", "long", "npy"}
+
+// LabelSources are the sketch fields that can be used to label the rows and columns of a smash matrix
+var LabelSources = []string{"path", "filename", "banner"}
+
+// MatrixWriter writes a smash matrix to disk as it is made
+type MatrixWriter struct {
+	format    string        // the output format (one of MatrixFormats)
+	labels    []string      // the label for each row (and column)
+	valueName string        // what the values are (used for the long format header)
+	precision int           // the number of decimal places to write (ignored by npy)
+	fh        *os.File      // the output file
+	buf       *bufio.Writer // buffers the phylip and npy output
+	writer    *csv.Writer   // writes the csv, tsv and long output
+	row       int           // the number of rows written so far
+}
+
+// MatrixFileName is a function to get the name of the file that a smash matrix is written to
+func MatrixFileName(outFile, format string) string {
+	switch format {
+	case "long":
+		return outFile + ".hulk-pairs.tsv"
+	case "phylip":
+		return outFile + ".hulk-matrix.phylip"
+	default:
+		return outFile + ".hulk-matrix." + format
+	}
+}
+
+// MatrixLabelsFileName is a function to get the name of the file that the row labels of an npy matrix are written to
+func MatrixLabelsFileName(outFile string) string {
+	return outFile + ".hulk-matrix.labels.txt"
+}
+
+// GetLabel is a method to get the label for a sketch from the requested source (the path is the sketch file, which isn't held in the HULKdata)
+// the file names are stored with a trailing comma, which is trimmed from the label
+func (HULKdata *HULKdata) GetLabel(source, path string) (string, error) {
+	switch source {
+	case "path":
+		return path, nil
+	case "filename":
+		return strings.TrimSuffix(HULKdata.FileName, ","), nil
+	case "banner":
+		if HULKdata.Banner == "" {
+			return "", fmt.Errorf("sketch has no banner label: %v", path)
+		}
+		return HULKdata.Banner, nil
+	default:
+		return "", fmt.Errorf("unknown label source: %v", source)
+	}
+}
+
+// CheckLabels is a function to check that the labels can be used for the rows (and columns) of a matrix, which means that they must be unique
+// the file name and banner labels can repeat (e.g. the banner label defaults to "blank"), so they should be checked before the sketches are compared
+func CheckLabels(labels []string) error {
+	rows := make(map[string]int, len(labels))
+	for i, label := range labels {
+		if first, ok := rows[label]; ok {
+			return fmt.Errorf("matrix labels must be unique, but rows %d and %d are both labelled %q", first+1, i+1, label)
+		}
+		rows[label] = i
+	}
+	return nil
+}
+
+// NewMatrixWriter is the constructor function, which creates the output file(s) for the requested format and writes the header
+// the valueName describes the values in the matrix (e.g. similarity or distance) and is used as the column name by the long format
+func NewMatrixWriter(outFile, format string, labels []string, valueName string, precision int) (*MatrixWriter, error) {
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("can't write a matrix without any rows")
+	}
+	if err := CheckLabels(labels); err != nil {
+		return nil, err
+	}
+	MatrixWriter := &MatrixWriter{
+		format:    format,
+		labels:    labels,
+		valueName: valueName,
+		precision: precision,
+	}
+	switch format {
+	case "csv", "tsv", "long", "phylip", "npy":
+	default:
+		return nil, fmt.Errorf("unknown matrix format: %v", format)
+	}
+	if format == "phylip" {
+		for _, label := range labels {
+			if strings.ContainsAny(label, " \t\n") {
+				return nil, fmt.Errorf("phylip labels can't contain whitespace: %q", label)
+			}
+		}
+	}
+	if format == "npy" {
+		if err := writeLabels(MatrixLabelsFileName(outFile), labels); err != nil {
+			return nil, err
+		}
+	}
+	fh, err := os.Create(MatrixFileName(outFile, format))
+	if err != nil {
+		return nil, err
+	}
+	MatrixWriter.fh = fh
+	if err := MatrixWriter.writeHeader(); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	return MatrixWriter, nil
+}
+
+// writeHeader is an unexported method to set up the writers and write the header for the format
+func (MatrixWriter *MatrixWriter) writeHeader() error {
+	switch MatrixWriter.format {
+	case "csv", "tsv", "long":
+		MatrixWriter.writer = csv.NewWriter(MatrixWriter.fh)
+		if MatrixWriter.format != "csv" {
+			MatrixWriter.writer.Comma = '\t'
+		}
+		if MatrixWriter.format == "long" {
+			return MatrixWriter.writer.Write([]string{"sketch_a", "sketch_b", MatrixWriter.valueName})
+		}
+		return MatrixWriter.writer.Write(append([]string{""}, MatrixWriter.labels...))
+	case "phylip":
+		MatrixWriter.buf = bufio.NewWriter(MatrixWriter.fh)
+		_, err := fmt.Fprintf(MatrixWriter.buf, "%d\n", len(MatrixWriter.labels))
+		return err
+	case "npy":
+		MatrixWriter.buf = bufio.NewWriter(MatrixWriter.fh)
+		return writeNpyHeader(MatrixWriter.buf, len(MatrixWriter.labels))
+	}
+	return nil
+}
+
+// WriteRow is a method to write the next row of a matrix format, which must have a value for every column
+func (MatrixWriter *MatrixWriter) WriteRow(row []float64) error {
+	if MatrixWriter.format == "long" {
+		return fmt.Errorf("rows can't be written to the long format, write the pairs instead")
+	}
+	if MatrixWriter.row >= len(MatrixWriter.labels) {
+		return fmt.Errorf("matrix already has %d rows", len(MatrixWriter.labels))
+	}
+	if len(row) != len(MatrixWriter.labels) {
+		return fmt.Errorf("matrix row has %d values, expected %d", len(row), len(MatrixWriter.labels))
+	}
+	label := MatrixWriter.labels[MatrixWriter.row]
+	MatrixWriter.row++
+	switch MatrixWriter.format {
+	case "npy":
+		for _, value := range row {
+			if err := binary.Write(MatrixWriter.buf, binary.LittleEndian, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "phylip":
+		values := make([]string, len(row)+1)
+		values[0] = label
+		for j, value := range row {
+			values[j+1] = MatrixWriter.formatValue(value)
+		}
+		_, err := fmt.Fprintln(MatrixWriter.buf, strings.Join(values, " "))
+		return err
+	default:
+		values := make([]string, len(row)+1)
+		values[0] = label
+		for j, value := range row {
+			values[j+1] = MatrixWriter.formatValue(value)
+		}
+		return MatrixWriter.writer.Write(values)
+	}
+}
+
+// WritePair is a method to write the value for a pair of rows to the long format
+func (MatrixWriter *MatrixWriter) WritePair(rowID, colID int, value float64) error {
+	if MatrixWriter.format != "long" {
+		return fmt.Errorf("pairs can only be written to the long format")
+	}
+	return MatrixWriter.writer.Write([]string{MatrixWriter.labels[rowID], MatrixWriter.labels[colID], MatrixWriter.formatValue(value)})
+}
+
+// Close is a method to flush the output and close the file, which checks that a matrix format received every row
+func (MatrixWriter *MatrixWriter) Close() error {
+	var err error
+	if MatrixWriter.writer != nil {
+		MatrixWriter.writer.Flush()
+		err = MatrixWriter.writer.Error()
+	}
+	if MatrixWriter.buf != nil {
+		if flushErr := MatrixWriter.buf.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	if closeErr := MatrixWriter.fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && MatrixWriter.format != "long" && MatrixWriter.row != len(MatrixWriter.labels) {
+		err = fmt.Errorf("matrix is incomplete: %d of %d rows written", MatrixWriter.row, len(MatrixWriter.labels))
+	}
+	return err
+}
+
+// formatValue is an unexported method to convert a value to a string for the text formats
+func (MatrixWriter *MatrixWriter) formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', MatrixWriter.precision, 64)
+}
+
+// writeNpyHeader is a helper function to write the header of a version 1.0 npy file holding a square matrix of little-endian float64s
+// the header is padded with spaces so that the data starts on a 64 byte boundary
+func writeNpyHeader(w *bufio.Writer, size int) error {
+	dict := fmt.Sprintf("{'descr': '<f8', 'fortran_order': False, 'shape': (%d, %d), }", size, size)
+	preamble := 10 // the magic string, the version and the header length
+	padding := 64 - (preamble+len(dict)+1)%64
+	if padding == 64 {
+		padding = 0
+	}
+	header := dict + strings.Repeat(" ", padding) + "\n"
+	if len(header) > math.MaxUint16 {
+		return fmt.Errorf("npy header is too long")
+	}
+	if _, err := w.WriteString("\x93NUMPY\x01\x00"); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint16(len(header))); err != nil {
+		return err
+	}
+	_, err := w.WriteString(header)
+	return err
+}
+
+// writeLabels is a helper function to write one label per line to a file
+func writeLabels(fileName string, labels []string) error {
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fh)
+	for _, label := range labels {
+		if _, err := fmt.Fprintln(w, label); err != nil {
+			fh.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
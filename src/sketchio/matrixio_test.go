@@ -0,0 +1,115 @@
+package sketchio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// test that the matrix formats are labelled and hold the values that were written
+func TestMatrixWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk-matrix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outFile := filepath.Join(dir, "test")
+	labels := []string{"a", "b", "c"}
+	rows := [][]float64{{0, 0.25, 0.5}, {0.25, 0, 0.125}, {0.5, 0.125, 0}}
+	expected := map[string]string{
+		"csv":    ",a,b,c\na,0.000,0.250,0.500\nb,0.250,0.000,0.125\nc,0.500,0.125,0.000\n",
+		"tsv":    "\ta\tb\tc\na\t0.000\t0.250\t0.500\nb\t0.250\t0.000\t0.125\nc\t0.500\t0.125\t0.000\n",
+		"phylip": "3\na 0.000 0.250 0.500\nb 0.250 0.000 0.125\nc 0.500 0.125 0.000\n",
+		"long":   "sketch_a\tsketch_b\tdistance\na\tb\t0.250\na\tc\t0.500\nb\tc\t0.125\n",
+	}
+	for _, format := range MatrixFormats {
+		out, err := NewMatrixWriter(outFile, format, labels, "distance", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, row := range rows {
+			if format == "long" {
+				for j := i + 1; j < len(row); j++ {
+					if err := out.WritePair(i, j, row[j]); err != nil {
+						t.Fatal(err)
+					}
+				}
+			} else if err := out.WriteRow(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := out.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(MatrixFileName(outFile, format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != "npy" {
+			if string(data) != expected[format] {
+				t.Fatalf("%v output is:\n%v\nexpected:\n%v", format, string(data), expected[format])
+			}
+			continue
+		}
+
+		// check the npy header is aligned and describes the matrix, and that the values follow it
+		headerLen := int(data[8]) | int(data[9])<<8
+		if !bytes.HasPrefix(data, []byte("\x93NUMPY\x01\x00")) || (10+headerLen)%64 != 0 {
+			t.Fatal("npy header is malformed")
+		}
+		if !bytes.Contains(data[10:10+headerLen], []byte("'shape': (3, 3)")) {
+			t.Fatalf("npy header has the wrong shape: %v", string(data[10:10+headerLen]))
+		}
+		if len(data) != 10+headerLen+9*8 {
+			t.Fatalf("npy file has %d bytes of values, expected %d", len(data)-10-headerLen, 9*8)
+		}
+		labelData, err := ioutil.ReadFile(MatrixLabelsFileName(outFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(labelData) != "a\nb\nc\n" {
+			t.Fatalf("npy labels file is %q", string(labelData))
+		}
+	}
+
+	// an incomplete matrix is an error
+	out, err := NewMatrixWriter(outFile, "csv", labels, "distance", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.WriteRow(rows[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err == nil {
+		t.Fatal("incomplete matrix should not close without an error")
+	}
+
+	// the labels must be unique
+	if _, err := NewMatrixWriter(outFile, "csv", []string{"a", "blank", "blank"}, "distance", 3); err == nil || !strings.Contains(err.Error(), `"blank"`) {
+		t.Fatalf("expected an error naming the duplicate label, got %v", err)
+	}
+}
+
+// test that the labels are taken from the requested source, with the trailing comma trimmed from the file names
+func TestGetLabel(t *testing.T) {
+	hulkData := NewHULKdata()
+	hulkData.FileName = "a_1.fq,a_2.fq,"
+	hulkData.Banner = "blank"
+	expected := map[string]string{"path": "a.json", "filename": "a_1.fq,a_2.fq", "banner": "blank"}
+	for source, label := range expected {
+		got, err := hulkData.GetLabel(source, "a.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != label {
+			t.Fatalf("%v label is %q, expected %q", source, got, label)
+		}
+	}
+	hulkData.Banner = ""
+	if _, err := hulkData.GetLabel("banner", "a.json"); err == nil {
+		t.Fatal("a sketch without a banner label should not be labelled by its banner")
+	}
+}
@@ -0,0 +1,285 @@
+package sketchio
+
+/*
+ this part of the package merges sketches made in separate runs (e.g. the lanes or flowcells of one sample) into a single sketch
+
+ KMV, KHF and HyperMinHash sketches and saved spectra are merged exactly
+ a histosketch only holds the minimum for each slot, which can't be combined into a histosketch of all the reads, so histosketches are rebuilt from the merged saved spectra instead
+*/
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/will-rowe/hulk/src/histosketch"
+	"github.com/will-rowe/hulk/src/kmerspectrum"
+	"github.com/will-rowe/hulk/src/minhash"
+)
+
+// MergeSource describes one of the sketches that was merged to make a sketch
+type MergeSource struct {
+	File      string `json:"file,omitempty"`      // the sketch file
+	FileName  string `json:"filename"`            // the sequence file(s) that were sketched
+	Sample    string `json:"sample,omitempty"`    // the sample ID, if the sketch was made from a manifest
+	ReadCount uint   `json:"read_count"`          // the number of reads sketched
+	Timestamp string `json:"timestamp,omitempty"` // the time at which the sketch was taken (RFC3339)
+}
+
+// MergeHULKdata is a function to merge several HULKdata into one, combining the sketches with the same algorithm and k-mer size
+// the sketch files are only used to record where the sketches came from, a sketch that was itself merged contributes its own sources
+// a sketch is only merged if every HULKdata has one with that algorithm and k-mer size, the others are skipped and returned as a list of descriptions
+func MergeHULKdata(sketchFiles []string, hulkDatas []*HULKdata) (*HULKdata, []string, error) {
+	if len(hulkDatas) < 2 {
+		return nil, nil, fmt.Errorf("need at least 2 sketches to merge, not %d", len(hulkDatas))
+	}
+	if len(sketchFiles) != len(hulkDatas) {
+		return nil, nil, fmt.Errorf("need a sketch file for each sketch (%d vs. %d)", len(sketchFiles), len(hulkDatas))
+	}
+
+	// sketches can only be merged if their seeds were selected and hashed in the same way
+	first := hulkDatas[0]
+	for i, hulkData := range hulkDatas[1:] {
+		if err := first.CheckHash(hulkData); err != nil {
+			return nil, nil, fmt.Errorf("can't merge %v and %v: %v", sketchFiles[0], sketchFiles[i+1], err)
+		}
+		if err := first.CheckSeeder(hulkData); err != nil {
+			return nil, nil, fmt.Errorf("can't merge %v and %v: %v", sketchFiles[0], sketchFiles[i+1], err)
+		}
+	}
+
+	// combine the runtime info and record the provenance
+	merged := NewHULKdata()
+	merged.HashFunc = first.HashFunc
+	merged.HashSeed = first.HashSeed
+	merged.Banner = first.Banner
+	merged.Timestamp = time.Now().Format(time.RFC3339)
+	merged.Metadata.Pairing = first.Metadata.Pairing
+	merged.Metadata.Sample = first.Metadata.Sample
+	for key, value := range first.Metadata.Attributes {
+		if merged.Metadata.Attributes == nil {
+			merged.Metadata.Attributes = make(map[string]string)
+		}
+		merged.Metadata.Attributes[key] = value
+	}
+	for i, hulkData := range hulkDatas {
+
+		// older sketches didn't record the seeder settings
+		if merged.Seeder.K == 0 {
+			merged.Seeder = hulkData.Seeder
+		}
+		merged.FileName += strings.TrimSuffix(hulkData.FileName, ",") + ","
+		merged.ReadCount += hulkData.ReadCount
+		if hulkData.Banner != merged.Banner {
+			merged.Banner = "blank"
+		}
+		if hulkData.Metadata.Pairing != merged.Metadata.Pairing {
+			merged.Metadata.Pairing = "mixed"
+		}
+		if hulkData.Metadata.Sample != merged.Metadata.Sample {
+			merged.Metadata.Sample = ""
+		}
+		for key, value := range merged.Metadata.Attributes {
+			if hulkData.Metadata.Attributes[key] != value {
+				delete(merged.Metadata.Attributes, key)
+			}
+		}
+		for filter, dropped := range hulkData.Metadata.ReadFilters {
+			if merged.Metadata.ReadFilters == nil {
+				merged.Metadata.ReadFilters = make(map[string]uint)
+			}
+			merged.Metadata.ReadFilters[filter] += dropped
+		}
+		if len(hulkData.Metadata.MergedFrom) != 0 {
+			merged.Metadata.MergedFrom = append(merged.Metadata.MergedFrom, hulkData.Metadata.MergedFrom...)
+			continue
+		}
+		merged.Metadata.MergedFrom = append(merged.Metadata.MergedFrom, &MergeSource{
+			File:      sketchFiles[i],
+			FileName:  hulkData.FileName,
+			Sample:    hulkData.Metadata.Sample,
+			ReadCount: hulkData.ReadCount,
+			Timestamp: hulkData.Timestamp,
+		})
+	}
+	if len(merged.Metadata.Attributes) == 0 {
+		merged.Metadata.Attributes = nil
+	}
+
+	// merge the sketches
+	skipped := []string{}
+	for _, sig := range first.Signatures {
+		kSize := sketchKmerSize(sig.Sketch)
+		sketches := []SketchObject{sig.Sketch}
+		for i, hulkData := range hulkDatas[1:] {
+			sketch, err := hulkData.FindSketch(kSize, sig.Algorithm)
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("%v (k=%d) is missing from %v", sig.Algorithm, kSize, sketchFiles[i+1]))
+				break
+			}
+			sketches = append(sketches, sketch)
+		}
+		if len(sketches) != len(hulkDatas) {
+			continue
+		}
+
+		// histosketches are rebuilt from the merged saved spectra, so they are skipped if any of the sketches don't have one
+		if sig.Algorithm == "histosketch" {
+			spectra := []SketchObject{}
+			for i, hulkData := range hulkDatas {
+				spectrum, err := hulkData.FindSketch(kSize, "spectrum")
+				if err != nil {
+					skipped = append(skipped, fmt.Sprintf("histosketch (k=%d) can only be merged using saved spectra, which are missing from %v (see hulk sketch --spectrum)", kSize, sketchFiles[i]))
+					break
+				}
+				spectra = append(spectra, spectrum)
+			}
+			if len(spectra) != len(hulkDatas) {
+				continue
+			}
+			cmSettings, err := mergeCountMin(sketchFiles, hulkDatas)
+			if err != nil {
+				return nil, nil, err
+			}
+			hs, err := rebuildHistoSketch(sketches, spectra, cmSettings)
+			if err != nil {
+				return nil, nil, fmt.Errorf("can't merge the histosketch sketches (k=%d): %v", kSize, err)
+			}
+			if err := merged.Add(hs); err != nil {
+				return nil, nil, err
+			}
+			merged.Metadata.CountMin = NewCountMin(hs)
+			continue
+		}
+		sketch, err := mergeSketches(sig.Algorithm, sketches)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't merge the %v sketches (k=%d): %v", sig.Algorithm, kSize, err)
+		}
+		if err := merged.Add(sketch); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(merged.Signatures) == 0 {
+		return nil, nil, fmt.Errorf("the sketches don't share any sketching algorithm and k-mer size")
+	}
+	return merged, skipped, nil
+}
+
+// mergeCountMin is a helper function to get the countmin sketch settings shared by a set of sketches
+func mergeCountMin(sketchFiles []string, hulkDatas []*HULKdata) (histosketch.CountMinSettings, error) {
+	settings := func(cm *CountMin) histosketch.CountMinSettings {
+		if cm == nil {
+			return histosketch.CountMinSettings{}
+		}
+		return histosketch.CountMinSettings{Width: cm.Width, Depth: cm.Depth, Conservative: cm.Conservative}
+	}
+	first := settings(hulkDatas[0].Metadata.CountMin)
+	for i, hulkData := range hulkDatas[1:] {
+		if settings(hulkData.Metadata.CountMin) != first {
+			return first, fmt.Errorf("can't merge %v and %v: the histosketches were made with different countmin sketch settings", sketchFiles[0], sketchFiles[i+1])
+		}
+	}
+	return first, nil
+}
+
+// rebuildHistoSketch is a helper function to make a histosketch of the merged saved spectra, with the same settings as the histosketches being merged
+// this gives the same histosketch as sketching all of the reads together (apart from any differences in countmin sketch collisions)
+func rebuildHistoSketch(histosketches, spectra []SketchObject, cmSettings histosketch.CountMinSettings) (*histosketch.HistoSketch, error) {
+	first := histosketches[0].(*histosketch.HistoSketch)
+	for _, sketch := range histosketches {
+		hs := sketch.(*histosketch.HistoSketch)
+		if err := first.CheckCompatible(hs); err != nil {
+			return nil, err
+		}
+		if hs.SketchSize != first.SketchSize || hs.Dimensions != first.Dimensions {
+			return nil, fmt.Errorf("histosketches have different sizes or numbers of histogram bins")
+		}
+		if hs.ApplyConceptDrift {
+			return nil, fmt.Errorf("can't merge histosketches made with concept drift, as their weights depend on when each element was added")
+		}
+	}
+	mergedSpectrum, err := mergeSketches("spectrum", spectra)
+	if err != nil {
+		return nil, err
+	}
+	spectrum := mergedSpectrum.(*kmerspectrum.SavedSpectrum)
+	if spectrum.NumBins != first.Dimensions {
+		return nil, fmt.Errorf("saved spectra have %d bins, but the histosketches have %d", spectrum.NumBins, first.Dimensions)
+	}
+	merged, err := histosketch.NewHistoSketch(first.KmerSize, first.SketchSize, first.Dimensions, 1.0, first.Seed, cmSettings)
+	if err != nil {
+		return nil, err
+	}
+	for _, bin := range spectrum.GetBins() {
+		if err := merged.AddElement(uint64(bin.BinID), bin.Frequency); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// mergeSketches is a helper function to merge a set of sketches made by the same algorithm into a new sketch
+// histosketches can't be merged in this way (see rebuildHistoSketch)
+func mergeSketches(algo string, sketches []SketchObject) (SketchObject, error) {
+	switch algo {
+	case "kmv":
+		first := sketches[0].(*minhash.KMVsketch)
+		merged := minhash.NewKMVsketch(first.KmerSize, first.SketchSize)
+		for _, sketch := range sketches {
+			if err := merged.Merge(sketch.(*minhash.KMVsketch)); err != nil {
+				return nil, err
+			}
+		}
+		return merged, nil
+	case "khf":
+		first := sketches[0].(*minhash.KHFsketch)
+		merged := minhash.NewKHFsketch(first.KmerSize, first.SketchSize)
+		for _, sketch := range sketches {
+			if err := merged.Merge(sketch.(*minhash.KHFsketch)); err != nil {
+				return nil, err
+			}
+		}
+		return merged, nil
+	case "hyperminhash":
+		first := sketches[0].(*minhash.HyperMinHash)
+		merged := minhash.NewHyperMinHash(first.KmerSize, first.SketchSize)
+		for _, sketch := range sketches {
+			if err := merged.Merge(sketch.(*minhash.HyperMinHash)); err != nil {
+				return nil, err
+			}
+		}
+		return merged, nil
+	case "spectrum":
+		first := sketches[0].(*kmerspectrum.SavedSpectrum)
+		merged, err := kmerspectrum.NewSavedSpectrum(first.KmerSize, first.NumBins, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, sketch := range sketches {
+			if err := merged.Merge(sketch.(*kmerspectrum.SavedSpectrum)); err != nil {
+				return nil, err
+			}
+		}
+		return merged, nil
+	default:
+		return nil, fmt.Errorf("unknown sketching algorithm: %v", algo)
+	}
+}
+
+// sketchKmerSize is a helper function to return the k-mer size of a sketch
+func sketchKmerSize(sketch SketchObject) uint {
+	switch sketch := sketch.(type) {
+	case *histosketch.HistoSketch:
+		return sketch.KmerSize
+	case *minhash.KMVsketch:
+		return sketch.KmerSize
+	case *minhash.KHFsketch:
+		return sketch.KmerSize
+	case *minhash.HyperMinHash:
+		return sketch.KmerSize
+	case *kmerspectrum.SavedSpectrum:
+		return sketch.KmerSize
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,138 @@
+package sketchio
+
+/*
+ this part of the package is the registry of the metrics that hulk smash can use to compare sketches
+
+ each metric declares which representations of a sketch it reads, and each sketching algorithm declares which representations it holds
+ a metric can only be used with a sketching algorithm that holds everything it reads (e.g. weightedjaccard reads the slot IDs and weights of a histosketch, and braycurtis is meaningless on the hash values held by a KMV sketch)
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the representations of a sketch that a metric can read
+const (
+	SLOTS     = "slot IDs"     // the hash value or k-mer spectrum bin held in each sketch slot
+	WEIGHTS   = "slot weights" // the histosketch weight for each sketch slot
+	REGISTERS = "registers"    // the HyperMinHash registers
+)
+
+// SmashAlgorithms are the sketching algorithms that hulk smash can compare (saved spectra are only used by hulk merge and hulk evaluate)
+var SmashAlgorithms = []string{"histosketch", "kmv", "khf", "hyperminhash"}
+
+// sketchRepresentations are the representations held by each sketching algorithm that hulk smash can compare
+var sketchRepresentations = map[string][]string{
+	"histosketch":  {SLOTS, WEIGHTS},
+	"kmv":          {SLOTS},
+	"khf":          {SLOTS},
+	"hyperminhash": {REGISTERS},
+}
+
+// Metric describes a way of comparing a pair of sketches
+type Metric struct {
+	Name    string
+	Reads   [][]string // the representations the metric reads (a sketch must hold all of the representations in one of these sets)
+	Bounded bool       // true if the metric is a distance between 0 and 1 (so it can also be written as a similarity)
+	SetSize bool       // true if the metric is an estimate of a set size rather than a distance
+}
+
+// Metrics is the registry of the metrics available to hulk smash
+var Metrics = []*Metric{
+	{Name: "jaccard", Reads: [][]string{{SLOTS}, {REGISTERS}}, Bounded: true},
+	{Name: "weightedjaccard", Reads: [][]string{{SLOTS, WEIGHTS}}, Bounded: true},
+	{Name: "braycurtis", Reads: [][]string{{WEIGHTS}}, Bounded: true},
+	{Name: "canberra", Reads: [][]string{{WEIGHTS}}},
+	{Name: "euclidean", Reads: [][]string{{WEIGHTS}}},
+	{Name: "union", Reads: [][]string{{REGISTERS}}, SetSize: true},
+	{Name: "intersection", Reads: [][]string{{REGISTERS}}, SetSize: true},
+}
+
+// MetricNames is a function to return the names of the registered metrics
+func MetricNames() []string {
+	names := make([]string, len(Metrics))
+	for i, metric := range Metrics {
+		names[i] = metric.Name
+	}
+	return names
+}
+
+// GetMetric is a function to find a metric in the registry
+func GetMetric(name string) (*Metric, error) {
+	for _, metric := range Metrics {
+		if metric.Name == name {
+			return metric, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown distance metric: %v (please select one of the following: %v)", name, MetricNames())
+}
+
+// Algorithms is a method to return the sketching algorithms that a metric can be used with
+func (Metric *Metric) Algorithms() []string {
+	algos := []string{}
+	for _, algo := range SmashAlgorithms {
+		if Metric.canRead(algo) {
+			algos = append(algos, algo)
+		}
+	}
+	return algos
+}
+
+// CheckAlgorithm is a method to check that a metric can be used with sketches made by a sketching algorithm
+func (Metric *Metric) CheckAlgorithm(algo string) error {
+	representations, ok := sketchRepresentations[algo]
+	if !ok {
+		return fmt.Errorf("%v sketches can't be compared by hulk smash (please select one of the following: %v)", algo, SmashAlgorithms)
+	}
+	if !Metric.canRead(algo) {
+		reads := make([]string, len(Metric.Reads))
+		for i, set := range Metric.Reads {
+			reads[i] = strings.Join(set, " and ")
+		}
+		return fmt.Errorf("the %v metric reads %v, but %v sketches only hold %v (the %v metric can be used with: %v)", Metric.Name, strings.Join(reads, " or "), algo, strings.Join(representations, " and "), Metric.Name, strings.Join(Metric.Algorithms(), ", "))
+	}
+	return nil
+}
+
+// reads is an unexported method to check if the metric reads a representation
+func (Metric *Metric) reads(representation string) bool {
+	for _, set := range Metric.Reads {
+		for _, needed := range set {
+			if needed == representation {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// canRead is an unexported method to check if a sketching algorithm holds all of the representations in one of the sets that the metric reads
+func (Metric *Metric) canRead(algo string) bool {
+	for _, set := range Metric.Reads {
+		held := 0
+		for _, needed := range set {
+			for _, representation := range sketchRepresentations[algo] {
+				if representation == needed {
+					held++
+				}
+			}
+		}
+		if held == len(set) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMetric is a helper function to find a metric and check it can be used with sketches made by a sketching algorithm
+func checkMetric(name, algo string) (*Metric, error) {
+	metric, err := GetMetric(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := metric.CheckAlgorithm(algo); err != nil {
+		return nil, err
+	}
+	return metric, nil
+}
@@ -0,0 +1,66 @@
+package sketchio
+
+import (
+	"testing"
+
+	"github.com/will-rowe/hulk/src/distances"
+)
+
+// test that the metric registry only allows metrics with the sketches that hold what they compare
+func TestMetrics(t *testing.T) {
+	for _, algo := range SmashAlgorithms {
+		if _, ok := sketchRepresentations[algo]; !ok {
+			t.Fatalf("no representations are registered for the %v algorithm", algo)
+		}
+	}
+	tests := []struct {
+		metric string
+		algo   string
+		valid  bool
+	}{
+		{"jaccard", "kmv", true},
+		{"weightedjaccard", "histosketch", true},
+		{"jaccard", "hyperminhash", true},
+		{"weightedjaccard", "kmv", false},
+		{"weightedjaccard", "hyperminhash", false},
+		{"braycurtis", "histosketch", true},
+		{"canberra", "khf", false},
+		{"euclidean", "kmv", false},
+		{"union", "hyperminhash", true},
+		{"union", "histosketch", false},
+		{"jaccard", "spectrum", false},
+	}
+	for _, test := range tests {
+		metric, err := GetMetric(test.metric)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := metric.CheckAlgorithm(test.algo); (err == nil) != test.valid {
+			t.Fatalf("%v metric with %v sketches: expected valid=%v, got %v", test.metric, test.algo, test.valid, err)
+		}
+	}
+	if _, err := GetMetric("cosine"); err == nil {
+		t.Fatal("unknown metric should not be found")
+	}
+
+	// prepared sketches refuse metrics that aren't registered for them
+	sketch := prepareTestSketches(t, testSketches(t, 1), "kmv")[0]
+	if _, err := sketch.GetDistance(sketch, "braycurtis"); err == nil {
+		t.Fatal("braycurtis should not be calculated from the slots of a KMV sketch")
+	}
+	if distance, err := sketch.GetDistance(sketch, "jaccard"); err != nil || distance != 0 {
+		t.Fatalf("identical sketches should have a jaccard distance of 0, got %v (%v)", distance, err)
+	}
+
+	// the abundance metrics are calculated from the histosketch weights
+	hsSketches := prepareTestSketches(t, testSketches(t, 2), "histosketch")
+	for _, metric := range []string{"braycurtis", "canberra", "euclidean"} {
+		expected, err := distances.GetDistance(hsSketches[0].weights, hsSketches[1].weights, metric)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if distance, err := hsSketches[0].GetDistance(hsSketches[1], metric); err != nil || distance != expected {
+			t.Fatalf("%v distance between histosketches is %v (%v), expected %v from their weights", metric, distance, err, expected)
+		}
+	}
+}
@@ -2,29 +2,84 @@
 package sketchio
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"strconv"
 
-	"github.com/will-rowe/hulk/src/distances"
 	"github.com/will-rowe/hulk/src/helpers"
 	"github.com/will-rowe/hulk/src/histosketch"
+	"github.com/will-rowe/hulk/src/kmerspectrum"
 	"github.com/will-rowe/hulk/src/minhash"
+	"github.com/will-rowe/hulk/src/minimizer"
 	"github.com/will-rowe/hulk/src/version"
 )
 
 // AvailAlgorithms is a list of the sketching algorithms currently used by HULK
-var AvailAlgorithms = []string{"histosketch", "kmv", "khf"}
+var AvailAlgorithms = []string{"histosketch", "kmv", "khf", "hyperminhash", "spectrum"}
 
 // HULKdata holds the common information required by any sketching algorithm in this library
 type HULKdata struct {
-	Class      string       `json:"class"`
-	FileName   string       `json:"filename"`
-	HashFunc   string       `json:"hash_function"`
-	License    string       `json:"license"`
-	Signatures []*Signature `json:"signatures"`
-	Version    string       `json:"version"`
-	Banner     string       `json:"banner_label"` // TODO: this entry is to store a label for BANNER (e.g. for training a classifier) - let's change it to a more generic metadata label
+	Class      string                 `json:"class"`
+	FileName   string                 `json:"filename"`
+	HashFunc   string                 `json:"hash_function"`
+	HashSeed   uint64                 `json:"hash_seed"` // the seed used by the hash function
+	Seeder     minimizer.SeederParams `json:"seeder"`    // the settings used to select seeds from the sequences
+	License    string                 `json:"license"`
+	Signatures []*Signature           `json:"signatures"`
+	Version    string                 `json:"version"`
+	Banner     string                 `json:"banner_label"`       // TODO: this entry is to store a label for BANNER (e.g. for training a classifier) - let's change it to a more generic metadata label
+	ReadCount  uint                   `json:"read_count"`         // the number of reads sketched
+	Timestamp  string                 `json:"timestamp"`          // the time at which the sketch was taken (RFC3339)
+	Interval   int                    `json:"interval,omitempty"` // the sketching interval this sketch was taken at (omitted for the final sketch)
+	Metadata   Metadata               `json:"metadata"`           // information about how the sketch was made
+}
+
+// Metadata holds information about the input data and settings used to make a sketch
+type Metadata struct {
+	Pairing     string            `json:"pairing,omitempty"`      // the read pairing mode (single, paired or interleaved)
+	ReadFilters map[string]uint   `json:"read_filters,omitempty"` // the number of sequences dropped by each read filter that was used
+	Sample      string            `json:"sample,omitempty"`       // the sample ID, when sketched from a manifest
+	Attributes  map[string]string `json:"attributes,omitempty"`   // any extra sample information from the manifest
+	Window      *Window           `json:"window,omitempty"`       // the sliding window covered by the sketch (omitted if the sketch covers all the reads)
+	MergedFrom  []*MergeSource    `json:"merged_from,omitempty"`  // the sketches that were merged to make this sketch (omitted if the sketch wasn't merged)
+	CountMin    *CountMin         `json:"count_min,omitempty"`    // the countmin sketch used by the histosketch to estimate the k-mer spectrum bin frequencies
+}
+
+// CountMin describes the countmin sketch used by a histosketch, along with the error bound for its frequency estimates
+type CountMin struct {
+	Width        uint32  `json:"width"`        // the number of counters in each table
+	Depth        uint32  `json:"depth"`        // the number of tables
+	Conservative bool    `json:"conservative"` // true if conservative update was used
+	Epsilon      float64 `json:"epsilon"`      // the relative accuracy of the frequency estimates
+	Delta        float64 `json:"delta"`        // the probability that a frequency estimate is within the error bound
+	Total        float64 `json:"total"`        // the total frequency added to the sketch (after any decay)
+	ErrorBound   float64 `json:"error_bound"`  // a frequency estimate exceeds the true frequency by at most this (epsilon * total), with probability delta
+}
+
+// NewCountMin is a function to describe the countmin sketch used by a histosketch
+func NewCountMin(hs *histosketch.HistoSketch) *CountMin {
+	cms := hs.GetCountMin()
+	return &CountMin{
+		Width:        cms.GetWidth(),
+		Depth:        cms.GetDepth(),
+		Conservative: cms.IsConservative(),
+		Epsilon:      cms.GetEpsilon(),
+		Delta:        cms.GetDelta(),
+		Total:        cms.GetTotal(),
+		ErrorBound:   cms.GetErrorBound(),
+	}
+}
+
+// Window describes the sliding window of reads covered by a windowed sketch
+type Window struct {
+	Reads         uint   `json:"reads,omitempty"`    // the maximum number of reads in the window
+	Duration      string `json:"duration,omitempty"` // the maximum length of time covered by the window
+	FirstInterval int    `json:"first_interval"`     // the first sketching interval in the window
+	LastInterval  int    `json:"last_interval"`      // the last sketching interval in the window
+	ReadCount     uint   `json:"read_count"`         // the number of reads in the window
 }
 
 // Signature contains the sketch and the algorithm by which it was generated
@@ -45,7 +100,7 @@ type SketchObject interface {
 func NewHULKdata() *HULKdata {
 	return &HULKdata{
 		Class:      "hulk_sketch",
-		HashFunc:   "ntHash",
+		HashFunc:   "hash64",
 		License:    "CC0",
 		Signatures: []*Signature{},
 		Version:    version.VERSION,
@@ -96,6 +151,21 @@ func (HULKdata *HULKdata) WriteJSON(outfileName string) error {
 	return nil
 }
 
+// StreamJSON writes a HULKdata to an io.Writer as a single line of JSON (i.e. newline-delimited JSON)
+func (HULKdata *HULKdata) StreamJSON(w io.Writer) error {
+
+	// Make sure it isn't an empty sketch object
+	if len(HULKdata.Signatures) == 0 {
+		return fmt.Errorf("no sketches have been added to the JSON object yet")
+	}
+
+	// Marshall it and write it
+	if err := json.NewEncoder(w).Encode(HULKdata); err != nil {
+		return fmt.Errorf("error streaming JSON: %v", err)
+	}
+	return nil
+}
+
 // LoadHULKdata loads a JSON file from disk into a HULKdata
 func LoadHULKdata(fileName string) (*HULKdata, error) {
 
@@ -110,9 +180,13 @@ func LoadHULKdata(fileName string) (*HULKdata, error) {
 		return nil, err
 	}
 
-	// unmarshal JSON to an interface
+	// unmarshal JSON to an interface, keeping numbers as they were written so that 64 bit hash values don't lose precision
 	var result map[string]interface{}
-	json.Unmarshal(data, &result)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil, fmt.Errorf("could not decode JSON in %v: %v", fileName, err)
+	}
 
 	// grab the easy stuff
 	loadedData := &HULKdata{
@@ -125,6 +199,57 @@ func LoadHULKdata(fileName string) (*HULKdata, error) {
 		Banner:     result["banner_label"].(string),
 	}
 
+	// grab the optional stuff (older sketches won't have these fields)
+	if readCount, ok := result["read_count"].(json.Number); ok {
+		count, err := strconv.ParseUint(readCount.String(), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		loadedData.ReadCount = uint(count)
+	}
+	if timestamp, ok := result["timestamp"].(string); ok {
+		loadedData.Timestamp = timestamp
+	}
+	if interval, ok := result["interval"].(json.Number); ok {
+		intervalID, err := strconv.Atoi(interval.String())
+		if err != nil {
+			return nil, err
+		}
+		loadedData.Interval = intervalID
+	}
+	// sketches made before the hash function was selectable were labelled ntHash but actually used hash64
+	if hashSeed, ok := result["hash_seed"].(json.Number); ok {
+		seed, err := strconv.ParseUint(hashSeed.String(), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		loadedData.HashSeed = seed
+	} else {
+		loadedData.HashFunc = "hash64"
+	}
+
+	// sketches made before the seeder was selectable used minimizers, but the window size wasn't recorded
+	if seeder, ok := result["seeder"].(map[string]interface{}); ok {
+		seederBytes, err := json.Marshal(seeder)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(seederBytes, &loadedData.Seeder); err != nil {
+			return nil, err
+		}
+	} else {
+		loadedData.Seeder.Name = "minimizer"
+	}
+	if metadata, ok := result["metadata"].(map[string]interface{}); ok {
+		metadataBytes, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(metadataBytes, &loadedData.Metadata); err != nil {
+			return nil, err
+		}
+	}
+
 	// get the signatures
 	jsonData := result["signatures"].([]interface{})
 	for _, sigData := range jsonData {
@@ -149,6 +274,11 @@ func LoadHULKdata(fileName string) (*HULKdata, error) {
 		case "histosketch":
 			loadingSketch := &histosketch.HistoSketch{}
 			json.Unmarshal(sketchBytes, loadingSketch)
+
+			// histosketches made before the seed was selectable all used the default seed
+			if _, ok := sketchData["seed"]; !ok {
+				loadingSketch.Seed = histosketch.DISTRIBUTION_SEED
+			}
 			sig.Sketch = loadingSketch
 		case "kmv":
 			loadingSketch := &minhash.KMVsketch{}
@@ -158,6 +288,20 @@ func LoadHULKdata(fileName string) (*HULKdata, error) {
 			loadingSketch := &minhash.KHFsketch{}
 			json.Unmarshal(sketchBytes, loadingSketch)
 			sig.Sketch = loadingSketch
+		case "hyperminhash":
+			loadingSketch := &minhash.HyperMinHash{}
+			json.Unmarshal(sketchBytes, loadingSketch)
+			if err := loadingSketch.Restore(); err != nil {
+				return nil, fmt.Errorf("could not load sketch from %v: %v", fileName, err)
+			}
+			sig.Sketch = loadingSketch
+		case "spectrum":
+			loadingSketch := &kmerspectrum.SavedSpectrum{}
+			json.Unmarshal(sketchBytes, loadingSketch)
+			if err := loadingSketch.Restore(); err != nil {
+				return nil, fmt.Errorf("could not load sketch from %v: %v", fileName, err)
+			}
+			sig.Sketch = loadingSketch
 		}
 
 		// add the populate Signature to the slice
@@ -240,6 +384,16 @@ func (HULKdata *HULKdata) FindSketch(kSize uint, algo string) (SketchObject, err
 			if x.KmerSize == kSize {
 				sketchObjs = append(sketchObjs, sig.Sketch)
 			}
+		case "hyperminhash":
+			var x *minhash.HyperMinHash = sig.Sketch.(*minhash.HyperMinHash)
+			if x.KmerSize == kSize {
+				sketchObjs = append(sketchObjs, sig.Sketch)
+			}
+		case "spectrum":
+			var x *kmerspectrum.SavedSpectrum = sig.Sketch.(*kmerspectrum.SavedSpectrum)
+			if x.KmerSize == kSize {
+				sketchObjs = append(sketchObjs, sig.Sketch)
+			}
 		}
 	}
 
@@ -255,52 +409,96 @@ func (HULKdata *HULKdata) FindSketch(kSize uint, algo string) (SketchObject, err
 	return sketchObjs[0], nil
 }
 
+// CheckHash is a method to check that two HULKdata were made using the same hash function and seed
+func (HULKdata *HULKdata) CheckHash(query *HULKdata) error {
+	if HULKdata.HashFunc != query.HashFunc || HULKdata.HashSeed != query.HashSeed {
+		return fmt.Errorf("sketches were made with different hash functions (%v seed %d vs. %v seed %d)", HULKdata.HashFunc, HULKdata.HashSeed, query.HashFunc, query.HashSeed)
+	}
+	return nil
+}
+
+// CheckSeeder is a method to check that two HULKdata were made using the same seeder settings
+// settings that weren't recorded by older versions of HULK are not checked
+func (HULKdata *HULKdata) CheckSeeder(query *HULKdata) error {
+	a, b := HULKdata.Seeder, query.Seeder
+	if a.Name != b.Name {
+		return fmt.Errorf("sketches were made with different seeders (%v vs. %v)", a.Name, b.Name)
+	}
+	if a.K == 0 || b.K == 0 {
+		return nil
+	}
+	if a != b {
+		return fmt.Errorf("sketches were made with different %v settings (%+v vs. %+v)", a.Name, a, b)
+	}
+	return nil
+}
+
 // GetDistance is a method to calculate a distance metric for two sketch objects
+// the sketches are extracted and compared in the same way as hulk smash (see PreparedSketch.GetDistance)
 func (HULKdata *HULKdata) GetDistance(query *HULKdata, metric string, kSize uint, algo string) (float64, error) {
 
-	// get the sketch objects of requested kSize
-	subjectSketchObj, err := HULKdata.FindSketch(kSize, algo)
+	// sketches can only be compared if their seeds were selected and hashed in the same way
+	if err := HULKdata.CheckHash(query); err != nil {
+		return 0.0, err
+	}
+	if err := HULKdata.CheckSeeder(query); err != nil {
+		return 0.0, err
+	}
+
+	// get the sketch objects of requested kSize, and make sure they can be compared
+	subjectSketch, err := HULKdata.PrepareSketch(kSize, algo)
 	if err != nil {
 		return 0.0, err
 	}
-	querySketchObj, err := query.FindSketch(kSize, algo)
+	querySketch, err := query.PrepareSketch(kSize, algo)
 	if err != nil {
 		return 0.0, err
 	}
-	subjectSketch := subjectSketchObj.GetSketch()
-	querySketch := querySketchObj.GetSketch()
-
-	// check the sketch sizes match
-	if len(subjectSketch) != len(querySketch) {
-		return 0.0, fmt.Errorf("sketch length mismatch: %d vs %d\n", len(subjectSketch), len(querySketch))
+	if err := subjectSketch.CheckCompatible(querySketch); err != nil {
+		return 0.0, err
 	}
 
-	// convert sketches to float64s
-	setA := make([]float64, len(subjectSketch))
-	setB := make([]float64, len(querySketch))
-	for i := 0; i < len(setA); i++ {
-		setA[i] = float64(subjectSketch[i])
-		setB[i] = float64(querySketch[i])
-	}
+	// calculate the distance
+	return subjectSketch.GetDistance(querySketch, metric)
+}
 
-	// run the distance calculation
-	if metric == "weightedjaccard" {
+// GetCardinality is a method to estimate the size of the union or intersection of the k-mers in two sketches
+// only HyperMinHash sketches can estimate set sizes
+func (HULKdata *HULKdata) GetCardinality(query *HULKdata, estimate string, kSize uint, algo string) (float64, error) {
 
-		// only supported by histosketches at the moment
-		var hsA *histosketch.HistoSketch
-		var hsB *histosketch.HistoSketch
-		var ok bool
-		if hsA, ok = subjectSketchObj.(*histosketch.HistoSketch); !ok {
-			return 0.0, fmt.Errorf("weighted jaccard is only supported for histosketches")
-		}
-		if hsB, ok = subjectSketchObj.(*histosketch.HistoSketch); !ok {
-			return 0.0, fmt.Errorf("weighted jaccard is only supported for histosketches")
-		}
+	// sketches can only be compared if their seeds were selected and hashed in the same way
+	if err := HULKdata.CheckHash(query); err != nil {
+		return 0.0, err
+	}
+	if err := HULKdata.CheckSeeder(query); err != nil {
+		return 0.0, err
+	}
 
-		// get the weights and calculate the distance
-		return distances.GetWJD(setA, setB, hsA.SketchWeights, hsB.SketchWeights)
+	// get the HyperMinHash sketches of requested kSize
+	subjectSketchObj, err := HULKdata.FindSketch(kSize, algo)
+	if err != nil {
+		return 0.0, err
+	}
+	querySketchObj, err := query.FindSketch(kSize, algo)
+	if err != nil {
+		return 0.0, err
+	}
+	subjectHMH, ok := subjectSketchObj.(*minhash.HyperMinHash)
+	if !ok {
+		return 0.0, fmt.Errorf("%v estimates are only supported for HyperMinHash sketches", estimate)
+	}
+	queryHMH, ok := querySketchObj.(*minhash.HyperMinHash)
+	if !ok {
+		return 0.0, fmt.Errorf("query sketch is not a HyperMinHash sketch: %v\n", query.FileName)
 	}
 
-	// calculate the distance
-	return distances.GetDistance(setA, setB, metric)
+	// run the estimate
+	switch estimate {
+	case "union":
+		return subjectHMH.UnionCardinality(queryHMH)
+	case "intersection":
+		return subjectHMH.IntersectionCardinality(queryHMH)
+	default:
+		return 0.0, fmt.Errorf("unknown cardinality estimate: %v", estimate)
+	}
 }
@@ -0,0 +1,429 @@
+package sketchio
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/will-rowe/hulk/src/histosketch"
+	"github.com/will-rowe/hulk/src/kmerspectrum"
+	"github.com/will-rowe/hulk/src/minhash"
+	"github.com/will-rowe/hulk/src/minimizer"
+)
+
+// testHULKdata writes a HULKdata containing a KMV sketch of large hash values and returns the file name
+func testHULKdata(t *testing.T, dir, name, hashFunc string, seed uint64) string {
+	kmv := minhash.NewKMVsketch(21, 5)
+	for i := uint64(1); i <= 10; i++ {
+		kmv.AddHash(^uint64(0) - i*12345)
+	}
+	hulkData := NewHULKdata()
+	hulkData.FileName = name
+	hulkData.HashFunc = hashFunc
+	hulkData.HashSeed = seed
+	if err := hulkData.Add(kmv); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(dir, name+".json")
+	if err := hulkData.WriteJSON(fileName); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// test that 64 bit hash values and the hash settings survive writing and loading
+func TestLoadHULKdata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk-sketchio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	loaded, err := LoadHULKdata(testHULKdata(t, dir, "a", "ntHash", ^uint64(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.HashFunc != "ntHash" || loaded.HashSeed != ^uint64(0) {
+		t.Fatalf("hash settings were not loaded: %v %d", loaded.HashFunc, loaded.HashSeed)
+	}
+
+	// sketches made before the hash function was recorded used hash64, whatever their label says
+	data, err := ioutil.ReadFile(testHULKdata(t, dir, "legacy", "ntHash", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte(`"hash_seed": 0,`), nil, 1)
+	legacyFile := filepath.Join(dir, "legacy.json")
+	if err := ioutil.WriteFile(legacyFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := LoadHULKdata(legacyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy.HashFunc != "hash64" {
+		t.Fatalf("legacy sketch should be loaded as hash64, not %v", legacy.HashFunc)
+	}
+}
+
+// test that sketches made with different hash functions or seeds are not compared
+func TestCheckHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk-sketchio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sketches := []*HULKdata{}
+	for _, settings := range []struct {
+		hashFunc string
+		seed     uint64
+	}{{"ntHash", 1}, {"ntHash", 1}, {"ntHash", 2}, {"hash64", 0}} {
+		loaded, err := LoadHULKdata(testHULKdata(t, dir, settings.hashFunc, settings.hashFunc, settings.seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sketches = append(sketches, loaded)
+	}
+	if _, err := sketches[0].GetDistance(sketches[1], "jaccard", 21, "kmv"); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range sketches[2:] {
+		if _, err := sketches[0].GetDistance(query, "jaccard", 21, "kmv"); err == nil {
+			t.Fatal("sketches with different hash settings should not be compared")
+		}
+	}
+}
+
+// test that sketches made with different seeders are not compared, unless the settings weren't recorded
+func TestCheckSeeder(t *testing.T) {
+	minimizerData := &HULKdata{Seeder: minimizer.SeederParams{Name: "minimizer", K: 21, W: 9}}
+	for _, test := range []struct {
+		seeder     minimizer.SeederParams
+		compatible bool
+	}{
+		{minimizer.SeederParams{Name: "minimizer", K: 21, W: 9}, true},
+		{minimizer.SeederParams{Name: "minimizer"}, true}, // an older sketch
+		{minimizer.SeederParams{Name: "minimizer", K: 21, W: 5}, false},
+		{minimizer.SeederParams{Name: "closed-syncmer", K: 21, S: 12}, false},
+	} {
+		err := minimizerData.CheckSeeder(&HULKdata{Seeder: test.seeder})
+		if (err == nil) != test.compatible {
+			t.Fatalf("incorrect compatibility check for %+v: %v", test.seeder, err)
+		}
+	}
+}
+
+// testHistoSketch writes a HULKdata containing a histosketch made with the supplied seed and returns the file name
+func testHistoSketch(t *testing.T, dir, name string, seed int64) string {
+	hs, err := histosketch.NewHistoSketch(3, 10, 64, 1.0, seed, histosketch.CountMinSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for bin := uint64(0); bin < 64; bin++ {
+		hs.AddElement(bin, float64(bin%7+1))
+	}
+	hulkData := NewHULKdata()
+	hulkData.FileName = name
+	if err := hulkData.Add(hs); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(dir, name+".json")
+	if err := hulkData.WriteJSON(fileName); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// test that the histosketch seed is loaded and that histosketches made with different seeds are not compared
+func TestHistoSketchSeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk-sketchio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sketches := []*HULKdata{}
+	for i, seed := range []int64{7, 7, 8} {
+		loaded, err := LoadHULKdata(testHistoSketch(t, dir, fmt.Sprintf("seed-%d", i), seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hs := loaded.Signatures[0].Sketch.(*histosketch.HistoSketch); hs.Seed != seed {
+			t.Fatalf("histosketch seed was not loaded: %d vs. %d", hs.Seed, seed)
+		}
+		sketches = append(sketches, loaded)
+	}
+	if dist, err := sketches[0].GetDistance(sketches[1], "jaccard", 3, "histosketch"); err != nil || dist != 0.0 {
+		t.Fatalf("histosketches made with the same seed should be identical: %v %v", dist, err)
+	}
+	if _, err := sketches[0].GetDistance(sketches[2], "jaccard", 3, "histosketch"); err == nil {
+		t.Fatal("histosketches with different seeds should not be compared")
+	}
+
+	// histosketches made before the seed was recorded used the default seed
+	data, err := ioutil.ReadFile(testHistoSketch(t, dir, "legacy", histosketch.DISTRIBUTION_SEED))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"seed": 1`)) {
+		t.Fatal("histosketch seed was not written")
+	}
+	data = bytes.Replace(data, []byte(`"seed": 1`), []byte(`"unknown": 1`), 1)
+	legacyFile := filepath.Join(dir, "legacy.json")
+	if err := ioutil.WriteFile(legacyFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := LoadHULKdata(legacyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hs := legacy.Signatures[0].Sketch.(*histosketch.HistoSketch); hs.Seed != histosketch.DISTRIBUTION_SEED {
+		t.Fatalf("legacy histosketch should be loaded with the default seed, not %d", hs.Seed)
+	}
+}
+
+// test that sketches are merged with their provenance, and that sketches missing from some of the inputs are skipped
+func TestMergeHULKdata(t *testing.T) {
+	// each lane has the same bins, with the k-mer spectrum saved alongside the histosketch
+	cmSettings := histosketch.CountMinSettings{Width: 128, Depth: 4}
+	newHistoSketch := func() *histosketch.HistoSketch {
+		hs, err := histosketch.NewHistoSketch(21, 10, 64, 1.0, histosketch.DISTRIBUTION_SEED, cmSettings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hs
+	}
+	hulkDatas := []*HULKdata{}
+	for i := 0; i < 3; i++ {
+		hs := newHistoSketch()
+		kmv := minhash.NewKMVsketch(21, 5)
+		bins := []*kmerspectrum.Bin{}
+		for j := uint64(0); j < 10; j++ {
+			hs.AddElement(j, float64(i+1))
+			bins = append(bins, &kmerspectrum.Bin{BinID: int32(j), Frequency: float64(i + 1)})
+			kmv.AddHash(j + uint64(i)*10)
+		}
+		spectrum, err := kmerspectrum.NewSavedSpectrum(21, 64, bins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hulkData := NewHULKdata()
+		hulkData.FileName = fmt.Sprintf("lane-%d.fq,", i)
+		hulkData.ReadCount = 100
+		hulkData.Metadata.Sample = "sample"
+		hulkData.Metadata.ReadFilters = map[string]uint{"minLength": 1}
+		hulkData.Metadata.CountMin = NewCountMin(hs)
+		for _, sketch := range []SketchObject{hs, kmv, spectrum} {
+			if err := hulkData.Add(sketch); err != nil {
+				t.Fatal(err)
+			}
+		}
+		hulkDatas = append(hulkDatas, hulkData)
+	}
+	hulkDatas[0].Add(minhash.NewKHFsketch(21, 5))
+	sketchFiles := []string{"lane-0.json", "lane-1.json", "lane-2.json"}
+	merged, skipped, err := MergeHULKdata(sketchFiles, hulkDatas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Signatures) != 3 || len(skipped) != 1 {
+		t.Fatalf("expected 3 merged sketches and 1 skipped, got %d and %d", len(merged.Signatures), len(skipped))
+	}
+
+	// the merged histosketch is the histosketch of all the lanes together, and it keeps the countmin sketch settings
+	pooled := newHistoSketch()
+	for j := uint64(0); j < 10; j++ {
+		pooled.AddElement(j, 6.0)
+	}
+	mergedHS, err := merged.FindSketch(21, "histosketch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for slot, bin := range mergedHS.(*histosketch.HistoSketch).Sketch {
+		if bin != pooled.Sketch[slot] || mergedHS.(*histosketch.HistoSketch).SketchWeights[slot] != pooled.SketchWeights[slot] {
+			t.Fatalf("merged histosketch differs from a histosketch of all the lanes at slot %d", slot)
+		}
+	}
+	if merged.Metadata.CountMin == nil || merged.Metadata.CountMin.Width != cmSettings.Width || merged.Metadata.CountMin.Depth != cmSettings.Depth || merged.Metadata.CountMin.Total != 60 {
+		t.Fatalf("countmin sketch metadata was not carried through: %+v", merged.Metadata.CountMin)
+	}
+	if merged.ReadCount != 300 || merged.Metadata.Sample != "sample" || merged.Metadata.ReadFilters["minLength"] != 3 {
+		t.Fatalf("runtime info was not merged: %d reads, sample %v, filters %v", merged.ReadCount, merged.Metadata.Sample, merged.Metadata.ReadFilters)
+	}
+	if len(merged.Metadata.MergedFrom) != 3 || merged.Metadata.MergedFrom[2].File != "lane-2.json" {
+		t.Fatalf("merge provenance was not recorded: %+v", merged.Metadata.MergedFrom)
+	}
+	kmv, err := merged.FindSketch(21, "kmv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, hv := range kmv.GetSketch() {
+		if hv != uint64(i) {
+			t.Fatalf("merged KMV sketch is incorrect: %v", kmv.GetSketch())
+		}
+	}
+
+	// a merged sketch contributes its own sources when it is merged again
+	remerged, _, err := MergeHULKdata([]string{"merged.json", "lane-0.json"}, []*HULKdata{merged, hulkDatas[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remerged.Metadata.MergedFrom) != 4 {
+		t.Fatalf("expected 4 merge sources, got %d", len(remerged.Metadata.MergedFrom))
+	}
+
+	// histosketches can't be merged without saved spectra
+	withoutSpectra := []*HULKdata{}
+	for _, hulkData := range hulkDatas {
+		stripped := *hulkData
+		stripped.Signatures = hulkData.Signatures[:2]
+		withoutSpectra = append(withoutSpectra, &stripped)
+	}
+	partial, skipped, err := MergeHULKdata(sketchFiles, withoutSpectra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := partial.FindSketch(21, "histosketch"); err == nil || len(skipped) != 1 {
+		t.Fatalf("histosketches should be skipped without saved spectra: %v", skipped)
+	}
+
+	// sketches made with different hash functions can't be merged
+	hulkDatas[1].HashFunc = "ntHash"
+	if _, _, err := MergeHULKdata(sketchFiles, hulkDatas); err == nil {
+		t.Fatal("sketches with different hash functions should not be merged")
+	}
+}
+
+// test that HyperMinHash sketches can be loaded and used to estimate set sizes
+func TestGetCardinality(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk-sketchio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hulkDatas := []*HULKdata{}
+	for i := uint64(0); i < 2; i++ {
+		hmh := minhash.NewHyperMinHash(21, 256)
+		for j := uint64(0); j < 2000; j++ {
+			hmh.AddHash(j + i*1000)
+		}
+		hulkData := NewHULKdata()
+		hulkData.FileName = fmt.Sprintf("%d.fq,", i)
+		if err := hulkData.Add(hmh); err != nil {
+			t.Fatal(err)
+		}
+		fileName := filepath.Join(dir, fmt.Sprintf("%d.json", i))
+		if err := hulkData.WriteJSON(fileName); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadHULKdata(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hulkDatas = append(hulkDatas, loaded)
+	}
+	for estimate, expected := range map[string]float64{"union": 3000, "intersection": 1000} {
+		cardinality, err := hulkDatas[0].GetCardinality(hulkDatas[1], estimate, 21, "hyperminhash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cardinality < expected*0.8 || cardinality > expected*1.2 {
+			t.Fatalf("incorrect %v estimate: %f (expected %f)", estimate, cardinality, expected)
+		}
+	}
+	if _, err := hulkDatas[0].GetCardinality(hulkDatas[1], "union", 21, "kmv"); err == nil {
+		t.Fatal("expected an error for sketches without a HyperMinHash")
+	}
+}
+
+// test that the weighted jaccard distance uses the weights of both sketches (the query weights used to be ignored)
+func TestWeightedJaccard(t *testing.T) {
+	newHistoSketch := func(weights []float64) *HULKdata {
+		hs, err := histosketch.NewHistoSketch(3, uint(len(weights)), 81, 1.0, histosketch.DISTRIBUTION_SEED, histosketch.CountMinSettings{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range hs.Sketch {
+			hs.Sketch[i] = uint(i)
+		}
+		copy(hs.SketchWeights, weights)
+		hulkData := NewHULKdata()
+		if err := hulkData.Add(hs); err != nil {
+			t.Fatal(err)
+		}
+		return hulkData
+	}
+
+	// the slots all match, so the distance is 1 - sum(min weights) / sum(max weights) = 1 - 4/8
+	subject := newHistoSketch([]float64{1, 1, 1, 1})
+	query := newHistoSketch([]float64{1, 1, 1, 5})
+	for _, pair := range [][2]*HULKdata{{subject, query}, {query, subject}} {
+		distance, err := pair[0].GetDistance(pair[1], "weightedjaccard", 3, "histosketch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if distance != 0.5 {
+			t.Fatalf("weighted jaccard distance is %f, expected 0.5", distance)
+		}
+	}
+
+	// histosketches made with the original sample scheme are still compared
+	for _, hulkData := range []*HULKdata{subject, query} {
+		hulkData.Signatures[0].Sketch.(*histosketch.HistoSketch).SampleScheme = 0
+	}
+	distance, err := subject.GetDistance(query, "weightedjaccard", 3, "histosketch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distance != 0.5 {
+		t.Fatalf("weighted jaccard distance for legacy histosketches is %f, expected 0.5", distance)
+	}
+}
+
+// testSketches is a helper function to get HULKdata holding a histosketch, KMV, KHF and HyperMinHash sketch (and the saved spectrum) of overlapping sets of elements, so that neighbouring samples are the most similar
+func testSketches(t *testing.T, numSamples int) []*HULKdata {
+	hulkDatas := make([]*HULKdata, numSamples)
+	for i := range hulkDatas {
+		hs, err := histosketch.NewHistoSketch(21, 20, 1000, 1.0, histosketch.DISTRIBUTION_SEED, histosketch.CountMinSettings{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		kmv, khf, hmh := minhash.NewKMVsketch(21, 20), minhash.NewKHFsketch(21, 20), minhash.NewHyperMinHash(21, 20)
+		bins := []*kmerspectrum.Bin{}
+		for j := uint64(0); j < 100; j++ {
+			element := uint64(i)*10 + j
+			hs.AddElement(element, 1.0)
+			kmv.AddHash(element)
+			khf.AddHash(element)
+			hmh.AddHash(element)
+			bins = append(bins, &kmerspectrum.Bin{BinID: int32(element), Frequency: float64(j%5 + 1)})
+		}
+		spectrum, err := kmerspectrum.NewSavedSpectrum(21, 1000, bins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hulkData := NewHULKdata()
+		hulkData.FileName = fmt.Sprintf("%d.fq,", i)
+		for _, sketch := range []SketchObject{hs, kmv, khf, hmh, spectrum} {
+			if err := hulkData.Add(sketch); err != nil {
+				t.Fatal(err)
+			}
+		}
+		hulkDatas[i] = hulkData
+	}
+	return hulkDatas
+}
+
+// prepareTestSketches is a helper function to prepare the sketches made by one sketching algorithm
+func prepareTestSketches(t *testing.T, hulkDatas []*HULKdata, algo string) []*PreparedSketch {
+	sketches := make([]*PreparedSketch, len(hulkDatas))
+	for i, hulkData := range hulkDatas {
+		sketch, err := hulkData.PrepareSketch(21, algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sketches[i] = sketch
+	}
+	return sketches
+}
@@ -0,0 +1,386 @@
+package sketchio
+
+/*
+ this part of the package compares every pair of sketches in a collection (i.e. hulk smash)
+
+ each sketch is extracted from its HULKdata and converted once, rather than for every comparison
+ the comparisons are symmetric, so only the upper triangle of the matrix is computed and held, with the rows shared between workers
+ very large collections can be compared in blocks of two tiles of sketches at a time, so that only those tiles need to be held in memory
+ the prepared tiles can be spilled to disk in a compact binary form, so that each sketch file is only read once however many blocks its tile is in
+ the compared blocks can also be spilled, so that only the blocks on and above the diagonal are compared and the blocks below it are filled in by transposing them
+*/
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/will-rowe/hulk/src/distances"
+	"github.com/will-rowe/hulk/src/histosketch"
+	"github.com/will-rowe/hulk/src/minhash"
+)
+
+// PreparedSketch is a sketch that has been extracted from a HULKdata, ready to be compared many times
+type PreparedSketch struct {
+	FileName string       // the sequence file(s) that were sketched
+	algo     string       // the sketching algorithm
+	sketch   SketchObject // the sketch
+	values   []float64    // the sketch converted for the distances package
+	weights  []float64    // the histosketch weights (nil for other sketches)
+}
+
+// CompareFunc is a function that compares a pair of prepared sketches
+type CompareFunc func(subject, query *PreparedSketch) (float64, error)
+
+// PrepareSketch is a method to extract the sketch of the requested k-mer size and sketching algorithm from a HULKdata
+func (HULKdata *HULKdata) PrepareSketch(kSize uint, algo string) (*PreparedSketch, error) {
+	sketchObj, err := HULKdata.FindSketch(kSize, algo)
+	if err != nil {
+		return nil, err
+	}
+	sketch := sketchObj.GetSketch()
+	prepared := &PreparedSketch{
+		FileName: HULKdata.FileName,
+		algo:     algo,
+		sketch:   sketchObj,
+		values:   make([]float64, len(sketch)),
+	}
+	for i, val := range sketch {
+		prepared.values[i] = float64(val)
+	}
+	if hs, ok := sketchObj.(*histosketch.HistoSketch); ok {
+		prepared.weights = hs.SketchWeights
+	}
+	return prepared, nil
+}
+
+// CheckCompatible is a method to check that two prepared sketches can be compared
+// histosketches can only be compared if they used the same consistent weighted samples
+func (PreparedSketch *PreparedSketch) CheckCompatible(query *PreparedSketch) error {
+	if PreparedSketch.algo != query.algo {
+		return fmt.Errorf("can't compare a %v sketch with a %v sketch: %v", PreparedSketch.algo, query.algo, query.FileName)
+	}
+	if subjectHS, ok := PreparedSketch.sketch.(*histosketch.HistoSketch); ok {
+		queryHS, ok := query.sketch.(*histosketch.HistoSketch)
+		if !ok {
+			return fmt.Errorf("query sketch is not a histosketch: %v", query.FileName)
+		}
+		if err := subjectHS.CheckCompatible(queryHS); err != nil {
+			return fmt.Errorf("can't compare %v and %v: %v", PreparedSketch.FileName, query.FileName, err)
+		}
+	}
+	return nil
+}
+
+// GetDistance is a method to calculate a distance metric for two prepared sketches
+// this gives the same distances as HULKdata.GetDistance, but the sketches must already have been checked with CheckCompatible
+// the metric must be registered for the sketching algorithm (see Metrics)
+func (PreparedSketch *PreparedSketch) GetDistance(query *PreparedSketch, metric string) (float64, error) {
+	registered, err := checkMetric(metric, PreparedSketch.algo)
+	if err != nil {
+		return 0.0, err
+	}
+	if registered.SetSize {
+		return 0.0, fmt.Errorf("the %v metric is a set size estimate, not a distance", metric)
+	}
+
+	// MinHash sketches use their own estimators for the jaccard metric
+	if subjectMH, ok := PreparedSketch.sketch.(minhash.MinHash); ok && metric == "jaccard" {
+		queryMH, ok := query.sketch.(minhash.MinHash)
+		if !ok {
+			return 0.0, fmt.Errorf("query sketch is not a MinHash sketch: %v\n", query.FileName)
+		}
+		similarity, err := subjectMH.GetSimilarity(queryMH)
+		return 1.0 - similarity, err
+	}
+
+	// check the sketch sizes match
+	if len(PreparedSketch.values) != len(query.values) {
+		return 0.0, fmt.Errorf("sketch length mismatch: %d vs %d\n", len(PreparedSketch.values), len(query.values))
+	}
+
+	// the remaining sketches are compared slot by slot, using the slot IDs and/or the histosketch weights that the metric reads
+	if registered.reads(WEIGHTS) && (PreparedSketch.weights == nil || query.weights == nil) {
+		return 0.0, fmt.Errorf("the %v metric needs histosketch weights: %v\n", metric, query.FileName)
+	}
+	switch {
+	case registered.reads(SLOTS) && registered.reads(WEIGHTS):
+		return distances.GetWJD(PreparedSketch.values, query.values, PreparedSketch.weights, query.weights)
+	case registered.reads(WEIGHTS):
+		return distances.GetDistance(PreparedSketch.weights, query.weights, metric)
+	}
+	return distances.GetDistance(PreparedSketch.values, query.values, metric)
+}
+
+// GetCardinality is a method to estimate the size of the union or intersection of the k-mers in two prepared HyperMinHash sketches
+func (PreparedSketch *PreparedSketch) GetCardinality(query *PreparedSketch, estimate string) (float64, error) {
+	subjectHMH, ok := PreparedSketch.sketch.(*minhash.HyperMinHash)
+	if !ok {
+		return 0.0, fmt.Errorf("%v estimates are only supported for HyperMinHash sketches", estimate)
+	}
+	queryHMH, ok := query.sketch.(*minhash.HyperMinHash)
+	if !ok {
+		return 0.0, fmt.Errorf("query sketch is not a HyperMinHash sketch: %v\n", query.FileName)
+	}
+	switch estimate {
+	case "union":
+		return subjectHMH.UnionCardinality(queryHMH)
+	case "intersection":
+		return subjectHMH.IntersectionCardinality(queryHMH)
+	default:
+		return 0.0, fmt.Errorf("unknown cardinality estimate: %v", estimate)
+	}
+}
+
+// spilledSketch holds the parts of a prepared sketch that are needed to compare it
+// histosketches only keep the settings that CheckCompatible uses (not the countmin sketch), and the other sketches are kept in their binary form
+type spilledSketch struct {
+	FileName     string
+	Algo         string
+	Values       []float64
+	Weights      []float64
+	Seed         int64  // histosketches only
+	SampleScheme int    // histosketches only
+	State        []byte // the MarshalBinary state of a MinHash or HyperMinHash sketch
+}
+
+// SpillSketches is a function to write a set of prepared sketches to a compact binary file, so that they can be reloaded without re-reading their sketch files
+func SpillSketches(fileName string, sketches []*PreparedSketch) error {
+	spilled := make([]*spilledSketch, len(sketches))
+	for i, sketch := range sketches {
+		spilled[i] = &spilledSketch{
+			FileName: sketch.FileName,
+			Algo:     sketch.algo,
+			Values:   sketch.values,
+			Weights:  sketch.weights,
+		}
+		switch sketchObj := sketch.sketch.(type) {
+		case *histosketch.HistoSketch:
+			spilled[i].Seed = sketchObj.Seed
+			spilled[i].SampleScheme = sketchObj.SampleScheme
+		case encoding.BinaryMarshaler:
+			state, err := sketchObj.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			spilled[i].State = state
+		default:
+			return fmt.Errorf("can't spill a %T sketch to disk", sketchObj)
+		}
+	}
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(fh).Encode(spilled); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// LoadSpilledSketches is a function to read the prepared sketches written by SpillSketches
+func LoadSpilledSketches(fileName string) ([]*PreparedSketch, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	spilled := []*spilledSketch{}
+	if err := gob.NewDecoder(bufio.NewReader(fh)).Decode(&spilled); err != nil {
+		return nil, fmt.Errorf("could not read spilled sketches %v: %v", fileName, err)
+	}
+	sketches := make([]*PreparedSketch, len(spilled))
+	for i, sketch := range spilled {
+		sketches[i] = &PreparedSketch{
+			FileName: sketch.FileName,
+			algo:     sketch.Algo,
+			values:   sketch.Values,
+			weights:  sketch.Weights,
+		}
+		if sketch.Algo == "histosketch" {
+			sketches[i].sketch = &histosketch.HistoSketch{Seed: sketch.Seed, SampleScheme: sketch.SampleScheme}
+			continue
+		}
+		var sketchObj interface {
+			SketchObject
+			encoding.BinaryUnmarshaler
+		}
+		switch sketch.Algo {
+		case "kmv":
+			sketchObj = &minhash.KMVsketch{}
+		case "khf":
+			sketchObj = &minhash.KHFsketch{}
+		case "hyperminhash":
+			sketchObj = &minhash.HyperMinHash{}
+		default:
+			return nil, fmt.Errorf("can't load a spilled %v sketch", sketch.Algo)
+		}
+		if err := sketchObj.UnmarshalBinary(sketch.State); err != nil {
+			return nil, err
+		}
+		sketches[i].sketch = sketchObj
+	}
+	return sketches, nil
+}
+
+// SpillBlock is a function to write a block of comparisons to a compact binary file, so that the mirrored block of a symmetric matrix doesn't need to be compared again
+func SpillBlock(fileName string, block [][]float64) error {
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(fh).Encode(block); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// LoadSpilledBlock is a function to read a block of comparisons written by SpillBlock
+func LoadSpilledBlock(fileName string) ([][]float64, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	block := [][]float64{}
+	if err := gob.NewDecoder(bufio.NewReader(fh)).Decode(&block); err != nil {
+		return nil, fmt.Errorf("could not read spilled block %v: %v", fileName, err)
+	}
+	return block, nil
+}
+
+// Matrix is a symmetric matrix of pairwise comparisons, which only holds the upper triangle (including the diagonal)
+type Matrix struct {
+	size   int
+	values []float64
+}
+
+// NewMatrix is the constructor function
+func NewMatrix(size int) *Matrix {
+	return &Matrix{
+		size:   size,
+		values: make([]float64, size*(size+1)/2),
+	}
+}
+
+// Size is a method to return the number of rows (and columns) in the matrix
+func (Matrix *Matrix) Size() int {
+	return Matrix.size
+}
+
+// Get is a method to return the value for a pair of rows
+func (Matrix *Matrix) Get(i, j int) float64 {
+	return Matrix.values[Matrix.offset(i, j)]
+}
+
+// Set is a method to set the value for a pair of rows (which also sets the mirrored pair)
+func (Matrix *Matrix) Set(i, j int, value float64) {
+	Matrix.values[Matrix.offset(i, j)] = value
+}
+
+// offset is an unexported method to find the position of a pair in the upper triangle
+func (Matrix *Matrix) offset(i, j int) int {
+	if i > j {
+		i, j = j, i
+	}
+	return i*Matrix.size - i*(i-1)/2 + (j - i)
+}
+
+// SmashSketches is a function to compare every pair of sketches, spreading the rows of the matrix across numWorkers go routines
+// each pair is only compared once, with the subject being the sketch that comes first
+func SmashSketches(sketches []*PreparedSketch, compare CompareFunc, numWorkers int) (*Matrix, error) {
+	matrix := NewMatrix(len(sketches))
+	err := parallelRows(len(sketches), numWorkers, func(i int) error {
+		for j := i; j < len(sketches); j++ {
+			value, err := compare(sketches[i], sketches[j])
+			if err != nil {
+				return err
+			}
+			matrix.Set(i, j, value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matrix, nil
+}
+
+// CompareBlock is a function to compare every subject sketch with every query sketch, spreading the subjects across numWorkers go routines
+// it is used to smash a collection in tiles, if the subjects and queries are the same tile then only the upper triangle is compared and it is then mirrored
+func CompareBlock(subjects, queries []*PreparedSketch, sameTile bool, compare CompareFunc, numWorkers int) ([][]float64, error) {
+	block := make([][]float64, len(subjects))
+	err := parallelRows(len(subjects), numWorkers, func(i int) error {
+		block[i] = make([]float64, len(queries))
+		start := 0
+		if sameTile {
+			start = i
+		}
+		for j := start; j < len(queries); j++ {
+			value, err := compare(subjects[i], queries[j])
+			if err != nil {
+				return err
+			}
+			block[i][j] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sameTile {
+		for i := range block {
+			for j := 0; j < i; j++ {
+				block[i][j] = block[j][i]
+			}
+		}
+	}
+	return block, nil
+}
+
+// parallelRows is a helper function to run a function for every row, spreading the rows across numWorkers go routines
+// the rows are handed out one at a time, so that the workers stay busy when the rows take different lengths of time
+// it stops handing out rows once a row returns an error, which is then returned
+func parallelRows(numRows, numWorkers int, fn func(int) error) error {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	rows := make(chan int)
+	errs := make(chan error, numWorkers)
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range rows {
+				if err := fn(i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(rows)
+		for i := 0; i < numRows; i++ {
+			select {
+			case rows <- i:
+			case err := <-errs:
+				errs <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
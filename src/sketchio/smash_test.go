@@ -0,0 +1,150 @@
+package sketchio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// test that smashing prepared sketches in parallel gives the same distances as comparing each pair of HULKdata
+func TestSmashSketches(t *testing.T) {
+	hulkDatas := testSketches(t, 7)
+	for _, algo := range []string{"histosketch", "kmv"} {
+		sketches := prepareTestSketches(t, hulkDatas, algo)
+		compare := func(subject, query *PreparedSketch) (float64, error) {
+			return subject.GetDistance(query, "jaccard")
+		}
+		for _, numWorkers := range []int{1, 3, 10} {
+			matrix, err := SmashSketches(sketches, compare, numWorkers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range hulkDatas {
+				for j := range hulkDatas {
+					expected, err := hulkDatas[i].GetDistance(hulkDatas[j], "jaccard", 21, algo)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if matrix.Get(i, j) != expected {
+						t.Fatalf("%v distance for %d vs. %d with %d workers is %f, expected %f", algo, i, j, numWorkers, matrix.Get(i, j), expected)
+					}
+				}
+			}
+		}
+	}
+
+	// errors from the comparisons are returned
+	failing := func(subject, query *PreparedSketch) (float64, error) {
+		return 0.0, fmt.Errorf("failed")
+	}
+	if _, err := SmashSketches([]*PreparedSketch{{}, {}, {}}, failing, 2); err == nil {
+		t.Fatal("expected the comparison error to be returned")
+	}
+}
+
+// test that comparing a collection in tiles gives the same values as comparing it all at once, and that a spilled block can be transposed to fill the mirrored block
+func TestCompareBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk-sketchio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sketches := prepareTestSketches(t, testSketches(t, 7), "kmv")
+	compare := func(subject, query *PreparedSketch) (float64, error) {
+		return subject.GetDistance(query, "jaccard")
+	}
+	matrix, err := SmashSketches(sketches, compare, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tileSize := range []int{1, 3, 7} {
+		for rowStart := 0; rowStart < len(sketches); rowStart += tileSize {
+			rowEnd := rowStart + tileSize
+			if rowEnd > len(sketches) {
+				rowEnd = len(sketches)
+			}
+			for colStart := 0; colStart < len(sketches); colStart += tileSize {
+				colEnd := colStart + tileSize
+				if colEnd > len(sketches) {
+					colEnd = len(sketches)
+				}
+				block, err := CompareBlock(sketches[rowStart:rowEnd], sketches[colStart:colEnd], rowStart == colStart, compare, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i, row := range block {
+					for j, value := range row {
+						if value != matrix.Get(rowStart+i, colStart+j) {
+							t.Fatalf("tile size %d: block value for %d vs. %d is %f, expected %f", tileSize, rowStart+i, colStart+j, value, matrix.Get(rowStart+i, colStart+j))
+						}
+					}
+				}
+
+				// a spilled block gives the mirrored block when it is transposed
+				blockFile := filepath.Join(dir, "block.gob")
+				if err := SpillBlock(blockFile, block); err != nil {
+					t.Fatal(err)
+				}
+				spilled, err := LoadSpilledBlock(blockFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i, row := range spilled {
+					for j, value := range row {
+						if value != matrix.Get(colStart+j, rowStart+i) {
+							t.Fatalf("tile size %d: spilled block value for %d vs. %d is %f, expected %f", tileSize, colStart+j, rowStart+i, value, matrix.Get(colStart+j, rowStart+i))
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// test that spilled sketches give the same comparisons as the sketches they were prepared from
+func TestSpillSketches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk-sketchio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hulkDatas := testSketches(t, 3)
+	metrics := map[string]string{"histosketch": "weightedjaccard", "kmv": "jaccard", "khf": "jaccard", "hyperminhash": "union"}
+	for algo, metric := range metrics {
+		sketches := prepareTestSketches(t, hulkDatas, algo)
+		spillFile := filepath.Join(dir, algo+".gob")
+		if err := SpillSketches(spillFile, sketches); err != nil {
+			t.Fatal(err)
+		}
+		spilled, err := LoadSpilledSketches(spillFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compare := func(subject, query *PreparedSketch) (float64, error) {
+			if metric == "union" {
+				return subject.GetCardinality(query, metric)
+			}
+			return subject.GetDistance(query, metric)
+		}
+		for i := range sketches {
+			if err := sketches[0].CheckCompatible(spilled[i]); err != nil {
+				t.Fatal(err)
+			}
+			for j := range sketches {
+				expected, err := compare(sketches[i], sketches[j])
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := compare(spilled[i], spilled[j])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != expected {
+					t.Fatalf("spilled %v sketches %d vs. %d give %f, expected %f", algo, i, j, got, expected)
+				}
+			}
+		}
+	}
+}